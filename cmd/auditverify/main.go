@@ -0,0 +1,82 @@
+// auditverify checks an export of the audit log, as returned by /api/v1/audit/Export, offline.
+// It reports entries which were modified, removed or reordered since they were appended.
+//
+//	auditverify -f audit.jsonl [-head <hash of the newest entry>]
+//
+// An export ends at the newest entry when it was taken, entries removed from the end can only be
+// detected by comparing the head printed with the hash of the newest entry of the running service.
+// The exit code is 0 if the chain is intact, 1 if not and 2 if the export could not be read.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"ipam/pkg/audit"
+)
+
+func main() {
+	file := flag.String("f", "-", "the exported audit log, - reads stdin")
+	head := flag.String("head", "", "the expected hash of the newest entry")
+	flag.Parse()
+	os.Exit(run(*file, *head))
+}
+
+func run(file, head string) int {
+	in := os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer f.Close()
+		in = f
+	}
+	// the snapshots of an entry can be large, lines are not limited in length.
+	r := bufio.NewReader(in)
+	v := audit.Verifier{}
+	intact := true
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(b) == 0 {
+			break
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		var e audit.Entry
+		if err := json.Unmarshal(b, &e); err != nil {
+			fmt.Printf("line %d: not an audit entry: %v\n", line, err)
+			intact = false
+			continue
+		}
+		if err := v.Add(e); err != nil {
+			fmt.Printf("line %d: %v\n", line, err)
+			intact = false
+		}
+	}
+	last := v.Head()
+	if last == nil {
+		fmt.Println("no entries")
+		if head != "" {
+			return 1
+		}
+		return 0
+	}
+	fmt.Printf("%d entries, head %d %s\n", v.Entries, last.Seq, last.Hash)
+	if head != "" && head != last.Hash {
+		fmt.Printf("head does not match %s, entries after %d are missing or the chain was replaced\n", head, last.Seq)
+		intact = false
+	}
+	if !intact {
+		return 1
+	}
+	return 0
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"ipam/routers"
+	v1 "ipam/routers/api/v1"
 	"ipam/utils/logging"
 	"ipam/utils/options"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 	"time"
 
 	"github.com/pkg/errors"
@@ -22,6 +24,16 @@ func main() {
 	//初始化log
 
 	logging.ConfigInit()
+	//初始化存储
+	v1.InitStorage(conf)
+	//检查网段一致性后退出
+	if options.Fsck {
+		os.Exit(v1.Fsck(options.Repair))
+	}
+	//启动过期地址回收
+	v1.StartReaper(conf.Lease)
+	//启动回收站清理
+	v1.StartPurger(conf.Archive)
 	s := &http.Server{
 		Addr:           conf.Http.Addr,
 		Handler:        routers.InitRouter(),
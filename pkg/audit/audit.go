@@ -0,0 +1,130 @@
+// Package audit keeps an append-only log of every mutation of prefixes, ips, idcs and notes,
+// with the operator who made it and snapshots of the changed objects before and after.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	goipam "ipam/pkg/ipam"
+)
+
+// ErrNotFound is returned by Store.Get if there is no entry with the given ID.
+var ErrNotFound = errors.New("audit entry not found")
+
+// Entry is a recorded mutation.
+type Entry struct {
+	ID        string    `json:"id" bson:"_id"`
+	Time      time.Time `json:"time" bson:"time"`
+	Operator  string    `json:"operator" bson:"operator"`   // who made the change, see NewContextWithOperator
+	Operation string    `json:"operation" bson:"operation"` // name of the mutating method, e.g. DeletePrefix
+	IDC       string    `json:"idc" bson:"idc"`
+	VRF       string    `json:"vrf" bson:"vrf"`
+	Cidrs     []string  `json:"cidrs" bson:"cidrs"` // the prefixes changed
+	IPs       []string  `json:"ips" bson:"ips"`     // the ips changed
+	Users     []string  `json:"users" bson:"users"` // the users of the changed ips before and after
+	// Args are the arguments of the operation which are not part of the snapshots.
+	Args json.RawMessage `json:"args,omitempty" bson:"args,omitempty"`
+	// Before and After are the JSON of the changed objects, for prefixes an array of them.
+	Before json.RawMessage `json:"before,omitempty" bson:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty" bson:"after,omitempty"`
+	// Error is set if the operation failed, it may have changed some objects nevertheless.
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+	// Seq, PrevHash and Hash chain the entries, they are set by the Store, see Verifier.
+	Seq      int64  `json:"seq" bson:"seq"`
+	PrevHash string `json:"prevhash" bson:"prevhash"`
+	Hash     string `json:"hash" bson:"hash"`
+}
+
+// Query selects entries, empty fields match all entries.
+type Query struct {
+	Cidr string
+	IP   string
+	// User matches the operator and the users of the changed ips.
+	User string
+	From time.Time
+	To   time.Time
+	// Limit is the maximum number of entries returned, the newest ones first. 0 returns all.
+	Limit int
+}
+
+// matches reports whether e is selected by q.
+func (q Query) matches(e Entry) bool {
+	if q.Cidr != "" && !contains(e.Cidrs, q.Cidr) {
+		return false
+	}
+	if q.IP != "" && !contains(e.IPs, q.IP) {
+		return false
+	}
+	if q.User != "" && e.Operator != q.User && !contains(e.Users, q.User) {
+		return false
+	}
+	if !q.From.IsZero() && e.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !e.Time.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// Store keeps the entries, they are never changed once appended.
+type Store interface {
+	// Append stores e as the last entry of the chain, its ID, Seq, PrevHash and Hash are set by the Store.
+	Append(ctx context.Context, e *Entry) error
+	// Get returns the entry with the given ID, ErrNotFound if there is none.
+	Get(ctx context.Context, id string) (*Entry, error)
+	// Query returns the entries selected by q, the newest first.
+	Query(ctx context.Context, q Query) ([]Entry, error)
+	// Walk calls fn for every entry of the chain in the order of Seq, it stops at the first error of fn.
+	Walk(ctx context.Context, fn func(e Entry) error) error
+}
+
+// NewContextWithOperator returns a copy of ctx whose mutations are recorded with operator.
+func NewContextWithOperator(ctx context.Context, operator string) context.Context {
+	return goipam.NewContextWithOperator(ctx, operator)
+}
+
+// OperatorFromContext returns the operator stored in ctx, system if none was set.
+func OperatorFromContext(ctx context.Context) string {
+	return goipam.OperatorFromContext(ctx)
+}
+
+// Record appends an entry of operation on the objects before and after to store.
+// before and after are marshalled to JSON, nil leaves them empty.
+func Record(ctx context.Context, store Store, e Entry, before, after interface{}, err error) error {
+	e.Time = time.Now()
+	e.Operator = OperatorFromContext(ctx)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	var merr error
+	e.Before, merr = marshal(before)
+	if merr != nil {
+		return merr
+	}
+	e.After, merr = marshal(after)
+	if merr != nil {
+		return merr
+	}
+	return store.Append(ctx, &e)
+}
+
+// marshal returns the JSON of v, nil for a nil v.
+func marshal(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
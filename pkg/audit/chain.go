@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The entries of a Store form a hash chain: every entry carries its position Seq, the Hash of
+// the entry before it and its own Hash over all of its fields. Changing an entry changes its Hash,
+// which no longer matches the PrevHash of its successor, a removed entry leaves a gap in Seq.
+
+// hashedEntry is the canonical form of an Entry its Hash is computed from.
+type hashedEntry struct {
+	Seq       int64           `json:"seq"`
+	PrevHash  string          `json:"prevhash"`
+	ID        string          `json:"id"`
+	Time      string          `json:"time"`
+	Operator  string          `json:"operator"`
+	Operation string          `json:"operation"`
+	IDC       string          `json:"idc"`
+	VRF       string          `json:"vrf"`
+	Cidrs     []string        `json:"cidrs"`
+	IPs       []string        `json:"ips"`
+	Users     []string        `json:"users"`
+	Args      json.RawMessage `json:"args"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	Error     string          `json:"error"`
+}
+
+// ComputeHash returns the hex encoded SHA-256 of all fields of e but Hash.
+// Stores keep the time with millisecond precision, it is hashed in UTC.
+func (e Entry) ComputeHash() string {
+	h := hashedEntry{
+		Seq:       e.Seq,
+		PrevHash:  e.PrevHash,
+		ID:        e.ID,
+		Time:      e.Time.UTC().Format(time.RFC3339Nano),
+		Operator:  e.Operator,
+		Operation: e.Operation,
+		IDC:       e.IDC,
+		VRF:       e.VRF,
+		Cidrs:     nonNil(e.Cidrs),
+		IPs:       nonNil(e.IPs),
+		Users:     nonNil(e.Users),
+		Args:      rawOrNull(e.Args),
+		Before:    rawOrNull(e.Before),
+		After:     rawOrNull(e.After),
+		Error:     e.Error,
+	}
+	// json.Marshal compacts the raw messages, the hash does not depend on their formatting.
+	b, err := json.Marshal(h)
+	if err != nil {
+		// only invalid raw messages can not be marshalled, they are hashed as they are.
+		b = []byte(fmt.Sprintf("%#v", h))
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// link appends e to the chain after prev, which is nil for the first entry.
+func (e *Entry) link(prev *Entry) {
+	e.Seq = 1
+	e.PrevHash = ""
+	if prev != nil {
+		e.Seq = prev.Seq + 1
+		e.PrevHash = prev.Hash
+	}
+	e.Time = e.Time.Truncate(time.Millisecond)
+	e.Hash = e.ComputeHash()
+}
+
+// Verifier checks entries read in the order of the chain, e.g. from an export.
+type Verifier struct {
+	prev *Entry
+	// Entries is the number of entries checked.
+	Entries int
+}
+
+// Add checks e and its link to the entry added before.
+// It returns an error describing every inconsistency found, the check goes on with the next entry nevertheless.
+func (v *Verifier) Add(e Entry) error {
+	var problems []string
+	if e.Hash != e.ComputeHash() {
+		problems = append(problems, fmt.Sprintf("entry %d was modified, its hash does not match its content", e.Seq))
+	}
+	switch {
+	case v.prev == nil && e.Seq != 1:
+		problems = append(problems, fmt.Sprintf("the chain starts at entry %d, entries 1 to %d are missing", e.Seq, e.Seq-1))
+	case v.prev == nil && e.PrevHash != "":
+		problems = append(problems, "entry 1 links to a previous entry")
+	case v.prev != nil && e.Seq <= v.prev.Seq:
+		problems = append(problems, fmt.Sprintf("entry %d follows entry %d, entries are duplicated or out of order", e.Seq, v.prev.Seq))
+	case v.prev != nil && e.Seq == v.prev.Seq+2:
+		problems = append(problems, fmt.Sprintf("entry %d is missing", e.Seq-1))
+	case v.prev != nil && e.Seq != v.prev.Seq+1:
+		problems = append(problems, fmt.Sprintf("entries %d to %d are missing", v.prev.Seq+1, e.Seq-1))
+	case v.prev != nil && e.PrevHash != v.prev.Hash:
+		problems = append(problems, fmt.Sprintf("entry %d does not link to entry %d, one of them was modified", e.Seq, v.prev.Seq))
+	}
+	v.prev = &e
+	v.Entries++
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// Head returns the last entry added, nil if none was added.
+// Comparing it with the newest entry of the Store detects entries removed from the end of the chain.
+func (v *Verifier) Head() *Entry {
+	return v.prev
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func rawOrNull(r json.RawMessage) json.RawMessage {
+	if len(r) == 0 {
+		return json.RawMessage("null")
+	}
+	return r
+}
@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	goipam "ipam/pkg/ipam"
+)
+
+var prod = goipam.Namespace{IDC: "bj", VRF: "prod"}
+
+// recordedEntries makes a few changes through an audited Ipamer and returns the entries recorded, in the order of the chain.
+func recordedEntries(t *testing.T) []Entry {
+	t.Helper()
+	store := NewMemory()
+	a := NewIpamer(goipam.New(), store)
+	ctx := NewContextWithOperator(goipam.NewContextWithNamespace(context.Background(), prod), "alice")
+	if _, err := a.NewPrefix(ctx, "10.0.0.0/24", "10.0.0.1", "", 1, prod.VRF, prod.IDC, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	ips, err := a.AcquireIP(ctx, "10.0.0.0/24", goipam.IPDetail{User: "bob"}, 2, goipam.AcquireIPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ReleaseIPFromPrefix(ctx, "10.0.0.0/24", ips[:1]); err != nil {
+		t.Fatal(err)
+	}
+	// failed operations are recorded too
+	if _, err := a.DeletePrefix(ctx, "10.9.0.0/24"); err == nil {
+		t.Fatal("expected the unknown prefix not to be deleted")
+	}
+	var entries []Entry
+	err = store.Walk(ctx, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("got %d entries, want 4", len(entries))
+	}
+	return entries
+}
+
+// verify adds all entries to a Verifier and returns the problems found.
+func verify(entries []Entry) []string {
+	var v Verifier
+	var problems []string
+	for _, e := range entries {
+		if err := v.Add(e); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+func TestVerifyChain(t *testing.T) {
+	entries := recordedEntries(t)
+	if problems := verify(entries); len(problems) != 0 {
+		t.Fatalf("got problems %v in an untouched chain", problems)
+	}
+	for n, e := range entries {
+		if e.Seq != int64(n+1) {
+			t.Errorf("got seq %d for entry %d", e.Seq, n+1)
+		}
+		if e.Operator != "alice" {
+			t.Errorf("got operator %q, want alice", e.Operator)
+		}
+	}
+
+	// an export is verified after a round trip through JSON
+	b, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported []Entry
+	if err := json.Unmarshal(b, &exported); err != nil {
+		t.Fatal(err)
+	}
+	if problems := verify(exported); len(problems) != 0 {
+		t.Fatalf("got problems %v in an exported chain", problems)
+	}
+}
+
+func TestVerifyTamperedChain(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(entries []Entry) []Entry
+		want   string
+	}{
+		{
+			name: "modified",
+			tamper: func(entries []Entry) []Entry {
+				entries[1].Operator = "mallory"
+				return entries
+			},
+			want: "entry 2 was modified",
+		},
+		{
+			name: "modified with its hash",
+			tamper: func(entries []Entry) []Entry {
+				entries[1].Operator = "mallory"
+				entries[1].Hash = entries[1].ComputeHash()
+				return entries
+			},
+			want: "entry 3 does not link to entry 2",
+		},
+		{
+			name: "removed",
+			tamper: func(entries []Entry) []Entry {
+				return append(entries[:1], entries[2:]...)
+			},
+			want: "entry 2 is missing",
+		},
+		{
+			name: "removed first",
+			tamper: func(entries []Entry) []Entry {
+				return entries[1:]
+			},
+			want: "entries 1 to 1 are missing",
+		},
+		{
+			name: "reordered",
+			tamper: func(entries []Entry) []Entry {
+				entries[1], entries[2] = entries[2], entries[1]
+				return entries
+			},
+			want: "entry 2 follows entry 3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := verify(tt.tamper(recordedEntries(t)))
+			if len(problems) == 0 {
+				t.Fatal("tampering not detected")
+			}
+			if !strings.Contains(strings.Join(problems, "; "), tt.want) {
+				t.Errorf("got problems %v, want %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifierHead(t *testing.T) {
+	entries := recordedEntries(t)
+	var v Verifier
+	if v.Head() != nil {
+		t.Fatal("got a head before an entry was added")
+	}
+	// entries removed from the end leave no gap, the head differs from the newest entry of the store
+	for _, e := range entries[:3] {
+		if err := v.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.Head().Hash == entries[3].Hash {
+		t.Error("head matches the newest entry although it was not added")
+	}
+	if v.Entries != 3 {
+		t.Errorf("got %d entries checked, want 3", v.Entries)
+	}
+}
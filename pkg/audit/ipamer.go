@@ -0,0 +1,556 @@
+package audit
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	goipam "ipam/pkg/ipam"
+)
+
+// Ipamer records every mutation made through the wrapped Ipamer in a Store, reads are passed through.
+type Ipamer struct {
+	goipam.Ipamer
+	store Store
+	// OnError is called if an entry can not be recorded, the mutation itself is done already.
+	OnError func(e Entry, err error)
+}
+
+// NewIpamer returns an Ipamer recording the mutations of ipam in store.
+func NewIpamer(ipam goipam.Ipamer, store Store) *Ipamer {
+	return &Ipamer{Ipamer: ipam, store: store}
+}
+
+// change is a mutation while it is recorded.
+type change struct {
+	operation string
+	// cidrs are the prefixes changed, they are snapshotted before and after the mutation.
+	// Prefixes created by the mutation are added to them once it is done.
+	cidrs []string
+	ips   []string
+	args  interface{}
+}
+
+// record runs fn and appends an entry of the change made by it.
+func (a *Ipamer) record(ctx context.Context, c change, fn func(c *change) error) error {
+	before := a.snapshot(ctx, c.cidrs)
+	err := fn(&c)
+	after := a.snapshot(ctx, c.cidrs)
+	namespace := goipam.NamespaceFromContext(ctx)
+	e := Entry{
+		Operation: c.operation,
+		IDC:       namespace.IDC,
+		VRF:       namespace.VRF,
+		Cidrs:     unique(c.cidrs),
+		IPs:       unique(c.ips),
+		Users:     users(c.ips, before, after),
+	}
+	var merr error
+	e.Args, merr = marshal(c.args)
+	if merr == nil {
+		merr = Record(ctx, a.store, e, before, after, err)
+	}
+	if merr != nil && a.OnError != nil {
+		a.OnError(e, merr)
+	}
+	return err
+}
+
+// snapshot returns the existing prefixes of cidrs, nil if there are none.
+func (a *Ipamer) snapshot(ctx context.Context, cidrs []string) []goipam.Prefix {
+	var ps []goipam.Prefix
+	for _, cidr := range unique(cidrs) {
+		if p := a.Ipamer.PrefixFrom(ctx, cidr); p != nil {
+			ps = append(ps, *p)
+		}
+	}
+	return ps
+}
+
+// users returns the users of ips in the prefixes before and after.
+func users(ips []string, before, after []goipam.Prefix) []string {
+	var us []string
+	for _, ps := range [][]goipam.Prefix{before, after} {
+		for _, p := range ps {
+			for _, ip := range ips {
+				if d, ok := p.Ips[ip]; ok && d.User != "" {
+					us = append(us, d.User)
+				}
+			}
+		}
+	}
+	return unique(us)
+}
+
+// unique returns the sorted distinct non empty strings of s.
+func unique(s []string) []string {
+	seen := make(map[string]bool)
+	u := []string{}
+	for _, v := range s {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			u = append(u, v)
+		}
+	}
+	sort.Strings(u)
+	return u
+}
+
+func prefixCidr(p *goipam.Prefix) string {
+	if p == nil {
+		return ""
+	}
+	return p.Cidr
+}
+
+func (a *Ipamer) NewPrefix(ctx context.Context, cidr, gateway, parentCidr string, vlanId int, vrf, idc string, isParent bool, kind string) (*goipam.Prefix, error) {
+	ctx = goipam.NewContextWithNamespace(ctx, goipam.Namespace{IDC: idc, VRF: vrf})
+	var p *goipam.Prefix
+	err := a.record(ctx, change{operation: "NewPrefix"}, func(c *change) error {
+		var err error
+		p, err = a.Ipamer.NewPrefix(ctx, cidr, gateway, parentCidr, vlanId, vrf, idc, isParent, kind)
+		if p != nil {
+			c.cidrs = append(c.cidrs, p.Cidr, p.ParentCidr)
+		}
+		return err
+	})
+	return p, err
+}
+
+func (a *Ipamer) NewPrefixWithOptions(ctx context.Context, cidr, gateway, parentCidr string, vlanId int, vrf, idc string, isParent bool, kind string, opts goipam.PrefixOptions) (*goipam.Prefix, error) {
+	ctx = goipam.NewContextWithNamespace(ctx, goipam.Namespace{IDC: idc, VRF: vrf})
+	c := change{operation: "NewPrefix", args: opts}
+	var p *goipam.Prefix
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		p, err = a.Ipamer.NewPrefixWithOptions(ctx, cidr, gateway, parentCidr, vlanId, vrf, idc, isParent, kind, opts)
+		if p != nil {
+			c.cidrs = append(c.cidrs, p.Cidr, p.ParentCidr)
+		}
+		return err
+	})
+	return p, err
+}
+
+func (a *Ipamer) DeletePrefix(ctx context.Context, cidr string) (*goipam.Prefix, error) {
+	c := change{operation: "DeletePrefix", cidrs: []string{cidr}}
+	if p := a.Ipamer.PrefixFrom(ctx, cidr); p != nil {
+		c.cidrs = append(c.cidrs, p.ParentCidr)
+	}
+	var p *goipam.Prefix
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		p, err = a.Ipamer.DeletePrefix(ctx, cidr)
+		return err
+	})
+	return p, err
+}
+
+func (a *Ipamer) AcquireChildPrefix(ctx context.Context, parentCidr string, length uint8) (*goipam.Prefix, error) {
+	c := change{operation: "AcquireChildPrefix", cidrs: []string{parentCidr}, args: map[string]interface{}{"length": length}}
+	var p *goipam.Prefix
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		p, err = a.Ipamer.AcquireChildPrefix(ctx, parentCidr, length)
+		c.cidrs = append(c.cidrs, prefixCidr(p))
+		return err
+	})
+	return p, err
+}
+
+func (a *Ipamer) AcquireSpecificChildPrefix(ctx context.Context, parentCidr, childCidr string) (*goipam.Prefix, error) {
+	c := change{operation: "AcquireSpecificChildPrefix", cidrs: []string{parentCidr}}
+	var p *goipam.Prefix
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		p, err = a.Ipamer.AcquireSpecificChildPrefix(ctx, parentCidr, childCidr)
+		c.cidrs = append(c.cidrs, prefixCidr(p))
+		return err
+	})
+	return p, err
+}
+
+func (a *Ipamer) AcquireChildPrefixFromPool(ctx context.Context, sel goipam.PoolSelector, length uint8) (*goipam.Prefix, error) {
+	c := change{operation: "AcquireChildPrefixFromPool", args: map[string]interface{}{"selector": sel, "length": length}}
+	var p *goipam.Prefix
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		p, err = a.Ipamer.AcquireChildPrefixFromPool(ctx, sel, length)
+		if p != nil {
+			c.cidrs = append(c.cidrs, p.Cidr, p.ParentCidr)
+		}
+		return err
+	})
+	return p, err
+}
+
+func (a *Ipamer) ReleaseChildPrefix(ctx context.Context, child *goipam.Prefix) error {
+	ctx = goipam.NewContextWithNamespace(ctx, child.Namespace())
+	c := change{operation: "ReleaseChildPrefix", cidrs: []string{child.Cidr, child.ParentCidr}}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.ReleaseChildPrefix(ctx, child)
+	})
+}
+
+func (a *Ipamer) AcquireSpecificIP(ctx context.Context, prefixCidr string, ipDetail goipam.IPDetail, specificIP string, num int) ([]string, error) {
+	c := change{operation: "AcquireSpecificIP", cidrs: []string{prefixCidr}, ips: []string{specificIP}, args: map[string]interface{}{"num": num}}
+	var ips []string
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		ips, err = a.Ipamer.AcquireSpecificIP(ctx, prefixCidr, ipDetail, specificIP, num)
+		c.ips = append(c.ips, ips...)
+		return err
+	})
+	return ips, err
+}
+
+func (a *Ipamer) AcquireIP(ctx context.Context, prefixCidr string, ipDetail goipam.IPDetail, num int, opts goipam.AcquireIPOptions) ([]string, error) {
+	c := change{operation: "AcquireIP", cidrs: []string{prefixCidr}, args: map[string]interface{}{"num": num, "options": opts}}
+	var ips []string
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		ips, err = a.Ipamer.AcquireIP(ctx, prefixCidr, ipDetail, num, opts)
+		c.ips = append(c.ips, ips...)
+		return err
+	})
+	return ips, err
+}
+
+func (a *Ipamer) ReleaseIP(ctx context.Context, ip *goipam.IP) (*goipam.Prefix, error) {
+	c := change{operation: "ReleaseIP", cidrs: []string{ip.ParentPrefix}, ips: []string{ip.IP.String()}}
+	var p *goipam.Prefix
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		p, err = a.Ipamer.ReleaseIP(ctx, ip)
+		return err
+	})
+	return p, err
+}
+
+func (a *Ipamer) ReleaseIPFromPrefix(ctx context.Context, prefixCidr string, ips []string) (*goipam.ReleaseIPRes, error) {
+	c := change{operation: "ReleaseIPFromPrefix", cidrs: []string{prefixCidr}, ips: ips}
+	var res *goipam.ReleaseIPRes
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		res, err = a.Ipamer.ReleaseIPFromPrefix(ctx, prefixCidr, ips)
+		return err
+	})
+	return res, err
+}
+
+func (a *Ipamer) ReleaseExpiredIP(ctx context.Context, prefixCidr, ip string, before time.Time) error {
+	c := change{operation: "ReleaseExpiredIP", cidrs: []string{prefixCidr}, ips: []string{ip}, args: map[string]interface{}{"before": before}}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.ReleaseExpiredIP(ctx, prefixCidr, ip, before)
+	})
+}
+
+// Load is recorded without snapshots, it replaces all prefixes.
+func (a *Ipamer) Load(ctx context.Context, dump string) error {
+	return a.record(ctx, change{operation: "Load"}, func(c *change) error {
+		return a.Ipamer.Load(ctx, dump)
+	})
+}
+
+func (a *Ipamer) SplitPrefix(ctx context.Context, cidr string, bits uint8) ([]*goipam.Prefix, error) {
+	c := change{operation: "SplitPrefix", cidrs: []string{cidr}, args: map[string]interface{}{"bits": bits}}
+	if p := a.Ipamer.PrefixFrom(ctx, cidr); p != nil {
+		c.cidrs = append(c.cidrs, p.ParentCidr)
+	}
+	var pieces []*goipam.Prefix
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		pieces, err = a.Ipamer.SplitPrefix(ctx, cidr, bits)
+		for _, p := range pieces {
+			c.cidrs = append(c.cidrs, p.Cidr)
+		}
+		return err
+	})
+	return pieces, err
+}
+
+func (a *Ipamer) MergePrefixes(ctx context.Context, cidrs []string) (*goipam.Prefix, error) {
+	c := change{operation: "MergePrefixes", cidrs: append([]string(nil), cidrs...)}
+	if len(cidrs) > 0 {
+		if p := a.Ipamer.PrefixFrom(ctx, cidrs[0]); p != nil {
+			c.cidrs = append(c.cidrs, p.ParentCidr)
+		}
+	}
+	var p *goipam.Prefix
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		p, err = a.Ipamer.MergePrefixes(ctx, cidrs)
+		c.cidrs = append(c.cidrs, prefixCidr(p))
+		return err
+	})
+	return p, err
+}
+
+func (a *Ipamer) ResizePrefix(ctx context.Context, cidr string, bits uint8) (*goipam.Prefix, error) {
+	c := change{operation: "ResizePrefix", cidrs: []string{cidr}, args: map[string]interface{}{"bits": bits}}
+	if p := a.Ipamer.PrefixFrom(ctx, cidr); p != nil {
+		c.cidrs = append(c.cidrs, p.ParentCidr)
+	}
+	var p *goipam.Prefix
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		p, err = a.Ipamer.ResizePrefix(ctx, cidr, bits)
+		c.cidrs = append(c.cidrs, prefixCidr(p))
+		return err
+	})
+	return p, err
+}
+
+func (a *Ipamer) ApplyRenumber(ctx context.Context, plan goipam.RenumberPlan) error {
+	c := change{operation: "ApplyRenumber", cidrs: []string{plan.OldCidr, plan.NewCidr}}
+	for _, m := range plan.Mappings {
+		c.ips = append(c.ips, m.Old, m.New)
+	}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.ApplyRenumber(ctx, plan)
+	})
+}
+
+func (a *Ipamer) RetireRenumber(ctx context.Context, oldCidr string) (*goipam.Prefix, error) {
+	c := change{operation: "RetireRenumber", cidrs: []string{oldCidr}}
+	if p := a.Ipamer.PrefixFrom(ctx, oldCidr); p != nil {
+		c.cidrs = append(c.cidrs, p.RenumberTo, p.ParentCidr)
+	}
+	var p *goipam.Prefix
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		p, err = a.Ipamer.RetireRenumber(ctx, oldCidr)
+		return err
+	})
+	return p, err
+}
+
+func (a *Ipamer) CancelRenumber(ctx context.Context, oldCidr string) error {
+	c := change{operation: "CancelRenumber", cidrs: []string{oldCidr}}
+	if p := a.Ipamer.PrefixFrom(ctx, oldCidr); p != nil {
+		c.cidrs = append(c.cidrs, p.RenumberTo)
+	}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.CancelRenumber(ctx, oldCidr)
+	})
+}
+
+func (a *Ipamer) EditPrefixQuarantine(ctx context.Context, prefixCidr, cooldown string) error {
+	c := change{operation: "EditPrefixQuarantine", cidrs: []string{prefixCidr}}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.EditPrefixQuarantine(ctx, prefixCidr, cooldown)
+	})
+}
+
+func (a *Ipamer) ReleaseQuarantined(ctx context.Context, prefixCidr string, ips []string) error {
+	c := change{operation: "ReleaseQuarantined", cidrs: []string{prefixCidr}, ips: ips}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.ReleaseQuarantined(ctx, prefixCidr, ips)
+	})
+}
+
+func (a *Ipamer) RenewIP(ctx context.Context, prefixCidr, ip string, expires time.Time) error {
+	c := change{operation: "RenewIP", cidrs: []string{prefixCidr}, ips: []string{ip}}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.RenewIP(ctx, prefixCidr, ip, expires)
+	})
+}
+
+func (a *Ipamer) EditIPUserFromPrefix(ctx context.Context, prefixCidr string, user string, ips []string) error {
+	c := change{operation: "EditIPUserFromPrefix", cidrs: []string{prefixCidr}, ips: ips}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.EditIPUserFromPrefix(ctx, prefixCidr, user, ips)
+	})
+}
+
+func (a *Ipamer) EditPrefixStrategy(ctx context.Context, prefixCidr string, strategy string) error {
+	c := change{operation: "EditPrefixStrategy", cidrs: []string{prefixCidr}}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.EditPrefixStrategy(ctx, prefixCidr, strategy)
+	})
+}
+
+func (a *Ipamer) EditPrefixTags(ctx context.Context, prefixCidr string, tags []string) error {
+	c := change{operation: "EditPrefixTags", cidrs: []string{prefixCidr}}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.EditPrefixTags(ctx, prefixCidr, tags)
+	})
+}
+
+func (a *Ipamer) AddReservedRange(ctx context.Context, prefixCidr string, r goipam.ReservedRange) error {
+	c := change{operation: "AddReservedRange", cidrs: []string{prefixCidr}}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.AddReservedRange(ctx, prefixCidr, r)
+	})
+}
+
+func (a *Ipamer) DeleteReservedRange(ctx context.Context, prefixCidr string, name string) error {
+	c := change{operation: "DeleteReservedRange", cidrs: []string{prefixCidr}, args: map[string]interface{}{"name": name}}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.DeleteReservedRange(ctx, prefixCidr, name)
+	})
+}
+
+func (a *Ipamer) ApplyTemplate(ctx context.Context, prefixCidr string, t goipam.Template) error {
+	c := change{operation: "ApplyTemplate", cidrs: []string{prefixCidr}, args: t}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.ApplyTemplate(ctx, prefixCidr, t)
+	})
+}
+
+func (a *Ipamer) EditIPDescriptionFromPrefix(ctx context.Context, prefixCidr string, description string, ip string) error {
+	c := change{operation: "EditIPDescriptionFromPrefix", cidrs: []string{prefixCidr}, ips: []string{ip}}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.EditIPDescriptionFromPrefix(ctx, prefixCidr, description, ip)
+	})
+}
+
+func (a *Ipamer) MarkIP(ctx context.Context, prefixCidr string, ipDetail goipam.IPDetail, ips []string) (*goipam.ReleaseIPRes, error) {
+	c := change{operation: "MarkIP", cidrs: []string{prefixCidr}, ips: ips}
+	var res *goipam.ReleaseIPRes
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		res, err = a.Ipamer.MarkIP(ctx, prefixCidr, ipDetail, ips)
+		return err
+	})
+	return res, err
+}
+
+func (a *Ipamer) AcquireLoopback(ctx context.Context, poolCidr string, ipDetail goipam.IPDetail) (*goipam.Prefix, error) {
+	c := change{operation: "AcquireLoopback", cidrs: []string{poolCidr}}
+	var p *goipam.Prefix
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		p, err = a.Ipamer.AcquireLoopback(ctx, poolCidr, ipDetail)
+		c.cidrs = append(c.cidrs, prefixCidr(p))
+		return err
+	})
+	return p, err
+}
+
+func (a *Ipamer) ReleaseLoopback(ctx context.Context, cidr string) error {
+	c := change{operation: "ReleaseLoopback", cidrs: []string{cidr}}
+	if p := a.Ipamer.PrefixFrom(ctx, cidr); p != nil {
+		c.cidrs = append(c.cidrs, p.ParentCidr)
+	}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.ReleaseLoopback(ctx, cidr)
+	})
+}
+
+func (a *Ipamer) RestoreIPs(ctx context.Context, prefixCidr string, ips map[string]goipam.IPDetail) error {
+	c := change{operation: "RestoreIPs", cidrs: []string{prefixCidr}}
+	for ip := range ips {
+		c.ips = append(c.ips, ip)
+	}
+	return a.record(ctx, c, func(c *change) error {
+		return a.Ipamer.RestoreIPs(ctx, prefixCidr, ips)
+	})
+}
+
+func (a *Ipamer) RestorePrefix(ctx context.Context, p goipam.Prefix) (*goipam.Prefix, error) {
+	ctx = goipam.NewContextWithNamespace(ctx, p.Namespace())
+	c := change{operation: "RestorePrefix", cidrs: []string{p.Cidr, p.ParentCidr}}
+	for ip := range p.Ips {
+		c.ips = append(c.ips, ip)
+	}
+	var restored *goipam.Prefix
+	err := a.record(ctx, c, func(c *change) error {
+		var err error
+		restored, err = a.Ipamer.RestorePrefix(ctx, p)
+		return err
+	})
+	return restored, err
+}
+
+// CheckConsistency is only recorded with repair, the prefixes of the findings are snapshotted
+// in the namespace of each of them.
+func (a *Ipamer) CheckConsistency(ctx context.Context, repair bool) (*goipam.ConsistencyReport, error) {
+	if !repair {
+		return a.Ipamer.CheckConsistency(ctx, false)
+	}
+	found, err := a.Ipamer.CheckConsistency(ctx, false)
+	if err != nil || found.Consistent() {
+		return found, err
+	}
+	byNamespace := make(map[goipam.Namespace][]string)
+	for _, f := range found.Findings {
+		n := goipam.Namespace{IDC: f.IDC, VRF: f.VRF}
+		byNamespace[n] = append(byNamespace[n], f.Cidr)
+	}
+	before := make(map[goipam.Namespace][]goipam.Prefix)
+	for n, cidrs := range byNamespace {
+		before[n] = a.snapshot(goipam.NewContextWithNamespace(ctx, n), cidrs)
+	}
+	report, err := a.Ipamer.CheckConsistency(ctx, true)
+	for n, cidrs := range byNamespace {
+		nctx := goipam.NewContextWithNamespace(ctx, n)
+		e := Entry{Operation: "CheckConsistency", IDC: n.IDC, VRF: n.VRF, Cidrs: unique(cidrs)}
+		rerr := Record(nctx, a.store, e, before[n], a.snapshot(nctx, cidrs), err)
+		if rerr != nil && a.OnError != nil {
+			a.OnError(e, rerr)
+		}
+	}
+	return report, err
+}
+
+func (a *Ipamer) RestoreArchivedPrefix(ctx context.Context, id string) (*goipam.Prefix, error) {
+	archived, err := a.Ipamer.ArchivedPrefixes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := change{operation: "RestoreArchivedPrefix", args: map[string]interface{}{"id": id}}
+	for _, ap := range archived {
+		if ap.ID == id {
+			ctx = goipam.NewContextWithNamespace(ctx, ap.Prefix.Namespace())
+			c.cidrs = []string{ap.Prefix.Cidr, ap.Prefix.ParentCidr}
+			for ip := range ap.Prefix.Ips {
+				c.ips = append(c.ips, ip)
+			}
+		}
+	}
+	var restored *goipam.Prefix
+	err = a.record(ctx, c, func(c *change) error {
+		var err error
+		restored, err = a.Ipamer.RestoreArchivedPrefix(ctx, id)
+		return err
+	})
+	return restored, err
+}
+
+// PurgeArchivedPrefixes is recorded once per namespace of the purged prefixes,
+// their archived state is the snapshot before. Nothing is recorded if no prefix was purged.
+func (a *Ipamer) PurgeArchivedPrefixes(ctx context.Context, before time.Time) ([]goipam.ArchivedPrefix, error) {
+	purged, err := a.Ipamer.PurgeArchivedPrefixes(ctx, before)
+	if err != nil {
+		e := Entry{Operation: "PurgeArchivedPrefixes"}
+		rerr := Record(ctx, a.store, e, nil, nil, err)
+		if rerr != nil && a.OnError != nil {
+			a.OnError(e, rerr)
+		}
+		return nil, err
+	}
+	byNamespace := make(map[goipam.Namespace][]goipam.ArchivedPrefix)
+	for _, ap := range purged {
+		n := ap.Prefix.Namespace()
+		byNamespace[n] = append(byNamespace[n], ap)
+	}
+	for n, aps := range byNamespace {
+		e := Entry{Operation: "PurgeArchivedPrefixes", IDC: n.IDC, VRF: n.VRF}
+		ids := make([]string, 0, len(aps))
+		for _, ap := range aps {
+			e.Cidrs = append(e.Cidrs, ap.Prefix.Cidr)
+			ids = append(ids, ap.ID)
+		}
+		e.Cidrs = unique(e.Cidrs)
+		var rerr error
+		e.Args, rerr = marshal(map[string]interface{}{"before": before, "ids": ids})
+		if rerr == nil {
+			rerr = Record(goipam.NewContextWithNamespace(ctx, n), a.store, e, aps, nil, nil)
+		}
+		if rerr != nil && a.OnError != nil {
+			a.OnError(e, rerr)
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+type memory struct {
+	lock    sync.RWMutex
+	entries []Entry
+}
+
+// NewMemory returns a Store keeping the entries in memory.
+func NewMemory() Store {
+	return &memory{}
+}
+
+func (m *memory) Append(ctx context.Context, e *Entry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e.ID = strconv.Itoa(len(m.entries) + 1)
+	var prev *Entry
+	if len(m.entries) > 0 {
+		prev = &m.entries[len(m.entries)-1]
+	}
+	e.link(prev)
+	m.entries = append(m.entries, *e)
+	return nil
+}
+
+func (m *memory) Get(ctx context.Context, id string) (*Entry, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, e := range m.entries {
+		if e.ID == id {
+			return &e, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+func (m *memory) Query(ctx context.Context, q Query) ([]Entry, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	entries := []Entry{}
+	for k := len(m.entries) - 1; k >= 0; k-- {
+		if !q.matches(m.entries[k]) {
+			continue
+		}
+		entries = append(entries, m.entries[k])
+		if q.Limit > 0 && len(entries) == q.Limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+func (m *memory) Walk(ctx context.Context, fn func(e Entry) error) error {
+	m.lock.RLock()
+	entries := m.entries[:len(m.entries):len(m.entries)]
+	m.lock.RUnlock()
+	for _, e := range entries {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	goipam "ipam/pkg/ipam"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongodb struct {
+	c *mongo.Collection
+	// lock serializes the appends of this process, appends of other processes are retried on a duplicate Seq.
+	lock sync.Mutex
+}
+
+// NewMongo returns a Store keeping the entries in the collection of config.
+func NewMongo(ctx context.Context, config goipam.MongoConfig) (Store, error) {
+	m, err := mongo.NewClient(config.MongoClientOptions)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Ping(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := m.Database(config.DatabaseName).Collection(config.CollectionName)
+	_, err = c.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "cidrs", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "ips", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "operator", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "users", Value: 1}, {Key: "time", Value: -1}}},
+		// entries recorded before the chain existed have no seq, they are not part of it.
+		{Keys: bson.D{{Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"seq": bson.M{"$gt": 0}})},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mongodb{c: c}, nil
+}
+
+func (m *mongodb) Append(ctx context.Context, e *Entry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e.ID = primitive.NewObjectID().Hex()
+	for {
+		prev, err := m.last(ctx)
+		if err != nil {
+			return err
+		}
+		e.link(prev)
+		_, err = m.c.InsertOne(ctx, e)
+		if err == nil {
+			return nil
+		}
+		// another instance appended an entry with the same seq in the meantime.
+		if !mongo.IsDuplicateKeyError(err) || ctx.Err() != nil {
+			return fmt.Errorf("unable to append audit entry: %w", err)
+		}
+	}
+}
+
+// last returns the last entry of the chain, nil if there is none.
+func (m *mongodb) last(ctx context.Context) (*Entry, error) {
+	o := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})
+	r := m.c.FindOne(ctx, chainFilter(), o)
+	if errors.Is(r.Err(), mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	var e Entry
+	if err := r.Decode(&e); err != nil {
+		return nil, fmt.Errorf(`error reading last audit entry: %w`, err)
+	}
+	return &e, nil
+}
+
+func (m *mongodb) Walk(ctx context.Context, fn func(e Entry) error) error {
+	o := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	c, err := m.c.Find(ctx, chainFilter(), o)
+	if err != nil {
+		return fmt.Errorf(`error reading audit entries: %w`, err)
+	}
+	defer c.Close(ctx)
+	for c.Next(ctx) {
+		var e Entry
+		if err := c.Decode(&e); err != nil {
+			return fmt.Errorf(`error reading audit entry: %w`, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return c.Err()
+}
+
+// chainFilter matches the entries of the chain.
+func chainFilter() bson.D {
+	return bson.D{{Key: "seq", Value: bson.M{"$gt": 0}}}
+}
+
+func (m *mongodb) Get(ctx context.Context, id string) (*Entry, error) {
+	r := m.c.FindOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if errors.Is(r.Err(), mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	var e Entry
+	if err := r.Decode(&e); err != nil {
+		return nil, fmt.Errorf(`error reading audit entry %s: %w`, id, err)
+	}
+	return &e, nil
+}
+
+func (m *mongodb) Query(ctx context.Context, q Query) ([]Entry, error) {
+	f := bson.D{}
+	if q.Cidr != "" {
+		f = append(f, bson.E{Key: "cidrs", Value: q.Cidr})
+	}
+	if q.IP != "" {
+		f = append(f, bson.E{Key: "ips", Value: q.IP})
+	}
+	if q.User != "" {
+		f = append(f, bson.E{Key: "$or", Value: bson.A{bson.M{"operator": q.User}, bson.M{"users": q.User}}})
+	}
+	t := bson.M{}
+	if !q.From.IsZero() {
+		t["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		t["$lt"] = q.To
+	}
+	if len(t) > 0 {
+		f = append(f, bson.E{Key: "time", Value: t})
+	}
+	o := options.Find().SetSort(bson.D{{Key: "time", Value: -1}, {Key: "_id", Value: -1}})
+	if q.Limit > 0 {
+		o.SetLimit(int64(q.Limit))
+	}
+	c, err := m.c.Find(ctx, f, o)
+	if err != nil {
+		return nil, fmt.Errorf(`error reading audit entries: %w`, err)
+	}
+	entries := []Entry{}
+	if err := c.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf(`error reading audit entries: %w`, err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,188 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	goipam "ipam/pkg/ipam"
+)
+
+// ErrConflict is returned by Undo if the operation was followed by changes of the same ips or prefixes.
+var ErrConflict = errors.New("conflicting changes")
+
+// UndoPlan is the inverse change of a recorded operation.
+type UndoPlan struct {
+	// Entry is the operation undone.
+	Entry Entry `json:"entry"`
+	// Cidr is the prefix whose ips are acquired again or which is created again.
+	Cidr string `json:"cidr"`
+	// IPs are the released ips, they are acquired again with the IPDetail they had before.
+	IPs map[string]goipam.IPDetail `json:"ips,omitempty"`
+	// Prefix is the deleted prefix, it is created again.
+	Prefix *goipam.Prefix `json:"prefix,omitempty"`
+	// ArchiveID is the archived prefix restored for the deleted prefix, it is empty if the prefix was purged
+	// from the archive already and is created again from the snapshot.
+	ArchiveID string `json:"archiveid,omitempty"`
+	// Conflicts are the later entries which changed the same ips or prefixes, the oldest first.
+	// The undo is refused if there are any.
+	Conflicts []Entry `json:"conflicts"`
+	// Done is set once the inverse change is made.
+	Done bool `json:"done"`
+}
+
+// Undo reverts the operation recorded in the entry with the given id from the snapshot taken before it.
+// Ips released by ReleaseIP, ReleaseIPFromPrefix or ReleaseExpiredIP are acquired again with their former IPDetail,
+// a prefix deleted by DeletePrefix is restored from the archive, or created again if it was purged from it.
+// The undo itself is recorded as operation Undo.
+// It is refused with ErrConflict if a later entry changed the same ips or prefixes,
+// with dryRun only the plan is returned.
+func (a *Ipamer) Undo(ctx context.Context, id string, dryRun bool) (*UndoPlan, error) {
+	e, err := a.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if e.Error != "" {
+		return nil, fmt.Errorf("operation %s of entry %s failed, it can not be undone", e.Operation, id)
+	}
+	var before, after []goipam.Prefix
+	if len(e.Before) > 0 {
+		if err := json.Unmarshal(e.Before, &before); err != nil {
+			return nil, fmt.Errorf("unable to read snapshot of entry %s: %w", id, err)
+		}
+	}
+	if len(e.After) > 0 {
+		if err := json.Unmarshal(e.After, &after); err != nil {
+			return nil, fmt.Errorf("unable to read snapshot of entry %s: %w", id, err)
+		}
+	}
+	plan := &UndoPlan{Entry: *e, Conflicts: []Entry{}}
+	switch e.Operation {
+	case "ReleaseIP", "ReleaseIPFromPrefix", "ReleaseExpiredIP":
+		if len(before) == 0 {
+			return nil, fmt.Errorf("entry %s has no snapshot of the prefix", id)
+		}
+		p := before[0]
+		var q goipam.Prefix
+		for _, s := range after {
+			if s.Cidr == p.Cidr {
+				q = s
+			}
+		}
+		plan.Cidr = p.Cidr
+		plan.IPs = make(map[string]goipam.IPDetail)
+		for _, ip := range e.IPs {
+			if d, ok := p.Ips[ip]; ok {
+				if _, still := q.Ips[ip]; !still {
+					plan.IPs[ip] = d
+				}
+			}
+		}
+		if len(plan.IPs) == 0 {
+			return nil, fmt.Errorf("entry %s released no ip", id)
+		}
+	case "DeletePrefix":
+		for k := range before {
+			deleted := true
+			for _, s := range after {
+				if s.Cidr == before[k].Cidr {
+					deleted = false
+				}
+			}
+			if deleted {
+				plan.Prefix = &before[k]
+			}
+		}
+		if plan.Prefix == nil {
+			return nil, fmt.Errorf("entry %s has no snapshot of the deleted prefix", id)
+		}
+		plan.Cidr = plan.Prefix.Cidr
+		archived, err := a.Ipamer.ArchivedPrefixes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// a later deletion of the same prefix is a conflict, the latest archived one is the one deleted by e.
+		for _, ap := range archived {
+			if ap.Prefix.Cidr == plan.Cidr && ap.Prefix.IDC == e.IDC && ap.Prefix.VRF == e.VRF {
+				plan.ArchiveID = ap.ID
+				break
+			}
+		}
+	default:
+		return nil, fmt.Errorf("undo of %s is not supported", e.Operation)
+	}
+
+	later, err := a.store.Query(ctx, Query{From: e.Time})
+	if err != nil {
+		return nil, err
+	}
+	for k := len(later) - 1; k >= 0; k-- {
+		l := later[k]
+		if l.ID == e.ID || (l.Seq > 0 && e.Seq > 0 && l.Seq < e.Seq) {
+			continue
+		}
+		if l.IDC == e.IDC && l.VRF == e.VRF && plan.conflicts(l) {
+			plan.Conflicts = append(plan.Conflicts, l)
+		}
+	}
+	if len(plan.Conflicts) > 0 {
+		return plan, fmt.Errorf("%w: %d later changes of the same ips or prefixes, entry %s can not be undone", ErrConflict, len(plan.Conflicts), id)
+	}
+	if dryRun {
+		return plan, nil
+	}
+
+	ctx = goipam.NewContextWithNamespace(ctx, goipam.Namespace{IDC: e.IDC, VRF: e.VRF})
+	c := change{operation: "Undo", cidrs: []string{plan.Cidr}, args: map[string]interface{}{"entry": id, "operation": e.Operation}}
+	for ip := range plan.IPs {
+		c.ips = append(c.ips, ip)
+	}
+	if plan.Prefix != nil {
+		c.cidrs = append(c.cidrs, plan.Prefix.ParentCidr)
+		for ip := range plan.Prefix.Ips {
+			c.ips = append(c.ips, ip)
+		}
+	}
+	err = a.record(ctx, c, func(c *change) error {
+		if plan.ArchiveID != "" {
+			_, err := a.Ipamer.RestoreArchivedPrefix(ctx, plan.ArchiveID)
+			return err
+		}
+		if plan.Prefix != nil {
+			_, err := a.Ipamer.RestorePrefix(ctx, *plan.Prefix)
+			return err
+		}
+		return a.Ipamer.RestoreIPs(ctx, plan.Cidr, plan.IPs)
+	})
+	if err != nil {
+		return plan, err
+	}
+	plan.Done = true
+	return plan, nil
+}
+
+// conflicts reports whether the later entry l changed the ips restored by the plan,
+// or for a deleted prefix, its cidr or a prefix inside of it.
+func (plan *UndoPlan) conflicts(l Entry) bool {
+	if plan.Prefix == nil {
+		for _, ip := range l.IPs {
+			if _, ok := plan.IPs[ip]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	deleted, err := netip.ParsePrefix(plan.Cidr)
+	if err != nil {
+		return true
+	}
+	for _, cidr := range l.Cidrs {
+		p, err := netip.ParsePrefix(cidr)
+		if err == nil && p.Bits() >= deleted.Bits() && deleted.Contains(p.Addr()) {
+			return true
+		}
+	}
+	return false
+}
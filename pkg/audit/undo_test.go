@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	goipam "ipam/pkg/ipam"
+)
+
+// newAuditedIpamer returns an audited Ipamer with the prefix 10.0.0.0/24 and the ips acquired in it for bob.
+func newAuditedIpamer(t *testing.T) (*Ipamer, context.Context, []string) {
+	t.Helper()
+	a := NewIpamer(goipam.New(), NewMemory())
+	ctx := NewContextWithOperator(goipam.NewContextWithNamespace(context.Background(), prod), "alice")
+	if _, err := a.NewPrefix(ctx, "10.0.0.0/24", "10.0.0.1", "", 1, prod.VRF, prod.IDC, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	ips, err := a.AcquireIP(ctx, "10.0.0.0/24", goipam.IPDetail{User: "bob", Description: "web"}, 2, goipam.AcquireIPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, ctx, ips
+}
+
+// lastEntry returns the newest entry of the Store of a.
+func lastEntry(t *testing.T, ctx context.Context, a *Ipamer) Entry {
+	t.Helper()
+	entries, err := a.store.Query(ctx, Query{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatal("no entry recorded")
+	}
+	return entries[0]
+}
+
+func TestUndoReleaseIP(t *testing.T) {
+	a, ctx, ips := newAuditedIpamer(t)
+	if _, err := a.ReleaseIPFromPrefix(ctx, "10.0.0.0/24", ips); err != nil {
+		t.Fatal(err)
+	}
+	released := lastEntry(t, ctx, a)
+
+	plan, err := a.Undo(ctx, released.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Done || len(plan.IPs) != 2 {
+		t.Fatalf("got dry run plan %+v, want the 2 released ips not done", plan)
+	}
+	if p := a.PrefixFrom(ctx, "10.0.0.0/24"); len(p.Ips) != 3 {
+		t.Fatal("dry run changed the prefix")
+	}
+
+	plan, err = a.Undo(ctx, released.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Done {
+		t.Fatal("undo not done")
+	}
+	p := a.PrefixFrom(ctx, "10.0.0.0/24")
+	for _, ip := range ips {
+		if d, ok := p.Ips[ip]; !ok || d.User != "bob" || d.Description != "web" {
+			t.Errorf("got %s with %+v after the undo, want it acquired for bob", ip, d)
+		}
+	}
+	undo := lastEntry(t, ctx, a)
+	if undo.Operation != "Undo" || undo.Operator != "alice" {
+		t.Errorf("got %s by %s recorded, want Undo by alice", undo.Operation, undo.Operator)
+	}
+	// the ips are acquired again, undoing the release twice conflicts with the undo
+	if _, err := a.Undo(ctx, released.ID, false); !errors.Is(err, ErrConflict) {
+		t.Errorf("got %v undoing twice, want %v", err, ErrConflict)
+	}
+}
+
+func TestUndoConflict(t *testing.T) {
+	a, ctx, ips := newAuditedIpamer(t)
+	if _, err := a.ReleaseIPFromPrefix(ctx, "10.0.0.0/24", ips[:1]); err != nil {
+		t.Fatal(err)
+	}
+	released := lastEntry(t, ctx, a)
+	if _, err := a.AcquireSpecificIP(ctx, "10.0.0.0/24", goipam.IPDetail{User: "carol"}, ips[0], 1); err != nil {
+		t.Fatal(err)
+	}
+	acquired := lastEntry(t, ctx, a)
+
+	plan, err := a.Undo(ctx, released.ID, false)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("got %v, want %v", err, ErrConflict)
+	}
+	if len(plan.Conflicts) != 1 || plan.Conflicts[0].ID != acquired.ID {
+		t.Errorf("got conflicts %v, want the acquisition %s", plan.Conflicts, acquired.ID)
+	}
+	if d := a.PrefixFrom(ctx, "10.0.0.0/24").Ips[ips[0]]; d.User != "carol" {
+		t.Errorf("got %s acquired for %q, want carol", ips[0], d.User)
+	}
+}
+
+func TestUndoDeletePrefix(t *testing.T) {
+	a, ctx, ips := newAuditedIpamer(t)
+	if err := a.EditPrefixTags(ctx, "10.0.0.0/24", []string{"web"}); err != nil {
+		t.Fatal(err)
+	}
+	// a prefix with acquired ips can not be deleted
+	if _, err := a.ReleaseIPFromPrefix(ctx, "10.0.0.0/24", ips); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.DeletePrefix(ctx, "10.0.0.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	deleted := lastEntry(t, ctx, a)
+
+	plan, err := a.Undo(ctx, deleted.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.ArchiveID == "" {
+		t.Error("prefix not restored from the archive")
+	}
+	p := a.PrefixFrom(ctx, "10.0.0.0/24")
+	if p == nil {
+		t.Fatal("prefix not restored")
+	}
+	if len(p.Tags) != 1 || p.Tags[0] != "web" || p.Gateway != "10.0.0.1" {
+		t.Errorf("got tags %v and gateway %s, want the ones before the deletion", p.Tags, p.Gateway)
+	}
+	archived, err := a.ArchivedPrefixes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 0 {
+		t.Errorf("got %d archived prefixes after the undo, want 0", len(archived))
+	}
+}
+
+func TestUndoRefused(t *testing.T) {
+	a, ctx, _ := newAuditedIpamer(t)
+	acquired := lastEntry(t, ctx, a)
+	if _, err := a.Undo(ctx, acquired.ID, true); err == nil {
+		t.Error("expected the undo of AcquireIP to be refused")
+	}
+	if _, err := a.DeletePrefix(ctx, "10.0.0.0/24"); err == nil {
+		t.Fatal("expected a prefix with acquired ips not to be deleted")
+	}
+	failed := lastEntry(t, ctx, a)
+	if _, err := a.Undo(ctx, failed.ID, true); err == nil {
+		t.Error("expected the undo of a failed operation to be refused")
+	}
+	if _, err := a.Undo(ctx, "unknown", true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+}
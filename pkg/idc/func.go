@@ -47,6 +47,10 @@ func GetIDC() (idcs []IDC) {
 
 // 获取IDC
 func GetIDCINFO() {
+	// 启动时连接数据库失败
+	if cli == nil {
+		return
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 	cur, cErr := cli.c.Find(ctx, bson.D{})
@@ -0,0 +1,80 @@
+package ipam
+
+import (
+	crand "crypto/rand"
+	"math/big"
+	"net/netip"
+
+	"go4.org/netipx"
+)
+
+// addrInt returns ip as integer, IPv4 addresses in their IPv4-mapped IPv6 form.
+func addrInt(ip netip.Addr) *big.Int {
+	a := ip.As16()
+	return new(big.Int).SetBytes(a[:])
+}
+
+// intAddr is the inverse of addrInt, is4 selects the IPv4 form of the result.
+func intAddr(n *big.Int, is4 bool) netip.Addr {
+	var a [16]byte
+	n.FillBytes(a[:])
+	ip := netip.AddrFrom16(a)
+	if is4 {
+		return ip.Unmap()
+	}
+	return ip
+}
+
+// addrOffset returns the distance of ip from the first address of ipnet.
+func addrOffset(ipnet netip.Prefix, ip netip.Addr) *big.Int {
+	return new(big.Int).Sub(addrInt(ip), addrInt(ipnet.Masked().Addr()))
+}
+
+// addrAt returns the address with the given offset from the first address of ipnet.
+func addrAt(ipnet netip.Prefix, offset *big.Int) netip.Addr {
+	n := new(big.Int).Add(addrInt(ipnet.Masked().Addr()), offset)
+	return intAddr(n, ipnet.Addr().Is4())
+}
+
+// prefixSize returns the number of addresses in ipnet.
+func prefixSize(ipnet netip.Prefix) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(ipnet.Addr().BitLen()-ipnet.Bits()))
+}
+
+// rangeSize returns the number of addresses in r.
+func rangeSize(r netipx.IPRange) *big.Int {
+	n := new(big.Int).Sub(addrInt(r.To()), addrInt(r.From()))
+	return n.Add(n, big.NewInt(1))
+}
+
+// setSize returns the number of addresses in set.
+func setSize(set *netipx.IPSet) *big.Int {
+	total := new(big.Int)
+	for _, r := range set.Ranges() {
+		total.Add(total, rangeSize(r))
+	}
+	return total
+}
+
+// nthAddr returns the n-th address of set counting from zero.
+func nthAddr(set *netipx.IPSet, n *big.Int) netip.Addr {
+	n = new(big.Int).Set(n)
+	for _, r := range set.Ranges() {
+		size := rangeSize(r)
+		if n.Cmp(size) >= 0 {
+			n.Sub(n, size)
+			continue
+		}
+		return intAddr(n.Add(n, addrInt(r.From())), r.From().Is4())
+	}
+	return netip.Addr{}
+}
+
+// randomInt returns a uniform random number in [0, n).
+func randomInt(n *big.Int) *big.Int {
+	r, err := crand.Int(crand.Reader, n)
+	if err != nil {
+		return new(big.Int)
+	}
+	return r
+}
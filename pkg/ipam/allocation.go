@@ -0,0 +1,199 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"ipam/utils/tools"
+	"net/netip"
+	"time"
+
+	"go4.org/netipx"
+)
+
+// childPlaceholderDescription was written into the Ips of a prefix for every address covered by
+// a child subnet before the allocation index existed, these entries carry no information and
+// are dropped when such a prefix is read.
+const childPlaceholderDescription = "子网段使用"
+
+// reservationOperator is the operator and user of the addresses reserved when a prefix is created.
+const reservationOperator = "networkman"
+
+// Descriptions of the addresses reserved when a prefix is created.
+const (
+	gatewayDescription   = "网关地址"
+	networkDescription   = "网络地址"
+	broadcastDescription = "广播地址"
+)
+
+// reservation returns the IPDetail of an address reserved when a prefix is created.
+func reservation(description string) IPDetail {
+	return IPDetail{Operator: reservationOperator, User: reservationOperator, Description: description, Date: tools.DateToString()}
+}
+
+// isReservation reports whether detail belongs to an address reserved when a prefix is created
+// or by a Template.
+func isReservation(detail IPDetail) bool {
+	return detail.Operator == reservationOperator && detail.User == reservationOperator
+}
+
+// allocatedSet returns the acquired addresses of the prefix, never nil.
+func (p *Prefix) allocatedSet() *netipx.IPSet {
+	if p.allocated == nil {
+		return &netipx.IPSet{}
+	}
+	return p.allocated
+}
+
+// Acquired reports whether ip is in use, either with an IPDetail in Ips or covered by a child subnet.
+func (p *Prefix) Acquired(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return p.allocatedSet().Contains(addr)
+}
+
+// acquire marks ips as used and stores detail for each of them.
+func (p *Prefix) acquire(detail IPDetail, ips ...netip.Addr) {
+	if p.Ips == nil {
+		p.Ips = make(map[string]IPDetail)
+	}
+	var b netipx.IPSetBuilder
+	b.AddSet(p.allocatedSet())
+	for _, ip := range ips {
+		b.Add(ip)
+		p.Ips[ip.String()] = detail
+	}
+	p.allocated, _ = b.IPSet()
+}
+
+// acquireRange marks all addresses of r as used without storing any detail.
+func (p *Prefix) acquireRange(r netipx.IPRange) {
+	var b netipx.IPSetBuilder
+	b.AddSet(p.allocatedSet())
+	b.AddRange(r)
+	p.allocated, _ = b.IPSet()
+}
+
+// release marks ips as free again and drops their details and request ids.
+func (p *Prefix) release(ips ...netip.Addr) {
+	var b netipx.IPSetBuilder
+	b.AddSet(p.allocatedSet())
+	for _, ip := range ips {
+		b.Remove(ip)
+		delete(p.Ips, ip.String())
+	}
+	p.allocated, _ = b.IPSet()
+	p.forgetRequests(ips...)
+}
+
+// persistIPs writes the ips acquired or changed in prefix. Storages implementing IPStorage only
+// write these ips, with exclusive an OptimisticLockError is returned if one of them was acquired
+// in the meantime. All other storages write the whole prefix.
+func (i *ipamer) persistIPs(ctx context.Context, prefix *Prefix, ips []string, exclusive bool) error {
+	s, ok := i.storage.(IPStorage)
+	if !ok {
+		_, err := i.storage.UpdatePrefix(ctx, *prefix)
+		return err
+	}
+	if exclusive {
+		return s.CreateIPs(ctx, *prefix, ips)
+	}
+	return s.UpdateIPs(ctx, *prefix, ips)
+}
+
+// persistRelease releases ips in prefix and writes the result.
+func (i *ipamer) persistRelease(ctx context.Context, prefix *Prefix, ips []netip.Addr) error {
+	now := time.Now()
+	if _, ok := i.storage.(IPStorage); !ok {
+		prefix.release(ips...)
+		prefix.quarantine(ips, now)
+		_, err := i.storage.UpdatePrefix(ctx, *prefix)
+		return err
+	}
+	keys := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		keys = append(keys, ip.String())
+	}
+	// addresses acquired without an IPDetail, request ids and the quarantine are kept in the prefix itself,
+	// they are written in the transaction deleting the ips.
+	err := i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		s, ok := tx.storage.(IPStorage)
+		if !ok {
+			return fmt.Errorf("transaction of storage %s does not store ips", tx.storage.Name())
+		}
+		err := s.DeleteIPs(ctx, *prefix, keys)
+		if err != nil {
+			return err
+		}
+		stored, err := tx.storage.ReadPrefix(ctx, prefix.Cidr, prefix.Namespace())
+		if err != nil {
+			return err
+		}
+		var structural []netip.Addr
+		for _, ip := range ips {
+			if stored.allocatedSet().Contains(ip) {
+				structural = append(structural, ip)
+			}
+		}
+		cooldown, _ := ParseQuarantine(stored.Quarantine)
+		requests := stored.forgetRequests(ips...)
+		if len(structural) == 0 && cooldown == 0 && !requests {
+			return nil
+		}
+		stored.release(structural...)
+		stored.quarantine(ips, now)
+		_, err = tx.storage.UpdatePrefix(ctx, stored)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	prefix.release(ips...)
+	prefix.quarantine(ips, now)
+	return nil
+}
+
+// encodeAllocated returns the ranges of set in their string notation.
+func encodeAllocated(set *netipx.IPSet) []string {
+	ranges := []string{}
+	if set == nil {
+		return ranges
+	}
+	for _, r := range set.Ranges() {
+		ranges = append(ranges, r.String())
+	}
+	return ranges
+}
+
+// decodeAllocated parses the ranges written by encodeAllocated.
+func decodeAllocated(ranges []string) *netipx.IPSet {
+	var b netipx.IPSetBuilder
+	for _, r := range ranges {
+		ipr, err := netipx.ParseIPRange(r)
+		if err != nil {
+			continue
+		}
+		b.AddRange(ipr)
+	}
+	set, _ := b.IPSet()
+	return set
+}
+
+// migrateAllocated builds the allocation index of a prefix stored before it existed
+// from the keys of ips, child subnet placeholders are removed from ips.
+func migrateAllocated(ips map[string]IPDetail) *netipx.IPSet {
+	var b netipx.IPSetBuilder
+	for ip, detail := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		b.Add(addr)
+		if detail.Description == childPlaceholderDescription {
+			delete(ips, ip)
+		}
+	}
+	set, _ := b.IPSet()
+	return set
+}
@@ -0,0 +1,170 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ArchivedPrefix is a deleted Prefix kept in the archive of the Storage until it is restored or purged.
+type ArchivedPrefix struct {
+	ID        string    `json:"id"`
+	Prefix    Prefix    `json:"prefix"`
+	DeletedBy string    `json:"deletedby"`
+	DeletedAt time.Time `json:"deletedat"`
+}
+
+type operatorContextKey struct{}
+
+// NewContextWithOperator returns a copy of ctx whose mutations are made on behalf of operator.
+func NewContextWithOperator(ctx context.Context, operator string) context.Context {
+	return context.WithValue(ctx, operatorContextKey{}, operator)
+}
+
+// OperatorFromContext returns the operator stored in ctx, system if none was set.
+func OperatorFromContext(ctx context.Context) string {
+	operator, _ := ctx.Value(operatorContextKey{}).(string)
+	if operator == "" {
+		return "system"
+	}
+	return operator
+}
+
+// archivePrefix keeps the deleted prefix in the archive if the storage has one.
+func (i *ipamer) archivePrefix(ctx context.Context, prefix Prefix) error {
+	s, ok := i.storage.(ArchiveStorage)
+	if !ok {
+		return nil
+	}
+	_, err := s.ArchivePrefix(ctx, ArchivedPrefix{Prefix: prefix, DeletedBy: OperatorFromContext(ctx), DeletedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("unable to archive prefix:%s %w", prefix.Cidr, err)
+	}
+	return nil
+}
+
+func (i *ipamer) archive() (ArchiveStorage, error) {
+	s, ok := i.storage.(ArchiveStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage %s keeps no archive of deleted prefixes", i.storage.Name())
+	}
+	return s, nil
+}
+
+func (i *ipamer) ArchivedPrefixes(ctx context.Context) ([]ArchivedPrefix, error) {
+	s, err := i.archive()
+	if err != nil {
+		return nil, err
+	}
+	return s.ReadArchivedPrefixes(ctx)
+}
+
+// RestoreArchivedPrefix creates the archived prefix again and removes it from the archive.
+// It is refused if the prefix or a prefix inside of it was created since it was deleted,
+// or if its parent changed.
+func (i *ipamer) RestoreArchivedPrefix(ctx context.Context, id string) (*Prefix, error) {
+	s, err := i.archive()
+	if err != nil {
+		return nil, err
+	}
+	a, err := s.ReadArchivedPrefix(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	ctx = NewContextWithNamespace(ctx, a.Prefix.Namespace())
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var prefix *Prefix
+	err = i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		s, err := tx.archive()
+		if err != nil {
+			return err
+		}
+		// the archived prefix may have been restored or purged while waiting for the lock.
+		a, err := s.ReadArchivedPrefix(ctx, id)
+		if err != nil {
+			return err
+		}
+		prefix, err = tx.restorePrefixInternal(ctx, a.Prefix)
+		if err != nil {
+			return err
+		}
+		return s.DeleteArchivedPrefix(ctx, id)
+	})
+	return prefix, err
+}
+
+// PurgeArchivedPrefixes removes the prefixes deleted before the given time from the archive for good.
+// The archived prefixes of a namespace are purged under its lock, so a concurrent restore either
+// restores a prefix before it is purged or finds it purged.
+func (i *ipamer) PurgeArchivedPrefixes(ctx context.Context, before time.Time) ([]ArchivedPrefix, error) {
+	s, err := i.archive()
+	if err != nil {
+		return nil, err
+	}
+	archived, err := s.ReadArchivedPrefixes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var namespaces []Namespace
+	expired := make(map[Namespace][]string)
+	for _, a := range archived {
+		if !a.DeletedAt.Before(before) {
+			continue
+		}
+		namespace := a.Prefix.Namespace()
+		if _, ok := expired[namespace]; !ok {
+			namespaces = append(namespaces, namespace)
+		}
+		expired[namespace] = append(expired[namespace], a.ID)
+	}
+	var purged []ArchivedPrefix
+	for _, namespace := range namespaces {
+		p, err := i.purgeArchivedPrefixes(NewContextWithNamespace(ctx, namespace), expired[namespace])
+		if err != nil {
+			return purged, err
+		}
+		purged = append(purged, p...)
+	}
+	return purged, nil
+}
+
+// purgeArchivedPrefixes removes the archived prefixes with the given ids of the namespace in ctx,
+// ids restored or purged meanwhile are skipped.
+func (i *ipamer) purgeArchivedPrefixes(ctx context.Context, ids []string) ([]ArchivedPrefix, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var purged []ArchivedPrefix
+	err = i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		purged = nil
+		s, err := tx.archive()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			a, err := s.ReadArchivedPrefix(ctx, id)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := s.DeleteArchivedPrefix(ctx, id); err != nil {
+				return err
+			}
+			purged = append(purged, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
@@ -0,0 +1,136 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreArchivedPrefix(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/16", "", true, "")
+	ctx := NewContextWithOperator(NewContextWithNamespace(context.Background(), prod), "alice")
+	if _, err := i.AcquireSpecificChildPrefix(ctx, "10.0.0.0/16", "10.0.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.EditPrefixTags(ctx, "10.0.1.0/24", []string{"web"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.DeletePrefix(ctx, "10.0.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := i.ArchivedPrefixes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 1 || archived[0].Prefix.Cidr != "10.0.1.0/24" || archived[0].DeletedBy != "alice" {
+		t.Fatalf("got archive %+v, want 10.0.1.0/24 deleted by alice", archived)
+	}
+
+	// a prefix inside of the archived one was created since
+	if _, err := i.AcquireSpecificChildPrefix(ctx, "10.0.0.0/16", "10.0.1.128/25"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.RestoreArchivedPrefix(ctx, archived[0].ID); err == nil {
+		t.Fatal("expected the restore to be refused while a prefix inside of it exists")
+	}
+	if _, err := i.DeletePrefix(ctx, "10.0.1.128/25"); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := i.RestoreArchivedPrefix(ctx, archived[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ParentCidr != "10.0.0.0/16" || len(p.Tags) != 1 || p.Tags[0] != "web" {
+		t.Errorf("got parent %q and tags %v, want the ones before the deletion", p.ParentCidr, p.Tags)
+	}
+	archived, err = i.ArchivedPrefixes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the /25 is left in the archive
+	if len(archived) != 1 || archived[0].Prefix.Cidr != "10.0.1.128/25" {
+		t.Fatalf("got archive %+v after the restore, want only 10.0.1.128/25", archived)
+	}
+	if _, err := i.RestoreArchivedPrefix(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestRestoreArchivedLoopback(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.255.0.0/24", "", false, KindLoopbackPool)
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	host, err := i.AcquireLoopback(ctx, "10.255.0.0/24", IPDetail{User: "r1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.ReleaseLoopback(ctx, host.Cidr); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := i.ArchivedPrefixes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 1 || archived[0].Prefix.Cidr != host.Cidr {
+		t.Fatalf("got archive %+v, want the released host %s", archived, host.Cidr)
+	}
+
+	if _, err := i.RestoreArchivedPrefix(ctx, archived[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	pool := i.PrefixFrom(ctx, "10.255.0.0/24")
+	if pool.IsParent {
+		t.Error("loopback pool became a parent prefix")
+	}
+	if d, ok := pool.Ips[strings.TrimSuffix(host.Cidr, "/32")]; !ok || d.User != "r1" {
+		t.Errorf("address of the restored host is not acquired in the pool for r1, got %+v", d)
+	}
+	r, err := i.CheckConsistency(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Findings) != 0 {
+		t.Errorf("got findings %v after the restore", r.Findings)
+	}
+}
+
+func TestPurgeArchivedPrefixes(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/24", "10.0.0.1", false, "")
+	newTestPrefix(t, i, mgmt, "10.0.0.0/24", "10.0.0.1", false, "")
+	for _, namespace := range []Namespace{prod, mgmt} {
+		if _, err := i.DeletePrefix(NewContextWithNamespace(context.Background(), namespace), "10.0.0.0/24"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+
+	purged, err := i.PurgeArchivedPrefixes(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 0 {
+		t.Fatalf("got %d prefixes purged which were deleted after the given time", len(purged))
+	}
+	purged, err = i.PurgeArchivedPrefixes(ctx, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 2 {
+		t.Fatalf("got %d prefixes purged, want the 2 of both namespaces", len(purged))
+	}
+	archived, err := i.ArchivedPrefixes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 0 {
+		t.Errorf("got %d archived prefixes after the purge, want 0", len(archived))
+	}
+	if _, err := i.RestoreArchivedPrefix(ctx, purged[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v restoring a purged prefix, want %v", err, ErrNotFound)
+	}
+}
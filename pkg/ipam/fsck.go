@@ -0,0 +1,354 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+
+	"go4.org/netipx"
+)
+
+// Kinds of the findings of CheckConsistency.
+const (
+	// FindingMissingChild is a child prefix listed by its parent which does not exist.
+	FindingMissingChild = "missing-child"
+	// FindingChildLink is a child prefix which does not point back to the parent listing it,
+	// or which points to a parent not listing it.
+	FindingChildLink = "child-link"
+	// FindingOverlap is a prefix enclosed by another prefix without being linked into its tree,
+	// or a cidr stored more than once.
+	FindingOverlap = "overlap"
+	// FindingIPOutside is an ip of a prefix which is not inside its cidr.
+	FindingIPOutside = "ip-outside"
+	// FindingMissingReservation is a gateway, network or broadcast address which is not acquired.
+	FindingMissingReservation = "missing-reservation"
+	// FindingLegacyChildLength is a prefix still carrying the childPrefixLength of go-ipam.
+	FindingLegacyChildLength = "legacy-child-length"
+)
+
+// Finding is an inconsistency of a stored prefix found by CheckConsistency.
+type Finding struct {
+	IDC        string `json:"idc"`
+	VRF        string `json:"vrf"`
+	Cidr       string `json:"cidr"`
+	Kind       string `json:"kind"`
+	Detail     string `json:"detail"`
+	Repairable bool   `json:"repairable"`
+	Repaired   bool   `json:"repaired"`
+	// repair fixes the finding with the ipamer of a transaction, nil if it needs manual work.
+	repair func(ctx context.Context, tx *ipamer) error
+}
+
+// ConsistencyReport is the result of CheckConsistency.
+type ConsistencyReport struct {
+	Prefixes int       `json:"prefixes"`
+	Findings []Finding `json:"findings"`
+}
+
+// Consistent reports whether no finding is left unrepaired.
+func (r *ConsistencyReport) Consistent() bool {
+	for _, f := range r.Findings {
+		if !f.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteText writes the report with one line per finding.
+func (r *ConsistencyReport) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%d prefixes checked, %d findings\n", r.Prefixes, len(r.Findings))
+	if err != nil {
+		return err
+	}
+	for _, f := range r.Findings {
+		state := "manual"
+		switch {
+		case f.Repaired:
+			state = "repaired"
+		case f.Repairable:
+			state = "repairable"
+		}
+		_, err = fmt.Fprintf(w, "%s/%s %s %s: %s [%s]\n", f.IDC, f.VRF, f.Cidr, f.Kind, f.Detail, state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckConsistency verifies the prefixes of all namespaces as read from the storage: the links between
+// parents and child prefixes, that prefixes do not overlap outside of the tree, that all ips are inside
+// their prefix, that the reserved addresses are acquired and that legacy fields are migrated.
+// With repair the repairable findings of a namespace are fixed in one transaction while holding its lock.
+func (i *ipamer) CheckConsistency(ctx context.Context, repair bool) (*ConsistencyReport, error) {
+	all, err := i.storage.ReadAllPrefixes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byNamespace := make(map[Namespace]Prefixes)
+	for _, p := range all {
+		byNamespace[p.Namespace()] = append(byNamespace[p.Namespace()], p)
+	}
+	namespaces := make([]Namespace, 0, len(byNamespace))
+	for n := range byNamespace {
+		namespaces = append(namespaces, n)
+	}
+	sort.Slice(namespaces, func(a, b int) bool {
+		return namespaces[a].String() < namespaces[b].String()
+	})
+	report := &ConsistencyReport{Prefixes: len(all), Findings: []Finding{}}
+	for _, n := range namespaces {
+		findings := i.checkNamespace(byNamespace[n])
+		if repair && len(findings) > 0 {
+			findings, err = i.repairNamespace(NewContextWithNamespace(ctx, n), n)
+			if err != nil {
+				return nil, fmt.Errorf("unable to repair namespace %s: %w", n, err)
+			}
+		}
+		report.Findings = append(report.Findings, findings...)
+	}
+	return report, nil
+}
+
+// repairNamespace checks the namespace n again under its lock and repairs its findings.
+func (i *ipamer) repairNamespace(ctx context.Context, n Namespace) ([]Finding, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var findings []Finding
+	err = i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		ps, err := tx.namespacePrefixes(ctx, n)
+		if err != nil {
+			return err
+		}
+		findings = tx.checkNamespace(ps)
+		for k := range findings {
+			if findings[k].repair == nil {
+				continue
+			}
+			err = findings[k].repair(ctx, tx)
+			if err != nil {
+				return fmt.Errorf("%s of %s: %w", findings[k].Kind, findings[k].Cidr, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for k := range findings {
+		findings[k].Repaired = findings[k].Repairable
+	}
+	return findings, nil
+}
+
+// checkNamespace returns the findings of the prefixes ps of one namespace.
+func (i *ipamer) checkNamespace(ps Prefixes) []Finding {
+	var findings []Finding
+	add := func(p *Prefix, kind, detail string, repair func(ctx context.Context, tx *ipamer) error) {
+		findings = append(findings, Finding{
+			IDC: p.IDC, VRF: p.VRF, Cidr: p.Cidr, Kind: kind, Detail: detail,
+			Repairable: repair != nil, repair: repair,
+		})
+	}
+	sort.Slice(ps, func(a, b int) bool {
+		return ps[a].Cidr < ps[b].Cidr
+	})
+	byCidr := make(map[string]*Prefix)
+	for k := range ps {
+		p := &ps[k]
+		if _, ok := byCidr[p.Cidr]; ok {
+			add(p, FindingOverlap, "cidr is stored more than once", nil)
+			continue
+		}
+		byCidr[p.Cidr] = p
+	}
+	// the parent of every prefix is the nearest prefix enclosing it.
+	parents := make(map[string]string)
+	for cidr := range byCidr {
+		ipnet, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+		for bits := ipnet.Bits() - 1; bits >= 0; bits-- {
+			super := netip.PrefixFrom(ipnet.Addr(), bits).Masked().String()
+			if _, ok := byCidr[super]; ok {
+				parents[cidr] = super
+				break
+			}
+		}
+	}
+	for k := range ps {
+		p := &ps[k]
+		if byCidr[p.Cidr] != p {
+			continue
+		}
+		cidr := p.Cidr
+		for _, child := range sortedChildren(p) {
+			child := child
+			c, ok := byCidr[child]
+			switch {
+			case !ok:
+				add(p, FindingMissingChild, fmt.Sprintf("child prefix %s does not exist", child),
+					func(ctx context.Context, tx *ipamer) error {
+						return tx.modifyPrefix(ctx, cidr, func(p *Prefix) error {
+							delete(p.availableChildPrefixes, child)
+							return nil
+						})
+					})
+			case parents[child] != cidr:
+				add(p, FindingChildLink, fmt.Sprintf("child prefix %s has parent %q", child, c.ParentCidr),
+					func(ctx context.Context, tx *ipamer) error {
+						return tx.modifyPrefix(ctx, cidr, func(p *Prefix) error {
+							delete(p.availableChildPrefixes, child)
+							return nil
+						})
+					})
+			}
+		}
+		parent := parents[cidr]
+		if p.ParentCidr != parent {
+			kind, detail := FindingOverlap, fmt.Sprintf("prefix is enclosed by %s but has parent %q", parent, p.ParentCidr)
+			if parent == "" {
+				kind, detail = FindingChildLink, fmt.Sprintf("parent %s does not exist or does not enclose the prefix", p.ParentCidr)
+			}
+			add(p, kind, detail, func(ctx context.Context, tx *ipamer) error {
+				return tx.modifyPrefix(ctx, cidr, func(p *Prefix) error {
+					p.ParentCidr = parent
+					return nil
+				})
+			})
+		}
+		if parent != "" {
+			if available, ok := byCidr[parent].availableChildPrefixes[cidr]; !ok || available {
+				add(p, FindingChildLink, fmt.Sprintf("parent %s does not list the prefix as child", parent),
+					func(ctx context.Context, tx *ipamer) error {
+						return tx.modifyPrefix(ctx, parent, func(p *Prefix) error {
+							if p.availableChildPrefixes == nil {
+								p.availableChildPrefixes = make(map[string]bool)
+							}
+							p.availableChildPrefixes[cidr] = false
+							if p.Kind != KindLoopbackPool {
+								p.IsParent = true
+							}
+							return nil
+						})
+					})
+			}
+		}
+		i.checkPrefix(p, add)
+	}
+	return findings
+}
+
+// sortedChildren returns the child prefixes in use of p.
+func sortedChildren(p *Prefix) []string {
+	var children []string
+	for child, available := range p.availableChildPrefixes {
+		if !available {
+			children = append(children, child)
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// checkPrefix adds the findings of the ips and fields of p.
+func (i *ipamer) checkPrefix(p *Prefix, add func(p *Prefix, kind, detail string, repair func(ctx context.Context, tx *ipamer) error)) {
+	cidr := p.Cidr
+	ipnet, err := netip.ParsePrefix(p.Cidr)
+	if err != nil {
+		add(p, FindingIPOutside, fmt.Sprintf("cidr can not be parsed: %v", err), nil)
+		return
+	}
+	var outside []string
+	for ip := range p.Ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !ipnet.Contains(addr) {
+			outside = append(outside, ip)
+		}
+	}
+	if len(outside) > 0 {
+		sort.Strings(outside)
+		add(p, FindingIPOutside, fmt.Sprintf("ips %v are not inside the prefix", outside),
+			func(ctx context.Context, tx *ipamer) error {
+				return tx.dropIPs(ctx, cidr, outside)
+			})
+	}
+	if p.childPrefixLength > 0 {
+		add(p, FindingLegacyChildLength, fmt.Sprintf("childPrefixLength %d is not migrated", p.childPrefixLength),
+			func(ctx context.Context, tx *ipamer) error {
+				return tx.modifyPrefix(ctx, cidr, func(p *Prefix) error {
+					p.childPrefixLength = 0
+					p.IsParent = true
+					return nil
+				})
+			})
+	}
+	expected, err := i.newPrefix(p.Cidr, p.Gateway, p.VlanID, p.VRF, p.IDC, "", p.IsParent, p.Kind)
+	if err != nil {
+		add(p, FindingMissingReservation, fmt.Sprintf("reserved addresses can not be computed: %v", err), nil)
+		return
+	}
+	var missing []string
+	for ip := range expected.Ips {
+		if !p.Acquired(ip) {
+			missing = append(missing, ip)
+		}
+	}
+	sort.Strings(missing)
+	for _, ip := range missing {
+		ip, detail := ip, expected.Ips[ip]
+		add(p, FindingMissingReservation, fmt.Sprintf("%s %s is not acquired", detail.Description, ip),
+			func(ctx context.Context, tx *ipamer) error {
+				return tx.reserveIP(ctx, cidr, ip, detail)
+			})
+	}
+}
+
+// dropIPs removes the keys ips of the prefix cidr without releasing them into the quarantine.
+func (i *ipamer) dropIPs(ctx context.Context, cidr string, ips []string) error {
+	p := i.PrefixFrom(ctx, cidr)
+	if p == nil {
+		return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, cidr)
+	}
+	var b netipx.IPSetBuilder
+	b.AddSet(p.allocatedSet())
+	for _, ip := range ips {
+		delete(p.Ips, ip)
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			b.Remove(addr)
+		}
+	}
+	p.allocated, _ = b.IPSet()
+	if s, ok := i.storage.(IPStorage); ok {
+		err := s.DeleteIPs(ctx, *p, ips)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := i.storage.UpdatePrefix(ctx, *p)
+	return err
+}
+
+// reserveIP acquires the reserved address ip of the prefix cidr with detail if it is still free.
+func (i *ipamer) reserveIP(ctx context.Context, cidr, ip string, detail IPDetail) error {
+	p := i.PrefixFrom(ctx, cidr)
+	if p == nil {
+		return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, cidr)
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return err
+	}
+	if p.allocatedSet().Contains(addr) {
+		return nil
+	}
+	p.acquire(detail, addr)
+	return i.persistIPs(ctx, p, []string{ip}, true)
+}
@@ -0,0 +1,105 @@
+package ipam
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestCheckConsistencyOfNewTree(t *testing.T) {
+	i := New()
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	newTestPrefix(t, i, prod, "10.0.0.0/16", "", true, "")
+	newTestPrefix(t, i, prod, "10.0.1.0/24", "10.0.1.1", false, "")
+	if _, err := i.AcquireChildPrefix(ctx, "10.0.0.0/16", 24); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.AcquireIP(ctx, "10.0.1.0/24", IPDetail{User: "a"}, 3, AcquireIPOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	newTestPrefix(t, i, prod, "10.1.0.0/31", "", false, KindPointToPoint)
+	newTestPrefix(t, i, prod, "10.255.0.0/24", "", false, KindLoopbackPool)
+	var hosts []*Prefix
+	for n := 0; n < 3; n++ {
+		h, err := i.AcquireLoopback(ctx, "10.255.0.0/24", IPDetail{User: "r"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts = append(hosts, h)
+	}
+	if err := i.ReleaseLoopback(ctx, hosts[1].Cidr); err != nil {
+		t.Fatal(err)
+	}
+	newTestPrefix(t, i, mgmt, "10.0.0.0/24", "10.0.0.1", false, "")
+
+	r, err := i.CheckConsistency(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Findings) != 0 {
+		var sb strings.Builder
+		_ = r.WriteText(&sb)
+		t.Fatalf("got findings on a new tree:\n%s", sb.String())
+	}
+	if r.Prefixes != 8 {
+		t.Errorf("got %d prefixes checked, want 8", r.Prefixes)
+	}
+	if pool := i.PrefixFrom(ctx, "10.255.0.0/24"); pool.IsParent {
+		t.Error("loopback pool became a parent prefix")
+	}
+}
+
+func TestCheckConsistencyRepair(t *testing.T) {
+	i := New().(*ipamer)
+	newTestPrefix(t, i, prod, "10.0.0.0/16", "", true, "")
+	newTestPrefix(t, i, prod, "10.0.1.0/24", "10.0.1.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+
+	// unlink the child, drop its gateway and link a child which does not exist
+	err := i.modifyPrefix(ctx, "10.0.1.0/24", func(p *Prefix) error {
+		p.ParentCidr = ""
+		p.release(netip.MustParseAddr("10.0.1.1"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = i.modifyPrefix(ctx, "10.0.0.0/16", func(p *Prefix) error {
+		delete(p.availableChildPrefixes, "10.0.1.0/24")
+		p.availableChildPrefixes["10.0.9.0/24"] = false
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := i.CheckConsistency(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Consistent() {
+		t.Fatal("expected findings on a broken tree")
+	}
+	if p := i.PrefixFrom(ctx, "10.0.1.0/24"); p.ParentCidr != "" {
+		t.Fatal("check without repair changed the prefix")
+	}
+
+	r, err = i.CheckConsistency(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Consistent() {
+		t.Fatalf("got unrepaired findings %v", r.Findings)
+	}
+	r, err = i.CheckConsistency(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Findings) != 0 {
+		t.Fatalf("got findings %v after the repair", r.Findings)
+	}
+	if p := i.PrefixFrom(ctx, "10.0.1.0/24"); p.ParentCidr != "10.0.0.0/16" {
+		t.Errorf("got parent %q after the repair, want 10.0.0.0/16", p.ParentCidr)
+	}
+}
@@ -0,0 +1,177 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// PrefixVersion is the snapshot of a Prefix taken when it was written.
+type PrefixVersion struct {
+	Prefix  Prefix
+	Version int64
+	Time    time.Time
+	// Deleted is set if the Prefix was deleted at Time, Prefix is its last state.
+	Deleted bool
+}
+
+const (
+	// IPAcquired is the event of an ip being acquired or marked while it was free.
+	IPAcquired = "acquired"
+	// IPMarked is the event of an acquired ip being marked again, which replaces its operator and date.
+	IPMarked = "marked"
+	// IPEdited is the event of the user, description or expiry of an acquired ip being changed.
+	IPEdited = "edited"
+	// IPReleased is the event of an ip being released, also by deleting its prefix.
+	IPReleased = "released"
+)
+
+// IPEvent is a change of an ip found between two versions of the prefix containing it.
+type IPEvent struct {
+	Time    time.Time `json:"time"`    //变更时间
+	Cidr    string    `json:"cidr"`    //所在网段
+	Version int64     `json:"version"` //网段版本
+	Kind    string    `json:"kind"`    //acquired,marked,edited或released
+	// Detail is the IPDetail after the change, for IPReleased the one before it.
+	Detail IPDetail `json:"detail"`
+}
+
+// IPChange is a write of a single ip recorded by an IPChangeStorage.
+type IPChange struct {
+	Cidr    string
+	IP      string
+	Version int64
+	Time    time.Time
+	// Released is set if the ip was released at Time, Detail is the IPDetail it had.
+	Released bool
+	Detail   IPDetail
+}
+
+// PrefixAt returns the Prefix cidr as it was stored at the given time.
+// If it did not exist at that time an NotFoundError is returned.
+func (i *ipamer) PrefixAt(ctx context.Context, cidr string, at time.Time) (*Prefix, error) {
+	ipprefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse cidr:%s %w", cidr, err)
+	}
+	vs, err := i.prefixVersions(ctx, []string{ipprefix.Masked().String()}, at)
+	if err != nil {
+		return nil, err
+	}
+	if len(vs) == 0 || vs[len(vs)-1].Deleted {
+		return nil, fmt.Errorf("%w: prefix %s did not exist at %s", ErrNotFound, cidr, at.Format(time.RFC3339))
+	}
+	last := vs[len(vs)-1]
+	p := *last.Prefix.deepCopy()
+	if s, ok := i.storage.(IPChangeStorage); ok {
+		// the snapshot is taken on the last change of the prefix itself, the ips written since are replayed.
+		changes, err := s.IPChanges(ctx, NamespaceFromContext(ctx), []string{p.Cidr}, "", last.Time, at)
+		if err != nil {
+			return nil, err
+		}
+		p.applyIPChanges(changes)
+	}
+	return &p, nil
+}
+
+// applyIPChanges replays changes recorded after the snapshot p was taken.
+func (p *Prefix) applyIPChanges(changes []IPChange) {
+	for _, c := range changes {
+		addr, err := netip.ParseAddr(c.IP)
+		if err != nil {
+			continue
+		}
+		if c.Released {
+			p.release(addr)
+			continue
+		}
+		p.acquire(c.Detail, addr)
+	}
+}
+
+// IPHistory returns the changes of ip in all prefixes of the namespace which ever contained it, the oldest first.
+func (i *ipamer) IPHistory(ctx context.Context, ip string) ([]IPEvent, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse ip:%s %w", ip, err)
+	}
+	addr = addr.Unmap()
+	// the snapshots are looked up by cidr, these are all cidrs an ip can be part of.
+	cidrs := make([]string, 0, addr.BitLen()+1)
+	for bits := 0; bits <= addr.BitLen(); bits++ {
+		p, _ := addr.Prefix(bits)
+		cidrs = append(cidrs, p.String())
+	}
+	vs, err := i.prefixVersions(ctx, cidrs, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	type state struct {
+		held   bool
+		detail IPDetail
+	}
+	// a step is the state of the ip in a prefix after a snapshot or a recorded change.
+	type step struct {
+		time    time.Time
+		cidr    string
+		version int64
+		after   state
+	}
+	steps := make([]step, 0, len(vs))
+	for _, v := range vs {
+		st := step{time: v.Time, cidr: v.Prefix.Cidr, version: v.Version}
+		if !v.Deleted {
+			st.after.detail, st.after.held = v.Prefix.Ips[addr.String()]
+			st.after.held = st.after.held || v.Prefix.allocatedSet().Contains(addr)
+		}
+		steps = append(steps, st)
+	}
+	if s, ok := i.storage.(IPChangeStorage); ok {
+		changes, err := s.IPChanges(ctx, NamespaceFromContext(ctx), cidrs, addr.String(), time.Time{}, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range changes {
+			st := step{time: c.Time, cidr: c.Cidr, version: c.Version}
+			if !c.Released {
+				st.after = state{held: true, detail: c.Detail}
+			}
+			steps = append(steps, st)
+		}
+		// a snapshot and a change taken at the same time keep this order, the snapshot first.
+		sort.SliceStable(steps, func(a, b int) bool { return steps[a].time.Before(steps[b].time) })
+	}
+	last := make(map[string]state)
+	events := []IPEvent{}
+	for _, st := range steps {
+		before, after := last[st.cidr], st.after
+		last[st.cidr] = after
+		e := IPEvent{Time: st.time, Cidr: st.cidr, Version: st.version, Detail: after.detail}
+		switch {
+		case !before.held && after.held:
+			e.Kind = IPAcquired
+		case before.held && !after.held:
+			e.Kind = IPReleased
+			e.Detail = before.detail
+		case !after.held || before.detail == after.detail:
+			continue
+		case before.detail.Operator != after.detail.Operator || before.detail.Date != after.detail.Date:
+			e.Kind = IPMarked
+		default:
+			e.Kind = IPEdited
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// prefixVersions returns the snapshots of the given cidrs of the namespace taken up to until.
+func (i *ipamer) prefixVersions(ctx context.Context, cidrs []string, until time.Time) ([]PrefixVersion, error) {
+	s, ok := i.storage.(HistoryStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage %s does not keep the history of prefixes", i.storage.Name())
+	}
+	return s.PrefixVersions(ctx, NamespaceFromContext(ctx), cidrs, until)
+}
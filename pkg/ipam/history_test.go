@@ -0,0 +1,108 @@
+package ipam
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// ipChangeMemory is a memory storage recording writes of ips like the per-ip layout of mongodb,
+// its changes are recorded by the test.
+type ipChangeMemory struct {
+	*memory
+	changes []IPChange
+}
+
+func (m *ipChangeMemory) IPChanges(_ context.Context, _ Namespace, cidrs []string, ip string, since, until time.Time) ([]IPChange, error) {
+	wanted := make(map[string]bool, len(cidrs))
+	for _, cidr := range cidrs {
+		wanted[cidr] = true
+	}
+	var changes []IPChange
+	for _, c := range m.changes {
+		if !wanted[c.Cidr] || (ip != "" && c.IP != ip) {
+			continue
+		}
+		if (!since.IsZero() && c.Time.Before(since)) || (!until.IsZero() && c.Time.After(until)) {
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// newIPChangeHistory creates 10.0.0.0/28 and records 10.0.0.5 being acquired, edited and released
+// a second, two and three seconds after its snapshot.
+func newIPChangeHistory(t *testing.T) (Ipamer, context.Context, time.Time) {
+	t.Helper()
+	s := &ipChangeMemory{memory: NewMemory().(*memory)}
+	i := NewWithStorage(s)
+	newTestPrefix(t, i, prod, "10.0.0.0/28", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	vs, err := s.PrefixVersions(ctx, prod, []string{"10.0.0.0/28"}, time.Time{})
+	if err != nil || len(vs) == 0 {
+		t.Fatalf("got versions %v, error %v", vs, err)
+	}
+	created := vs[len(vs)-1].Time
+	s.changes = []IPChange{
+		{Cidr: "10.0.0.0/28", IP: "10.0.0.5", Time: created.Add(time.Second), Detail: IPDetail{User: "a"}},
+		{Cidr: "10.0.0.0/28", IP: "10.0.0.6", Time: created.Add(time.Second), Detail: IPDetail{User: "other"}},
+		{Cidr: "10.0.0.0/28", IP: "10.0.0.5", Time: created.Add(2 * time.Second), Detail: IPDetail{User: "b"}},
+		{Cidr: "10.0.0.0/28", IP: "10.0.0.5", Time: created.Add(3 * time.Second), Released: true, Detail: IPDetail{User: "b"}},
+	}
+	return i, ctx, created
+}
+
+func TestPrefixAtReplaysIPChanges(t *testing.T) {
+	i, ctx, created := newIPChangeHistory(t)
+	tests := []struct {
+		at   time.Duration
+		user string
+		held bool
+	}{
+		{at: 0},
+		{at: time.Second, user: "a", held: true},
+		{at: 2500 * time.Millisecond, user: "b", held: true},
+		{at: 3 * time.Second},
+	}
+	for _, tt := range tests {
+		p, err := i.PrefixAt(ctx, "10.0.0.0/28", created.Add(tt.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, ok := p.Ips["10.0.0.5"]
+		if ok != tt.held || d.User != tt.user {
+			t.Errorf("got 10.0.0.5 held %v by %q after %s, want held %v by %q", ok, d.User, tt.at, tt.held, tt.user)
+		}
+		if ok != p.allocatedSet().Contains(netip.MustParseAddr("10.0.0.5")) {
+			t.Errorf("allocation index of 10.0.0.5 does not match its detail after %s", tt.at)
+		}
+	}
+}
+
+func TestIPHistoryOfIPChanges(t *testing.T) {
+	i, ctx, created := newIPChangeHistory(t)
+	events, err := i.IPHistory(ctx, "10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		kind string
+		user string
+		at   time.Duration
+	}{
+		{kind: IPAcquired, user: "a", at: time.Second},
+		{kind: IPEdited, user: "b", at: 2 * time.Second},
+		{kind: IPReleased, user: "b", at: 3 * time.Second},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got events %+v, want %d", events, len(want))
+	}
+	for k, w := range want {
+		e := events[k]
+		if e.Kind != w.kind || e.Detail.User != w.user || !e.Time.Equal(created.Add(w.at)) {
+			t.Errorf("got event %+v, want %s by %q after %s", e, w.kind, w.user, w.at)
+		}
+	}
+}
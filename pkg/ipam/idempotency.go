@@ -0,0 +1,67 @@
+package ipam
+
+import "net/netip"
+
+// requestIPs returns the ips acquired before with the given request id.
+func (p *Prefix) requestIPs(requestID string) ([]string, bool) {
+	if requestID == "" {
+		return nil, false
+	}
+	ips, ok := p.Requests[requestID]
+	if !ok || len(ips) == 0 {
+		return nil, false
+	}
+	return append([]string(nil), ips...), true
+}
+
+// rememberRequest stores the ips acquired with the given request id.
+func (p *Prefix) rememberRequest(requestID string, ips []string) {
+	if requestID == "" {
+		return
+	}
+	if p.Requests == nil {
+		p.Requests = make(map[string][]string)
+	}
+	p.Requests[requestID] = append([]string(nil), ips...)
+}
+
+// forgetRequests drops released ips from the stored requests and reports whether any was found.
+func (p *Prefix) forgetRequests(ips ...netip.Addr) bool {
+	if len(p.Requests) == 0 {
+		return false
+	}
+	released := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		released[ip.String()] = true
+	}
+	changed := false
+	for id, acquired := range p.Requests {
+		kept := acquired[:0:0]
+		for _, ip := range acquired {
+			if !released[ip] {
+				kept = append(kept, ip)
+			}
+		}
+		if len(kept) == len(acquired) {
+			continue
+		}
+		changed = true
+		if len(kept) == 0 {
+			delete(p.Requests, id)
+			continue
+		}
+		p.Requests[id] = kept
+	}
+	return changed
+}
+
+func copyRequests(m map[string][]string) map[string][]string {
+	if m == nil {
+		return nil
+	}
+	cm := make(map[string][]string, len(m))
+	for k, v := range m {
+		cm[k] = append([]string(nil), v...)
+	}
+	return cm
+}
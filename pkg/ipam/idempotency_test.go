@@ -0,0 +1,61 @@
+package ipam
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAcquireIPWithRequestID(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/24", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+
+	first, err := i.AcquireIP(ctx, "10.0.0.0/24", IPDetail{User: "a"}, 2, AcquireIPOptions{RequestID: "r1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a retry of the request returns the same ips and acquires no more
+	again, err := i.AcquireIP(ctx, "10.0.0.0/24", IPDetail{User: "a"}, 2, AcquireIPOptions{RequestID: "r1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 2 || again[0] != first[0] || again[1] != first[1] {
+		t.Fatalf("got %v on retry, want %v", again, first)
+	}
+	if got := i.PrefixFrom(ctx, "10.0.0.0/24").Usage().AcquiredIPs.Int64(); got != 5 {
+		t.Errorf("got %d acquired ips, want 5", got)
+	}
+
+	other, err := i.AcquireIP(ctx, "10.0.0.0/24", IPDetail{User: "a"}, 1, AcquireIPOptions{RequestID: "r2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other[0] == first[0] || other[0] == first[1] {
+		t.Errorf("got %v for another request, already acquired by the first", other)
+	}
+
+	// released ips are dropped from the request, once all are released the request acquires new ones
+	if _, err := i.ReleaseIPFromPrefix(ctx, "10.0.0.0/24", first[:1]); err != nil {
+		t.Fatal(err)
+	}
+	again, err = i.AcquireIP(ctx, "10.0.0.0/24", IPDetail{User: "a"}, 2, AcquireIPOptions{RequestID: "r1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 1 || again[0] != first[1] {
+		t.Fatalf("got %v after releasing %s, want [%s]", again, first[0], first[1])
+	}
+	if _, err := i.ReleaseIPFromPrefix(ctx, "10.0.0.0/24", first[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if requests := i.PrefixFrom(ctx, "10.0.0.0/24").Requests; len(requests) != 1 {
+		t.Errorf("got requests %v, want only r2", requests)
+	}
+	again, err = i.AcquireIP(ctx, "10.0.0.0/24", IPDetail{User: "a"}, 2, AcquireIPOptions{RequestID: "r1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 2 {
+		t.Errorf("got %v, want 2 newly acquired ips", again)
+	}
+}
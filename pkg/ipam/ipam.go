@@ -2,20 +2,29 @@ package ipam
 
 import (
 	"context"
-	"sync"
+	"time"
 )
 
 // Ipamer can be used to do IPAM stuff.
+// All methods which take a cidr operate in the Namespace stored in ctx with NewContextWithNamespace.
 type Ipamer interface {
-	// NewPrefix create a new Prefix from a string notation.
-	NewPrefix(ctx context.Context, cidr, gateway, parentCidr string, vlanId int, vrf, idc string, isParent bool) (*Prefix, error)
+	// NewPrefix create a new Prefix from a string notation in the namespace of idc and vrf.
+	// kind selects the reserved addresses, an empty kind creates a KindSubnet prefix.
+	// The new Prefix becomes a child of the nearest Prefix enclosing it and the parent of the prefixes it encloses.
+	NewPrefix(ctx context.Context, cidr, gateway, parentCidr string, vlanId int, vrf, idc string, isParent bool, kind string) (*Prefix, error)
+	// NewPrefixWithOptions creates a new Prefix like NewPrefix with the strategy, tags, quarantine and template of opts,
+	// all in one transaction. Nothing is created if one of them can not be applied.
+	NewPrefixWithOptions(ctx context.Context, cidr, gateway, parentCidr string, vlanId int, vrf, idc string, isParent bool, kind string, opts PrefixOptions) (*Prefix, error)
 	// DeletePrefix delete a Prefix from a string notation.
-	// If the Prefix is not found an NotFoundError is returned.
+	// If the Prefix is not found an NotFoundError is returned, a Prefix with child prefixes or acquired ips can not be deleted.
 	DeletePrefix(ctx context.Context, cidr string) (*Prefix, error)
 	// AcquireChildPrefix will return a Prefix with a smaller length from the given Prefix.
 	AcquireChildPrefix(ctx context.Context, parentCidr string, length uint8) (*Prefix, error)
 	// AcquireSpecificChildPrefix will return a Prefix with a smaller length from the given Prefix.
 	AcquireSpecificChildPrefix(ctx context.Context, parentCidr, childCidr string) (*Prefix, error)
+	// AcquireChildPrefixFromPool will return a Prefix with the given length from one of the parent prefixes
+	// of the namespace selected by sel.
+	AcquireChildPrefixFromPool(ctx context.Context, sel PoolSelector, length uint8) (*Prefix, error)
 	// ReleaseChildPrefix will mark this child Prefix as available again.
 	ReleaseChildPrefix(ctx context.Context, child *Prefix) error
 	// PrefixFrom will return a known Prefix.
@@ -24,8 +33,9 @@ type Ipamer interface {
 	// If specificIP is empty, the next free IP is returned.
 	// If there is no free IP an NoIPAvailableError is returned.
 	AcquireSpecificIP(ctx context.Context, prefixCidr string, ipDetail IPDetail, specificIP string, num int) ([]string, error)
-	// AcquireIP will return the next unused IP from this Prefix.
-	AcquireIP(ctx context.Context, prefixCidr string, ipDetail IPDetail, num int) ([]string, error)
+	// AcquireIP will return the next unused IPs from this Prefix, picked by the allocation strategy
+	// of opts or, if not given, of the prefix. Between 1 and MaxAcquireIPs ips are acquired at once.
+	AcquireIP(ctx context.Context, prefixCidr string, ipDetail IPDetail, num int, opts AcquireIPOptions) ([]string, error)
 	// ReleaseIP will release the given IP for later usage and returns the updated Prefix.
 	// If the IP is not found an NotFoundError is returned.
 	ReleaseIP(ctx context.Context, ip *IP) (*Prefix, error)
@@ -36,29 +46,113 @@ type Ipamer interface {
 	Dump(ctx context.Context) (string, error)
 	// Load a previously created json formatted dump, deletes all prefixes before loading
 	Load(ctx context.Context, dump string) error
-	// ReadAllPrefixCidrs retrieves all existing Prefix CIDRs from the underlying storage
+	// ReadAllPrefixCidrs retrieves all existing Prefix CIDRs of the namespace from the underlying storage
 	ReadAllPrefixCidrs(ctx context.Context) ([]string, error)
+	// ReadAllPrefixes retrieves the prefixes of all namespaces from the underlying storage
+	ReadAllPrefixes(ctx context.Context) (Prefixes, error)
+	// SplitPrefix replaces the leaf Prefix by the prefixes of length bits it consists of, carrying over its ips and ranges.
+	SplitPrefix(ctx context.Context, cidr string, bits uint8) ([]*Prefix, error)
+	// MergePrefixes replaces adjacent sibling leaf prefixes by their supernet, carrying over their ips and ranges.
+	MergePrefixes(ctx context.Context, cidrs []string) (*Prefix, error)
+	// ResizePrefix changes the length of the leaf Prefix keeping its network address,
+	// it is refused if an acquired ip or a range would fall outside of the resized Prefix.
+	ResizePrefix(ctx context.Context, cidr string, bits uint8) (*Prefix, error)
+	// PlanRenumber proposes a one-to-one mapping of the acquired ips of oldCidr into the existing prefix newCidr,
+	// keeping host offsets where possible.
+	PlanRenumber(ctx context.Context, oldCidr, newCidr string) (*RenumberPlan, error)
+	// ApplyRenumber acquires all new ips of the plan at once and links both prefixes until the renumbering is retired.
+	// The plan must map every acquired ip of the old prefix, no ips can be acquired in it until then.
+	ApplyRenumber(ctx context.Context, plan RenumberPlan) error
+	// RenumberPlanOf returns the applied mapping of a renumbered prefix.
+	RenumberPlanOf(ctx context.Context, oldCidr string) (*RenumberPlan, error)
+	// RetireRenumber releases the ips of the renumbered prefix and deletes it,
+	// it is refused while the prefix holds ips which are not mapped.
+	RetireRenumber(ctx context.Context, oldCidr string) (*Prefix, error)
+	// CancelRenumber releases the new ips of an applied renumbering and unlinks both prefixes.
+	CancelRenumber(ctx context.Context, oldCidr string) error
+	// EditPrefixQuarantine sets the cooldown, e.g. 24h, released ips of the Prefix are skipped by acquisition.
+	EditPrefixQuarantine(ctx context.Context, prefixCidr, cooldown string) error
+	// QuarantinedIPs returns the released ips of the Prefix which are still in quarantine.
+	QuarantinedIPs(ctx context.Context, prefixCidr string) ([]QuarantinedIP, error)
+	// ReleaseQuarantined ends the quarantine of the given ips, of all ips of the Prefix if none are given.
+	ReleaseQuarantined(ctx context.Context, prefixCidr string, ips []string) error
+	// RenewIP sets the expiry of an acquired ip, a zero expires makes it permanent.
+	RenewIP(ctx context.Context, prefixCidr, ip string, expires time.Time) error
+	// ReleaseExpiredIP releases the ip if it expires before the given time, an ip renewed meanwhile is kept.
+	ReleaseExpiredIP(ctx context.Context, prefixCidr, ip string, before time.Time) error
+	// Leases returns the ips of all namespaces which expire before the given time.
+	Leases(ctx context.Context, before time.Time) ([]Lease, error)
+	// PrefixTree returns the prefixes of the namespace as tree, every Prefix below the nearest Prefix enclosing it.
+	PrefixTree(ctx context.Context) ([]*PrefixNode, error)
 	//修改ip使用人
 	EditIPUserFromPrefix(ctx context.Context, prefixCidr string, user string, ips []string) error
+	// EditPrefixStrategy sets the allocation strategy used by AcquireIP for this Prefix.
+	EditPrefixStrategy(ctx context.Context, prefixCidr string, strategy string) error
+	// EditPrefixTags replaces the tags of this Prefix.
+	EditPrefixTags(ctx context.Context, prefixCidr string, tags []string) error
+	// AddReservedRange adds a named range to the Prefix which AcquireIP only hands out addresses from if requested.
+	AddReservedRange(ctx context.Context, prefixCidr string, r ReservedRange) error
+	// DeleteReservedRange removes the named range from the Prefix, acquired addresses inside the range are kept.
+	DeleteReservedRange(ctx context.Context, prefixCidr string, name string) error
+	// ApplyTemplate adds the ranges and reservations of the Template to the Prefix.
+	// If a rule covers an acquired address an AlreadyAllocatedError is returned and nothing is changed.
+	ApplyTemplate(ctx context.Context, prefixCidr string, t Template) error
 	//修改ip描述
 	EditIPDescriptionFromPrefix(ctx context.Context, prefixCidr string, description string, ip string) error
 	//标记ip
 	MarkIP(ctx context.Context, prefixCidr string, ipDetail IPDetail, ips []string) (*ReleaseIPRes, error)
+	// AcquireLoopback acquires the next free address of the loopback pool poolCidr
+	// and returns the KindHost Prefix created for it.
+	AcquireLoopback(ctx context.Context, poolCidr string, ipDetail IPDetail) (*Prefix, error)
+	// ReleaseLoopback deletes the KindHost Prefix cidr and releases its address in the loopback pool,
+	// the host is kept in the archive like prefixes deleted with DeletePrefix.
+	ReleaseLoopback(ctx context.Context, cidr string) error
+	// Locks returns the locks serializing structural changes which are currently held.
+	Locks(ctx context.Context) ([]LockHolder, error)
+	// CheckConsistency verifies the stored prefixes of all namespaces and reports the inconsistencies found,
+	// with repair the repairable ones are fixed.
+	CheckConsistency(ctx context.Context, repair bool) (*ConsistencyReport, error)
+	// PrefixAt returns the Prefix as it was stored at the given time, from the snapshots the Storage
+	// takes on every write. The Storage must implement HistoryStorage.
+	PrefixAt(ctx context.Context, cidr string, at time.Time) (*Prefix, error)
+	// IPHistory returns when the ip was acquired, marked, edited and released and by whom, the oldest first.
+	IPHistory(ctx context.Context, ip string) ([]IPEvent, error)
+	// RestoreIPs acquires the ips of the Prefix again with the given IPDetail, e.g. to undo their release.
+	// If one of them is acquired an AlreadyAllocatedError is returned and nothing is changed.
+	RestoreIPs(ctx context.Context, prefixCidr string, ips map[string]IPDetail) error
+	// RestorePrefix creates the deleted Prefix again with its ips, ranges and settings below the same parent.
+	// It is refused if the Prefix or a prefix inside of it exists, or if its parent changed.
+	RestorePrefix(ctx context.Context, p Prefix) (*Prefix, error)
+	// ArchivedPrefixes returns the deleted prefixes kept in the archive of the Storage, the latest deleted first.
+	// The Storage must implement ArchiveStorage.
+	ArchivedPrefixes(ctx context.Context) ([]ArchivedPrefix, error)
+	// RestoreArchivedPrefix creates the archived prefix with the given id again and removes it from the archive.
+	// It is refused if the prefix or a prefix inside of it was created since, or if its parent changed.
+	RestoreArchivedPrefix(ctx context.Context, id string) (*Prefix, error)
+	// PurgeArchivedPrefixes removes the prefixes deleted before the given time from the archive and returns them.
+	PurgeArchivedPrefixes(ctx context.Context, before time.Time) ([]ArchivedPrefix, error)
 }
 
 type ipamer struct {
-	mu      sync.Mutex
 	storage Storage
+	locker  Locker
+	// tx is set if storage is a transaction, see transaction
+	tx bool
 }
 
 // New returns a Ipamer with in memory storage for networks, prefixes and ips.
 func New() Ipamer {
 	storage := NewMemory()
-	return &ipamer{storage: storage}
+	return &ipamer{storage: storage, locker: newLocalLocker()}
 }
 
 // NewWithStorage allows you to create a Ipamer instance with your Storage implementation.
-// The Storage interface must be implemented.
+// The Storage interface must be implemented. If the Storage is a Locker too
+// it serializes structural changes, otherwise they are only serialized within this process.
 func NewWithStorage(storage Storage) Ipamer {
-	return &ipamer{storage: storage}
+	locker, ok := storage.(Locker)
+	if !ok {
+		locker = newLocalLocker()
+	}
+	return &ipamer{storage: storage, locker: locker}
 }
@@ -0,0 +1,32 @@
+package ipam
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// isPointToPoint reports whether ipnet is a point-to-point link, an IPv4 /31 or an IPv6 /127,
+// which uses both of its addresses without network and broadcast address.
+// An IPv6 /126 is handled like every other IPv6 prefix, only its first address is reserved.
+func isPointToPoint(ipnet netip.Prefix) bool {
+	return ipnet.Bits() == ipnet.Addr().BitLen()-1
+}
+
+// eui64 returns the address of ipnet with the modified EUI-64 interface identifier derived from mac,
+// see RFC 4291 appendix A. ipnet must be an IPv6 /64.
+func eui64(ipnet netip.Prefix, mac string) (netip.Addr, error) {
+	if !ipnet.Addr().Is6() || ipnet.Bits() != 64 {
+		return netip.Addr{}, fmt.Errorf("eui-64 addresses need an ipv6 /64 prefix, got:%s", ipnet)
+	}
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return netip.Addr{}, fmt.Errorf("given mac:%s is not a valid 48 bit mac address", mac)
+	}
+	a := ipnet.Masked().Addr().As16()
+	a[8] = hw[0] ^ 0x02
+	a[9], a[10] = hw[1], hw[2]
+	a[11], a[12] = 0xff, 0xfe
+	a[13], a[14], a[15] = hw[3], hw[4], hw[5]
+	return netip.AddrFrom16(a), nil
+}
@@ -11,7 +11,8 @@ type prefixJSON struct {
 	// TODO remove this in the next release
 	ChildPrefixLength int                 // the length of the child prefixes. Legacy to migrate existing prefixes stored in the db to set the IsParent on reads.
 	IsParent          bool                // set to true if there are child prefixes
-	IPs               map[string]IPDetail // The ips contained in this prefix
+	IPs               map[string]IPDetail // The ips contained in this prefix which carry an IPDetail
+	Allocated         []string            // ranges of all acquired ips, nil for prefixes stored before it existed
 	Version           int64               // Version is used for optimistic locking
 }
 
@@ -21,6 +22,11 @@ func (p prefixJSON) toPrefix() Prefix {
 	if p.ChildPrefixLength > 0 {
 		p.IsParent = true
 	}
+	// Prefixes stored before the allocation index existed only have IPs, build it from them.
+	allocated := decodeAllocated(p.Allocated)
+	if p.Allocated == nil {
+		allocated = migrateAllocated(p.IPs)
+	}
 	return Prefix{
 		Gateway:                p.Gateway,
 		VlanID:                 p.VlanID,
@@ -31,7 +37,19 @@ func (p prefixJSON) toPrefix() Prefix {
 		availableChildPrefixes: p.AvailableChildPrefixes,
 		childPrefixLength:      p.ChildPrefixLength,
 		IsParent:               p.IsParent,
+		Kind:                   p.Kind,
+		Strategy:               p.Strategy,
+		Ranges:                 p.Ranges,
+		Template:               p.Template,
+		Tags:                   p.Tags,
+		RenumberTo:             p.RenumberTo,
+		RenumberFrom:           p.RenumberFrom,
+		Renumbered:             p.Renumbered,
+		Quarantine:             p.Quarantine,
+		Quarantined:            p.Quarantined,
+		Requests:               p.Requests,
 		Ips:                    p.IPs,
+		allocated:              allocated,
 		version:                p.Version,
 	}
 }
@@ -39,18 +57,30 @@ func (p prefixJSON) toPrefix() Prefix {
 func (p Prefix) toPrefixJSON() prefixJSON {
 	return prefixJSON{
 		Prefix: Prefix{
-			Gateway:    p.Gateway,
-			VlanID:     p.VlanID,
-			VRF:        p.VRF,
-			IDC:        p.IDC,
-			Cidr:       p.Cidr,
-			ParentCidr: p.ParentCidr,
+			Gateway:      p.Gateway,
+			VlanID:       p.VlanID,
+			VRF:          p.VRF,
+			IDC:          p.IDC,
+			Cidr:         p.Cidr,
+			ParentCidr:   p.ParentCidr,
+			Kind:         p.Kind,
+			Strategy:     p.Strategy,
+			Ranges:       p.Ranges,
+			Template:     p.Template,
+			Tags:         p.Tags,
+			RenumberTo:   p.RenumberTo,
+			RenumberFrom: p.RenumberFrom,
+			Renumbered:   p.Renumbered,
+			Quarantine:   p.Quarantine,
+			Quarantined:  p.Quarantined,
+			Requests:     p.Requests,
 		},
 		AvailableChildPrefixes: p.availableChildPrefixes,
 		IsParent:               p.IsParent,
 		// TODO remove this in the next release
 		ChildPrefixLength: p.childPrefixLength,
 		IPs:               p.Ips,
+		Allocated:         encodeAllocated(p.allocatedSet()),
 		Version:           p.version,
 	}
 }
@@ -0,0 +1,182 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+)
+
+// Kinds of a Prefix.
+const (
+	// KindSubnet reserves the network address, the broadcast address on IPv4 and the gateway.
+	// Prefixes stored without a kind are subnets.
+	KindSubnet = "subnet"
+	// KindPointToPoint is a /31 or /127 link, only the gateway is reserved if given.
+	KindPointToPoint = "p2p"
+	// KindHost is a /32 or /128 host route without reserved addresses.
+	KindHost = "host"
+	// KindLoopbackPool hands out its addresses as KindHost prefixes with AcquireLoopback.
+	KindLoopbackPool = "loopbackpool"
+)
+
+// checkKind validates kind and whether ipnet has a length suitable for it.
+func checkKind(ipnet netip.Prefix, kind string) error {
+	switch kind {
+	case KindSubnet, KindLoopbackPool:
+		return nil
+	case KindPointToPoint:
+		if !isPointToPoint(ipnet) {
+			return fmt.Errorf("point-to-point prefix must be a /31 or /127, got:%s", ipnet)
+		}
+		return nil
+	case KindHost:
+		if ipnet.Bits() != ipnet.Addr().BitLen() {
+			return fmt.Errorf("host prefix must be a /32 or /128, got:%s", ipnet)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown prefix kind:%s", kind)
+}
+
+// gatewayOptional reports whether prefixes of kind may be created without gateway.
+func gatewayOptional(kind string) bool {
+	return kind != KindSubnet
+}
+
+func (i *ipamer) AcquireLoopback(ctx context.Context, poolCidr string, ipDetail IPDetail) (*Prefix, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var host *Prefix
+	err = retryOnOptimisticLock(func() error {
+		return i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+			var err error
+			host, err = tx.acquireLoopbackInternal(ctx, poolCidr, ipDetail)
+			return err
+		})
+	})
+	return host, err
+}
+
+func (i *ipamer) acquireLoopbackInternal(ctx context.Context, poolCidr string, ipDetail IPDetail) (*Prefix, error) {
+	pool := i.PrefixFrom(ctx, poolCidr)
+	if pool == nil {
+		return nil, fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, poolCidr)
+	}
+	if pool.Kind != KindLoopbackPool {
+		return nil, fmt.Errorf("prefix %s is not a loopback pool", pool.Cidr)
+	}
+	ipnet, err := netip.ParsePrefix(pool.Cidr)
+	if err != nil {
+		return nil, err
+	}
+	strategy, err := ParseStrategy(pool.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	free, err := pool.freeIPs(ipnet)
+	if err != nil {
+		return nil, err
+	}
+	free, err = pool.restrictToRanges(free, "")
+	if err != nil {
+		return nil, err
+	}
+	picked := strategy.Pick(ipnet, free, 1)
+	if len(picked) == 0 {
+		return nil, fmt.Errorf("%w: no free address left in loopback pool %s", ErrNoIPAvailable, pool.Cidr)
+	}
+	return i.createLoopback(ctx, pool, picked[0], ipDetail)
+}
+
+// createLoopback acquires ip in the loopback pool and creates its KindHost prefix as child of the pool.
+func (i *ipamer) createLoopback(ctx context.Context, pool *Prefix, ip netip.Addr, ipDetail IPDetail) (*Prefix, error) {
+	hostCidr := netip.PrefixFrom(ip, ip.BitLen()).String()
+	host, err := i.newPrefix(hostCidr, "", pool.VlanID, pool.VRF, pool.IDC, pool.Cidr, false, KindHost)
+	if err != nil {
+		return nil, err
+	}
+	host.acquire(ipDetail, ip)
+
+	// the host is a child of the pool, the pool is no parent as it keeps holding the address.
+	if pool.availableChildPrefixes == nil {
+		pool.availableChildPrefixes = make(map[string]bool)
+	}
+	pool.availableChildPrefixes[hostCidr] = false
+	pool.acquire(ipDetail, ip)
+	err = i.persistIPs(ctx, pool, []string{ip.String()}, true)
+	if err != nil {
+		return nil, err
+	}
+	// the child prefixes and the quarantine are part of the prefix document, persistIPs only wrote the ip.
+	if _, ok := i.storage.(IPStorage); ok {
+		_, err = i.storage.UpdatePrefix(ctx, *pool)
+		if err != nil {
+			return nil, err
+		}
+	}
+	created, err := i.storage.CreatePrefix(ctx, *host)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create loopback %s: %w", hostCidr, err)
+	}
+	return &created, nil
+}
+
+// restoreLoopbackInternal creates the deleted loopback host again with the IPDetail it had,
+// if its address is still free in the pool.
+func (i *ipamer) restoreLoopbackInternal(ctx context.Context, host Prefix, pool *Prefix) (*Prefix, error) {
+	ipnet, err := netip.ParsePrefix(host.Cidr)
+	if err != nil {
+		return nil, err
+	}
+	ip := ipnet.Addr()
+	if pool.allocatedSet().Contains(ip) {
+		return nil, fmt.Errorf("%w: loopback %s is acquired in pool %s", ErrAlreadyAllocated, ip, pool.Cidr)
+	}
+	delete(pool.Quarantined, ip.String())
+	return i.createLoopback(ctx, pool, ip, host.Ips[ip.String()])
+}
+
+func (i *ipamer) ReleaseLoopback(ctx context.Context, cidr string) error {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return retryOnOptimisticLock(func() error {
+		return i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+			return tx.releaseLoopbackInternal(ctx, cidr)
+		})
+	})
+}
+
+func (i *ipamer) releaseLoopbackInternal(ctx context.Context, cidr string) error {
+	host := i.PrefixFrom(ctx, cidr)
+	if host == nil {
+		return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, cidr)
+	}
+	if host.Kind != KindHost || host.ParentCidr == "" {
+		return fmt.Errorf("prefix %s is not a loopback", host.Cidr)
+	}
+	pool := i.PrefixFrom(ctx, host.ParentCidr)
+	if pool == nil || pool.Kind != KindLoopbackPool {
+		return fmt.Errorf("loopback pool %s of %s not found", host.ParentCidr, host.Cidr)
+	}
+	ipnet, err := netip.ParsePrefix(host.Cidr)
+	if err != nil {
+		return err
+	}
+	// the host holds the address of the loopback, it is deleted with it.
+	_, err = i.removePrefix(ctx, host)
+	if err != nil {
+		return err
+	}
+	// removePrefix unlinked the host from the pool, release on the stored pool.
+	pool = i.PrefixFrom(ctx, host.ParentCidr)
+	if pool == nil {
+		return fmt.Errorf("loopback pool %s of %s not found", host.ParentCidr, host.Cidr)
+	}
+	return i.persistRelease(ctx, pool, []netip.Addr{ipnet.Addr()})
+}
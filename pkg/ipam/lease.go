@@ -0,0 +1,188 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// ExpiresLayout is the format of IPDetail.Expires, the same as the one of IPDetail.Date.
+const ExpiresLayout = "2006-01-02 15:04:05"
+
+// Lease is an acquired ip with an expiry.
+type Lease struct {
+	IDC     string    `json:"idc"`
+	VRF     string    `json:"vrf"`
+	Cidr    string    `json:"cidr"`
+	IP      string    `json:"ip"`
+	Detail  IPDetail  `json:"detail"`
+	Expires time.Time `json:"expires"`
+}
+
+// ParseExpires parses an expiry in ExpiresLayout in local time, an empty string never expires.
+func ParseExpires(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(ExpiresLayout, s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry %q, expected format %s", s, ExpiresLayout)
+	}
+	return t, nil
+}
+
+// ExpiresAt returns the expiry of the ip and false if it never expires.
+func (d IPDetail) ExpiresAt() (time.Time, bool) {
+	t, err := ParseExpires(d.Expires)
+	if err != nil || t.IsZero() {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// RenewIP sets the expiry of an acquired ip, a zero expires removes it.
+func (i *ipamer) RenewIP(ctx context.Context, prefixCidr, ip string, expires time.Time) error {
+	return retryOnOptimisticLock(func() error {
+		prefix := i.PrefixFrom(ctx, prefixCidr)
+		if prefix == nil {
+			return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, prefixCidr)
+		}
+		detail, ok := prefix.Ips[ip]
+		if !ok || isReservation(detail) {
+			return fmt.Errorf("%w: ip %s is not acquired in %s", ErrNotFound, ip, prefixCidr)
+		}
+		detail.Expires = ""
+		if !expires.IsZero() {
+			detail.Expires = expires.In(time.Local).Format(ExpiresLayout)
+		}
+		prefix.Ips[ip] = detail
+		return i.persistIPs(ctx, prefix, []string{ip}, false)
+	})
+}
+
+// ReleaseExpiredIP releases the ip if it expires before the given time. The expiry is checked on the
+// same version of the prefix the release is written to, an ip renewed meanwhile is kept.
+func (i *ipamer) ReleaseExpiredIP(ctx context.Context, prefixCidr, ip string, before time.Time) error {
+	return retryOnOptimisticLock(func() error {
+		return i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+			return tx.releaseExpiredInternal(ctx, prefixCidr, ip, before)
+		})
+	})
+}
+
+func (i *ipamer) releaseExpiredInternal(ctx context.Context, prefixCidr, ip string, before time.Time) error {
+	prefix := i.PrefixFrom(ctx, prefixCidr)
+	if prefix == nil {
+		return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, prefixCidr)
+	}
+	detail, ok := prefix.Ips[ip]
+	if !ok {
+		return fmt.Errorf("%w: ip %s is not acquired in %s", ErrNotFound, ip, prefixCidr)
+	}
+	expires, ok := detail.ExpiresAt()
+	if !ok || !expires.Before(before) {
+		return fmt.Errorf("ip %s was renewed", ip)
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return err
+	}
+	return i.persistRelease(ctx, prefix, []netip.Addr{addr})
+}
+
+// Leases returns the ips of all namespaces which expire before the given time, the earliest first.
+func (i *ipamer) Leases(ctx context.Context, before time.Time) ([]Lease, error) {
+	ps, err := i.storage.ReadAllPrefixes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	leases := []Lease{}
+	for _, p := range ps {
+		for ip, detail := range p.Ips {
+			expires, ok := detail.ExpiresAt()
+			if !ok || !expires.Before(before) {
+				continue
+			}
+			leases = append(leases, Lease{IDC: p.IDC, VRF: p.VRF, Cidr: p.Cidr, IP: ip, Detail: detail, Expires: expires})
+		}
+	}
+	sort.Slice(leases, func(a, b int) bool {
+		if leases[a].Expires.Equal(leases[b].Expires) {
+			return leases[a].IP < leases[b].IP
+		}
+		return leases[a].Expires.Before(leases[b].Expires)
+	})
+	return leases, nil
+}
+
+// Notifier tells the owner of an ip, IPDetail.User, that it was reclaimed.
+type Notifier interface {
+	Notify(ctx context.Context, lease Lease) error
+}
+
+// Reaper releases expired ips once their grace period has passed.
+type Reaper struct {
+	ipam Ipamer
+	// Grace is the time an ip stays acquired after it expired
+	Grace time.Duration
+	// Notifier is told about every released ip, may be nil
+	Notifier Notifier
+	// OnError is called for errors of a single ip, may be nil
+	OnError func(lease Lease, err error)
+}
+
+// NewReaper returns a Reaper of the ips managed by ipam.
+func NewReaper(ipam Ipamer, grace time.Duration, notifier Notifier) *Reaper {
+	return &Reaper{ipam: ipam, Grace: grace, Notifier: notifier}
+}
+
+// Reap releases all ips which expired before now minus the grace period and returns them.
+func (r *Reaper) Reap(ctx context.Context, now time.Time) ([]Lease, error) {
+	leases, err := r.ipam.Leases(ctx, now.Add(-r.Grace))
+	if err != nil {
+		return nil, err
+	}
+	reaped := []Lease{}
+	for _, l := range leases {
+		err := r.reap(ctx, l, now)
+		if err != nil {
+			if r.OnError != nil {
+				r.OnError(l, err)
+			}
+			continue
+		}
+		reaped = append(reaped, l)
+		if r.Notifier != nil {
+			err = r.Notifier.Notify(ctx, l)
+			if err != nil && r.OnError != nil {
+				r.OnError(l, err)
+			}
+		}
+	}
+	return reaped, nil
+}
+
+// reap releases a single ip unless it was renewed or released meanwhile.
+func (r *Reaper) reap(ctx context.Context, l Lease, now time.Time) error {
+	ctx = NewContextWithNamespace(ctx, Namespace{IDC: l.IDC, VRF: l.VRF})
+	return r.ipam.ReleaseExpiredIP(ctx, l.Cidr, l.IP, now.Add(-r.Grace))
+}
+
+// Run reaps every interval until ctx is done.
+func (r *Reaper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			_, err := r.Reap(ctx, now)
+			if err != nil && r.OnError != nil {
+				r.OnError(Lease{}, err)
+			}
+		}
+	}
+}
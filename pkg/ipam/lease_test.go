@@ -0,0 +1,128 @@
+package ipam
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps every lease it is told about.
+type recordingNotifier struct {
+	leases []Lease
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, lease Lease) error {
+	n.leases = append(n.leases, lease)
+	return nil
+}
+
+// renewingIpamer renews the ip renew right after Leases returned it, as if its owner renewed it
+// while the Reaper was releasing the expired ips.
+type renewingIpamer struct {
+	Ipamer
+	renew string
+}
+
+func (r renewingIpamer) Leases(ctx context.Context, before time.Time) ([]Lease, error) {
+	leases, err := r.Ipamer.Leases(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range leases {
+		if l.IP == r.renew {
+			ctx := NewContextWithNamespace(ctx, Namespace{IDC: l.IDC, VRF: l.VRF})
+			err := r.Ipamer.RenewIP(ctx, l.Cidr, l.IP, time.Now().Add(24*time.Hour))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return leases, nil
+}
+
+// newLeases acquires ips in 10.0.0.0/24 which expire at the given times, a zero time never expires.
+func newLeases(t *testing.T, expires map[string]time.Time) (Ipamer, context.Context) {
+	t.Helper()
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/24", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	for ip, e := range expires {
+		detail := IPDetail{User: "owner of " + ip}
+		if !e.IsZero() {
+			detail.Expires = e.Format(ExpiresLayout)
+		}
+		if _, err := i.AcquireSpecificIP(ctx, "10.0.0.0/24", detail, ip, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return i, ctx
+}
+
+func TestReap(t *testing.T) {
+	now := time.Now()
+	i, ctx := newLeases(t, map[string]time.Time{
+		"10.0.0.10": now.Add(-2 * time.Hour),
+		"10.0.0.11": now.Add(-30 * time.Minute),
+		"10.0.0.12": {},
+	})
+	n := &recordingNotifier{}
+	r := NewReaper(i, time.Hour, n)
+
+	// 10.0.0.11 is still in its grace period
+	reaped, err := r.Reap(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reaped) != 1 || reaped[0].IP != "10.0.0.10" {
+		t.Fatalf("got reaped %+v, want 10.0.0.10", reaped)
+	}
+	p := i.PrefixFrom(ctx, "10.0.0.0/24")
+	for ip, want := range map[string]bool{"10.0.0.10": false, "10.0.0.11": true, "10.0.0.12": true} {
+		if _, ok := p.Ips[ip]; ok != want {
+			t.Errorf("got %s acquired %v, want %v", ip, ok, want)
+		}
+	}
+
+	reaped, err = r.Reap(ctx, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reaped) != 1 || reaped[0].IP != "10.0.0.11" {
+		t.Fatalf("got reaped %+v after the grace period, want 10.0.0.11", reaped)
+	}
+	if len(n.leases) != 2 || n.leases[0].IP != "10.0.0.10" || n.leases[1].IP != "10.0.0.11" {
+		t.Errorf("got notified %+v, want 10.0.0.10 and 10.0.0.11", n.leases)
+	}
+	if n.leases[0].Detail.User != "owner of 10.0.0.10" {
+		t.Errorf("got notified user %q, want the owner of the ip", n.leases[0].Detail.User)
+	}
+}
+
+func TestReapRenewedMeanwhile(t *testing.T) {
+	now := time.Now()
+	i, ctx := newLeases(t, map[string]time.Time{
+		"10.0.0.10": now.Add(-2 * time.Hour),
+		"10.0.0.11": now.Add(-2 * time.Hour),
+	})
+	n := &recordingNotifier{}
+	r := NewReaper(renewingIpamer{Ipamer: i, renew: "10.0.0.11"}, time.Hour, n)
+	var failed []Lease
+	r.OnError = func(l Lease, err error) { failed = append(failed, l) }
+
+	reaped, err := r.Reap(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reaped) != 1 || reaped[0].IP != "10.0.0.10" {
+		t.Fatalf("got reaped %+v, want 10.0.0.10", reaped)
+	}
+	if _, ok := i.PrefixFrom(ctx, "10.0.0.0/24").Ips["10.0.0.11"]; !ok {
+		t.Error("renewed ip 10.0.0.11 was released")
+	}
+	if len(failed) != 1 || failed[0].IP != "10.0.0.11" {
+		t.Errorf("got errors for %+v, want one for 10.0.0.11", failed)
+	}
+	if len(n.leases) != 1 || n.leases[0].IP != "10.0.0.10" {
+		t.Errorf("got notified %+v, want only 10.0.0.10", n.leases)
+	}
+}
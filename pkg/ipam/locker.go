@@ -0,0 +1,111 @@
+package ipam
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Locker serializes structural changes of prefixes, creating, deleting, splitting and renumbering them,
+// across all Ipamer instances sharing a Storage. Storages shared by several processes implement it,
+// for all other storages an in-process Locker is used.
+type Locker interface {
+	// Lock blocks until the lock name is held or ctx is done, the returned func releases it.
+	Lock(ctx context.Context, name string) (func(), error)
+	// Holders returns the locks currently held.
+	Holders(ctx context.Context) ([]LockHolder, error)
+}
+
+// LockHolder is a lock held by an Ipamer instance. A lock is a lease, its holder renews it
+// while it is held, if the holder dies the lock expires and can be taken over.
+type LockHolder struct {
+	Name     string    `json:"name" bson:"_id"`
+	Owner    string    `json:"owner" bson:"owner"`
+	Acquired time.Time `json:"acquired" bson:"acquired"`
+	Expires  time.Time `json:"expires" bson:"expires"`
+}
+
+// lockOwner identifies the locks of this process, it is built of hostname, pid and a random suffix.
+func lockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// localLocker is the in-process Locker used for storages which are not shared.
+type localLocker struct {
+	owner   string
+	mu      sync.Mutex
+	locks   map[string]chan struct{}
+	holders map[string]LockHolder
+}
+
+func newLocalLocker() *localLocker {
+	return &localLocker{
+		owner:   lockOwner(),
+		locks:   make(map[string]chan struct{}),
+		holders: make(map[string]LockHolder),
+	}
+}
+
+func (l *localLocker) Lock(ctx context.Context, name string) (func(), error) {
+	l.mu.Lock()
+	c, ok := l.locks[name]
+	if !ok {
+		c = make(chan struct{}, 1)
+		l.locks[name] = c
+	}
+	l.mu.Unlock()
+	select {
+	case c <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("unable to lock %s: %w", name, ctx.Err())
+	}
+	l.mu.Lock()
+	l.holders[name] = LockHolder{Name: name, Owner: l.owner, Acquired: time.Now()}
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		delete(l.holders, name)
+		l.mu.Unlock()
+		<-c
+	}, nil
+}
+
+func (l *localLocker) Holders(ctx context.Context) ([]LockHolder, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	holders := make([]LockHolder, 0, len(l.holders))
+	for _, h := range l.holders {
+		holders = append(holders, h)
+	}
+	sort.Slice(holders, func(a, b int) bool {
+		return holders[a].Name < holders[b].Name
+	})
+	return holders, nil
+}
+
+// lock takes the structural lock of the namespace in ctx. It serializes structural changes of the namespace
+// within this process and, if the storage is shared, across all processes using it.
+// Changes of different namespaces do not wait for each other.
+func (i *ipamer) lock(ctx context.Context) (func(), error) {
+	namespace := NamespaceFromContext(ctx)
+	unlock, err := i.locker.Lock(ctx, "namespace:"+namespace.String())
+	if err != nil {
+		return nil, fmt.Errorf("unable to lock namespace %s: %w", namespace, err)
+	}
+	return unlock, nil
+}
+
+func (i *ipamer) Locks(ctx context.Context) ([]LockHolder, error) {
+	return i.locker.Holders(ctx)
+}
@@ -0,0 +1,98 @@
+package ipam
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocalLocker(t *testing.T) {
+	l := newLocalLocker()
+	ctx := context.Background()
+	unlock, err := l.Lock(ctx, "namespace:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	holders, err := l.Holders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holders) != 1 || holders[0].Name != "namespace:a" {
+		t.Fatalf("got holders %v, want namespace:a", holders)
+	}
+
+	// another lock is taken while a is held
+	other, err := l.Lock(ctx, "namespace:b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Lock(tctx, "namespace:a"); err == nil {
+		t.Fatal("expected a held lock not to be taken")
+	}
+	unlock()
+	unlock, err = l.Lock(ctx, "namespace:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+func TestConcurrentChildPrefixes(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/16", "", true, "")
+	newTestPrefix(t, i, mgmt, "10.0.0.0/16", "", true, "")
+
+	var wg sync.WaitGroup
+	children := make(chan *Prefix, 40)
+	errs := make(chan error, 40)
+	for n := 0; n < 40; n++ {
+		wg.Add(1)
+		go func(namespace Namespace) {
+			defer wg.Done()
+			ctx := NewContextWithNamespace(context.Background(), namespace)
+			c, err := i.AcquireChildPrefix(ctx, "10.0.0.0/16", 24)
+			if err != nil {
+				errs <- err
+				return
+			}
+			children <- c
+		}([]Namespace{prod, mgmt}[n%2])
+	}
+	wg.Wait()
+	close(children)
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	seen := make(map[Namespace]map[string]bool)
+	for c := range children {
+		if seen[c.Namespace()] == nil {
+			seen[c.Namespace()] = make(map[string]bool)
+		}
+		if seen[c.Namespace()][c.Cidr] {
+			t.Errorf("child %s acquired twice in %s", c.Cidr, c.Namespace())
+		}
+		seen[c.Namespace()][c.Cidr] = true
+	}
+
+	ctx := context.Background()
+	r, err := i.CheckConsistency(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Findings) != 0 {
+		t.Errorf("got findings %v after concurrent acquisitions", r.Findings)
+	}
+	locks, err := i.Locks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 0 {
+		t.Errorf("got locks %v still held", locks)
+	}
+}
@@ -3,20 +3,41 @@ package ipam
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 )
 
 type memory struct {
-	prefixes map[string]Prefix
-	lock     sync.RWMutex
+	prefixes map[Namespace]map[string]Prefix
+	history  []PrefixVersion
+	archive  []ArchivedPrefix
+	// archived counts the prefixes ever archived, it is the ID of the latest one.
+	archived int
+	// retention is MemoryConfig.HistoryRetention
+	retention time.Duration
+	lock      sync.RWMutex
+}
+
+// MemoryConfig configures a memory storage.
+type MemoryConfig struct {
+	// HistoryRetention is how long snapshots of prefixes are kept, they are kept forever if it is zero.
+	HistoryRetention time.Duration
 }
 
 // NewMemory create a memory storage for ipam
 func NewMemory() Storage {
-	prefixes := make(map[string]Prefix)
+	return NewMemoryWithConfig(MemoryConfig{})
+}
+
+// NewMemoryWithConfig create a memory storage for ipam configured by config
+func NewMemoryWithConfig(config MemoryConfig) Storage {
+	prefixes := make(map[Namespace]map[string]Prefix)
 	return &memory{
-		prefixes: prefixes,
-		lock:     sync.RWMutex{},
+		prefixes:  prefixes,
+		retention: config.HistoryRetention,
+		lock:      sync.RWMutex{},
 	}
 }
 func (m *memory) Name() string {
@@ -26,27 +47,32 @@ func (m *memory) CreatePrefix(_ context.Context, prefix Prefix) (Prefix, error)
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	_, ok := m.prefixes[prefix.Cidr]
+	namespace := prefix.Namespace()
+	_, ok := m.prefixes[namespace][prefix.Cidr]
 	if ok {
 		return Prefix{}, fmt.Errorf("prefix already created:%v", prefix)
 	}
-	m.prefixes[prefix.Cidr] = *prefix.deepCopy()
+	if m.prefixes[namespace] == nil {
+		m.prefixes[namespace] = make(map[string]Prefix)
+	}
+	m.prefixes[namespace][prefix.Cidr] = *prefix.deepCopy()
+	m.recordVersion(prefix, false)
 	return prefix, nil
 }
-func (m *memory) ReadPrefix(_ context.Context, prefix string) (Prefix, error) {
+func (m *memory) ReadPrefix(_ context.Context, prefix string, namespace Namespace) (Prefix, error) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	result, ok := m.prefixes[prefix]
+	result, ok := m.prefixes[namespace][prefix]
 	if !ok {
-		return Prefix{}, fmt.Errorf("prefix %s not found", prefix)
+		return Prefix{}, fmt.Errorf("prefix %s not found in namespace %s", prefix, namespace)
 	}
 	return *result.deepCopy(), nil
 }
 func (m *memory) DeleteAllPrefixes(_ context.Context) error {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
-	m.prefixes = make(map[string]Prefix)
+	m.prefixes = make(map[Namespace]map[string]Prefix)
 	return nil
 }
 func (m *memory) ReadAllPrefixes(_ context.Context) (Prefixes, error) {
@@ -54,17 +80,19 @@ func (m *memory) ReadAllPrefixes(_ context.Context) (Prefixes, error) {
 	defer m.lock.RUnlock()
 
 	ps := make(Prefixes, 0, len(m.prefixes))
-	for _, v := range m.prefixes {
-		ps = append(ps, *v.deepCopy())
+	for _, namespace := range m.prefixes {
+		for _, v := range namespace {
+			ps = append(ps, *v.deepCopy())
+		}
 	}
 	return ps, nil
 }
-func (m *memory) ReadAllPrefixCidrs(_ context.Context) ([]string, error) {
+func (m *memory) ReadAllPrefixCidrs(_ context.Context, namespace Namespace) ([]string, error) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	ps := make([]string, 0, len(m.prefixes))
-	for cidr := range m.prefixes {
+	ps := make([]string, 0, len(m.prefixes[namespace]))
+	for cidr := range m.prefixes[namespace] {
 		ps = append(ps, cidr)
 	}
 	return ps, nil
@@ -79,20 +107,148 @@ func (m *memory) UpdatePrefix(_ context.Context, prefix Prefix) (Prefix, error)
 	if prefix.Cidr == "" {
 		return Prefix{}, fmt.Errorf("prefix not present:%v", prefix)
 	}
-	oldPrefix, ok := m.prefixes[prefix.Cidr]
+	namespace := prefix.Namespace()
+	oldPrefix, ok := m.prefixes[namespace][prefix.Cidr]
 	if !ok {
 		return Prefix{}, fmt.Errorf("prefix not found:%s", prefix.Cidr)
 	}
 	if oldPrefix.version != oldVersion {
 		return Prefix{}, fmt.Errorf("%w: unable to update prefix:%s", ErrOptimisticLockError, prefix.Cidr)
 	}
-	m.prefixes[prefix.Cidr] = *prefix.deepCopy()
+	m.prefixes[namespace][prefix.Cidr] = *prefix.deepCopy()
+	m.recordVersion(prefix, false)
 	return prefix, nil
 }
+
+// RunInTransaction runs fn on a copy of all prefixes and replaces them with the copy if fn succeeds.
+// The storage stays locked while fn runs, other callers wait for the transaction to finish.
+func (m *memory) RunInTransaction(ctx context.Context, fn func(ctx context.Context, tx Storage) error) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// prefixes are stored as deep copies and replaced on every write, copying the maps suffices.
+	prefixes := make(map[Namespace]map[string]Prefix, len(m.prefixes))
+	for namespace, ps := range m.prefixes {
+		prefixes[namespace] = make(map[string]Prefix, len(ps))
+		for cidr, p := range ps {
+			prefixes[namespace][cidr] = p
+		}
+	}
+	// the history is only appended to, the transaction appends to a slice of its own.
+	history := m.history[:len(m.history):len(m.history)]
+	archive := append([]ArchivedPrefix(nil), m.archive...)
+	tx := &memory{prefixes: prefixes, history: history, archive: archive, archived: m.archived, retention: m.retention, lock: sync.RWMutex{}}
+	err := fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+	m.prefixes = tx.prefixes
+	m.history = tx.history
+	m.archive = tx.archive
+	m.archived = tx.archived
+	return nil
+}
+
 func (m *memory) DeletePrefix(_ context.Context, prefix Prefix) (Prefix, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	delete(m.prefixes, prefix.Cidr)
+	stored, ok := m.prefixes[prefix.Namespace()][prefix.Cidr]
+	delete(m.prefixes[prefix.Namespace()], prefix.Cidr)
+	if ok {
+		m.recordVersion(stored, true)
+	}
 	return *prefix.deepCopy(), nil
 }
+
+func (m *memory) PrefixVersions(_ context.Context, namespace Namespace, cidrs []string, until time.Time) ([]PrefixVersion, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	wanted := make(map[string]bool, len(cidrs))
+	for _, cidr := range cidrs {
+		wanted[cidr] = true
+	}
+	var vs []PrefixVersion
+	for _, v := range m.history {
+		if !until.IsZero() && v.Time.After(until) {
+			break
+		}
+		if v.Prefix.Namespace() != namespace || !wanted[v.Prefix.Cidr] {
+			continue
+		}
+		v.Prefix = *v.Prefix.deepCopy()
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+// recordVersion appends a snapshot of prefix to the history and drops the snapshots older than the retention,
+// the caller must hold the lock.
+func (m *memory) recordVersion(prefix Prefix, deleted bool) {
+	now := time.Now()
+	m.history = append(m.history, PrefixVersion{
+		Prefix:  *prefix.deepCopy(),
+		Version: prefix.version,
+		Time:    now,
+		Deleted: deleted,
+	})
+	if m.retention <= 0 {
+		return
+	}
+	// the history is ordered by time, the expired snapshots are at its start.
+	expired := sort.Search(len(m.history), func(k int) bool {
+		return !m.history[k].Time.Before(now.Add(-m.retention))
+	})
+	m.history = m.history[expired:]
+}
+
+func (m *memory) ArchivePrefix(_ context.Context, a ArchivedPrefix) (ArchivedPrefix, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.archived++
+	a.ID = strconv.Itoa(m.archived)
+	a.Prefix = *a.Prefix.deepCopy()
+	m.archive = append(m.archive, a)
+	return a, nil
+}
+
+func (m *memory) ReadArchivedPrefixes(_ context.Context) ([]ArchivedPrefix, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	as := make([]ArchivedPrefix, 0, len(m.archive))
+	for k := len(m.archive) - 1; k >= 0; k-- {
+		a := m.archive[k]
+		a.Prefix = *a.Prefix.deepCopy()
+		as = append(as, a)
+	}
+	return as, nil
+}
+
+func (m *memory) ReadArchivedPrefix(_ context.Context, id string) (ArchivedPrefix, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, a := range m.archive {
+		if a.ID == id {
+			a.Prefix = *a.Prefix.deepCopy()
+			return a, nil
+		}
+	}
+	return ArchivedPrefix{}, fmt.Errorf("%w: archived prefix %s", ErrNotFound, id)
+}
+
+func (m *memory) DeleteArchivedPrefix(_ context.Context, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for k, a := range m.archive {
+		if a.ID == id {
+			m.archive = append(m.archive[:k:k], m.archive[k+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: archived prefix %s", ErrNotFound, id)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -12,20 +13,56 @@ import (
 )
 
 const dbIndex = `prefix.cidr`
+const idcKey = `prefix.idc`
+const vrfKey = `prefix.vrf`
 const versionKey = `version`
 
+// legacyIndexName is the index which made a cidr unique across all namespaces.
+const legacyIndexName = `prefix.cidr_1`
+
 type MongoConfig struct {
-	DatabaseName       string
-	CollectionName     string
-	MongoClientOptions *options.ClientOptions
+	DatabaseName   string
+	CollectionName string
+	// IPCollectionName selects the per-ip layout if set, every acquired ip is then
+	// stored as a document of its own in this collection.
+	IPCollectionName string
+	// LockCollectionName is the collection of the locks serializing structural changes
+	// across all instances using this database, it defaults to locks.
+	LockCollectionName string
+	// HistoryCollectionName is the collection of the snapshots taken every time a prefix is written,
+	// it defaults to prefix_history.
+	HistoryCollectionName string
+	// IPHistoryCollectionName is the collection of the writes of single ips in the per-ip layout,
+	// it defaults to ip_history. Snapshots of prefixes are then only taken if the prefix itself changes.
+	IPHistoryCollectionName string
+	// HistoryRetention is how long snapshots and writes of ips are kept, they are kept forever if it is zero.
+	HistoryRetention time.Duration
+	// OnHistoryError is called if the snapshot of a prefix written outside of a transaction can not be stored,
+	// the write itself is kept. Inside a transaction the failure aborts the transaction.
+	OnHistoryError func(err error)
+	// ArchiveCollectionName is the collection deleted prefixes are kept in until they are purged,
+	// it defaults to prefix_archive.
+	ArchiveCollectionName string
+	MongoClientOptions    *options.ClientOptions
 }
 
 type mongodb struct {
-	c    *mongo.Collection
-	lock sync.RWMutex
+	c       *mongo.Collection
+	history *mongo.Collection
+	archive *mongo.Collection
+	lock    sync.RWMutex
+	locker  *mongoLocker
+	// onHistoryError is MongoConfig.OnHistoryError
+	onHistoryError func(err error)
 }
 
+// NewMongo returns a Storage keeping the prefixes in mongodb. Prefixes are changed in multi-document
+// transactions, the server must therefore be a replica set, a single node replica set is enough,
+// or a sharded cluster. A standalone server is refused.
 func NewMongo(ctx context.Context, config MongoConfig) (Storage, error) {
+	if config.IPCollectionName != "" {
+		return newMongoIPs(ctx, config)
+	}
 	return newMongo(ctx, config)
 }
 
@@ -47,24 +84,88 @@ func newMongo(ctx context.Context, config MongoConfig) (*mongodb, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = checkTopology(ctx, m)
+	if err != nil {
+		return nil, err
+	}
 
 	c := m.Database(config.DatabaseName).Collection(config.CollectionName)
 
 	_, err = c.Indexes().CreateMany(ctx, []mongo.IndexModel{{
-		Keys:    bson.M{dbIndex: 1},
+		Keys:    bson.D{{Key: idcKey, Value: 1}, {Key: vrfKey, Value: 1}, {Key: dbIndex, Value: 1}},
 		Options: options.Index().SetUnique(true),
 	}})
 	if err != nil {
 		return nil, err
 	}
-	return &mongodb{c, sync.RWMutex{}}, nil
+	// the same cidr may exist in several namespaces, drop the old unique index if it is still there.
+	_, err = c.Indexes().DropOne(ctx, legacyIndexName)
+	var cmdErr mongo.CommandError
+	if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Name == "IndexNotFound") {
+		return nil, err
+	}
+	lockCollection := config.LockCollectionName
+	if lockCollection == "" {
+		lockCollection = defaultLockCollectionName
+	}
+	locker, err := newMongoLocker(ctx, m.Database(config.DatabaseName).Collection(lockCollection))
+	if err != nil {
+		return nil, err
+	}
+	historyCollection := config.HistoryCollectionName
+	if historyCollection == "" {
+		historyCollection = defaultHistoryCollectionName
+	}
+	history, err := newMongoHistory(ctx, m.Database(config.DatabaseName).Collection(historyCollection), config.HistoryRetention)
+	if err != nil {
+		return nil, err
+	}
+	archiveCollection := config.ArchiveCollectionName
+	if archiveCollection == "" {
+		archiveCollection = defaultArchiveCollectionName
+	}
+	archive, err := newMongoArchive(ctx, m.Database(config.DatabaseName).Collection(archiveCollection))
+	if err != nil {
+		return nil, err
+	}
+	return &mongodb{c: c, history: history, archive: archive, locker: locker, onHistoryError: config.OnHistoryError}, nil
+}
+
+// checkTopology returns an error if the server does not support transactions,
+// i.e. it is neither a member of a replica set nor a mongos of a sharded cluster.
+func checkTopology(ctx context.Context, c *mongo.Client) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := c.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Name == "CommandNotFound" {
+		// servers before 4.4.2 only know the legacy name of hello
+		err = c.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to determine the mongodb topology: %w", err)
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errors.New("mongodb is a standalone server, transactions need a replica set or a sharded cluster, e.g. start mongod with --replSet and run rs.initiate()")
+	}
+	return nil
 }
 
 func (m *mongodb) CreatePrefix(ctx context.Context, prefix Prefix) (Prefix, error) {
+	p, err := m.createPrefix(ctx, prefix)
+	if err != nil {
+		return Prefix{}, err
+	}
+	return p, m.recordVersion(ctx, p, false)
+}
+
+func (m *mongodb) createPrefix(ctx context.Context, prefix Prefix) (Prefix, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	f := bson.D{{Key: dbIndex, Value: prefix.Cidr}}
+	f := prefixFilter(prefix.Cidr, prefix.Namespace())
 	r := m.c.FindOne(ctx, f)
 
 	// ErrNoDocuments should be returned if the prefix does not exist
@@ -81,11 +182,11 @@ func (m *mongodb) CreatePrefix(ctx context.Context, prefix Prefix) (Prefix, erro
 	return prefix, nil
 }
 
-func (m *mongodb) ReadPrefix(ctx context.Context, prefix string) (Prefix, error) {
+func (m *mongodb) ReadPrefix(ctx context.Context, prefix string, namespace Namespace) (Prefix, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	f := bson.D{{Key: dbIndex, Value: prefix}}
+	f := prefixFilter(prefix, namespace)
 	r := m.c.FindOne(ctx, f)
 
 	// ErrNoDocuments should be returned if the prefix does not exist
@@ -137,26 +238,43 @@ func (m *mongodb) ReadAllPrefixes(ctx context.Context) (Prefixes, error) {
 	return s, nil
 }
 
-func (m *mongodb) ReadAllPrefixCidrs(ctx context.Context) ([]string, error) {
-	p, err := m.ReadAllPrefixes(ctx)
+func (m *mongodb) ReadAllPrefixCidrs(ctx context.Context, namespace Namespace) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	f := bson.D{{Key: idcKey, Value: namespace.IDC}, {Key: vrfKey, Value: namespace.VRF}}
+	c, err := m.c.Find(ctx, f)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf(`error reading prefixes of namespace %s: %w`, namespace, err)
+	}
+	var r []prefixJSON
+	if err := c.All(ctx, &r); err != nil {
+		return nil, fmt.Errorf(`error reading prefixes of namespace %s: %w`, namespace, err)
 	}
-	var s = make([]string, len(p))
-	for i, v := range p {
+
+	var s = make([]string, len(r))
+	for i, v := range r {
 		s[i] = v.Cidr
 	}
 	return s, nil
 }
 
 func (m *mongodb) UpdatePrefix(ctx context.Context, prefix Prefix) (Prefix, error) {
+	p, err := m.updatePrefix(ctx, prefix)
+	if err != nil {
+		return Prefix{}, err
+	}
+	return p, m.recordVersion(ctx, p, false)
+}
+
+func (m *mongodb) updatePrefix(ctx context.Context, prefix Prefix) (Prefix, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
 	oldVersion := prefix.version
 	prefix.version = oldVersion + 1
 
-	f := bson.D{{Key: dbIndex, Value: prefix.Cidr}, {Key: versionKey, Value: oldVersion}}
+	f := append(prefixFilter(prefix.Cidr, prefix.Namespace()), bson.E{Key: versionKey, Value: oldVersion})
 
 	o := options.Replace().SetUpsert(false)
 	r, err := m.c.ReplaceOne(ctx, f, prefix.toPrefixJSON(), o)
@@ -175,10 +293,18 @@ func (m *mongodb) UpdatePrefix(ctx context.Context, prefix Prefix) (Prefix, erro
 }
 
 func (m *mongodb) DeletePrefix(ctx context.Context, prefix Prefix) (Prefix, error) {
+	p, err := m.deletePrefix(ctx, prefix)
+	if err != nil {
+		return Prefix{}, err
+	}
+	return p, m.recordVersion(ctx, p, true)
+}
+
+func (m *mongodb) deletePrefix(ctx context.Context, prefix Prefix) (Prefix, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	f := bson.D{{Key: dbIndex, Value: prefix.Cidr}}
+	f := prefixFilter(prefix.Cidr, prefix.Namespace())
 	r := m.c.FindOneAndDelete(ctx, f)
 
 	// ErrNoDocuments should be returned if the prefix does not exist
@@ -195,3 +321,35 @@ func (m *mongodb) DeletePrefix(ctx context.Context, prefix Prefix) (Prefix, erro
 	}
 	return j.toPrefix(), nil
 }
+
+// RunInTransaction runs fn in a multi-document transaction, the context passed to fn carries its session.
+// Transactions need a replica set or sharded cluster, which newMongo checks. fn is called again on transient transaction errors.
+func (m *mongodb) RunInTransaction(ctx context.Context, fn func(ctx context.Context, tx Storage) error) error {
+	return m.runInTransaction(ctx, m, fn)
+}
+
+func (m *mongodb) runInTransaction(ctx context.Context, s Storage, fn func(ctx context.Context, tx Storage) error) error {
+	session, err := m.c.Database().Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("unable to start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc, s)
+	})
+	return err
+}
+
+// Lock makes the mongodb storage a Locker shared by all instances using the database.
+func (m *mongodb) Lock(ctx context.Context, name string) (func(), error) {
+	return m.locker.Lock(ctx, name)
+}
+
+func (m *mongodb) Holders(ctx context.Context) ([]LockHolder, error) {
+	return m.locker.Holders(ctx)
+}
+
+// prefixFilter matches the document of the given cidr in namespace.
+func prefixFilter(cidr string, namespace Namespace) bson.D {
+	return bson.D{{Key: idcKey, Value: namespace.IDC}, {Key: vrfKey, Value: namespace.VRF}, {Key: dbIndex, Value: cidr}}
+}
@@ -0,0 +1,94 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultArchiveCollectionName is used if MongoConfig has no ArchiveCollectionName.
+const defaultArchiveCollectionName = `prefix_archive`
+
+// archiveDocument is a deleted prefix kept in the archive.
+type archiveDocument struct {
+	ID        string     `bson:"_id"`
+	DeletedBy string     `bson:"deletedby"`
+	DeletedAt time.Time  `bson:"deletedat"`
+	Prefix    prefixJSON `bson:"prefix"`
+}
+
+func (d archiveDocument) toArchivedPrefix() ArchivedPrefix {
+	return ArchivedPrefix{ID: d.ID, Prefix: d.Prefix.toPrefix(), DeletedBy: d.DeletedBy, DeletedAt: d.DeletedAt}
+}
+
+func newMongoArchive(ctx context.Context, c *mongo.Collection) (*mongo.Collection, error) {
+	_, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "deletedat", Value: -1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (m *mongodb) ArchivePrefix(ctx context.Context, a ArchivedPrefix) (ArchivedPrefix, error) {
+	a.ID = primitive.NewObjectID().Hex()
+	// mongodb stores times with millisecond precision.
+	a.DeletedAt = a.DeletedAt.Truncate(time.Millisecond)
+	_, err := m.archive.InsertOne(ctx, archiveDocument{
+		ID:        a.ID,
+		DeletedBy: a.DeletedBy,
+		DeletedAt: a.DeletedAt,
+		Prefix:    a.Prefix.toPrefixJSON(),
+	})
+	if err != nil {
+		return ArchivedPrefix{}, fmt.Errorf("unable to archive prefix:%s, error:%w", a.Prefix.Cidr, err)
+	}
+	return a, nil
+}
+
+func (m *mongodb) ReadArchivedPrefixes(ctx context.Context) ([]ArchivedPrefix, error) {
+	o := options.Find().SetSort(bson.D{{Key: "deletedat", Value: -1}, {Key: "_id", Value: -1}})
+	c, err := m.archive.Find(ctx, bson.D{}, o)
+	if err != nil {
+		return nil, fmt.Errorf(`error reading archived prefixes: %w`, err)
+	}
+	var docs []archiveDocument
+	if err := c.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf(`error reading archived prefixes: %w`, err)
+	}
+	as := make([]ArchivedPrefix, 0, len(docs))
+	for _, d := range docs {
+		as = append(as, d.toArchivedPrefix())
+	}
+	return as, nil
+}
+
+func (m *mongodb) ReadArchivedPrefix(ctx context.Context, id string) (ArchivedPrefix, error) {
+	r := m.archive.FindOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if errors.Is(r.Err(), mongo.ErrNoDocuments) {
+		return ArchivedPrefix{}, fmt.Errorf("%w: archived prefix %s", ErrNotFound, id)
+	}
+	var d archiveDocument
+	if err := r.Decode(&d); err != nil {
+		return ArchivedPrefix{}, fmt.Errorf(`error reading archived prefix %s: %w`, id, err)
+	}
+	return d.toArchivedPrefix(), nil
+}
+
+func (m *mongodb) DeleteArchivedPrefix(ctx context.Context, id string) error {
+	r, err := m.archive.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return fmt.Errorf("unable to delete archived prefix %s: %w", id, err)
+	}
+	if r.DeletedCount == 0 {
+		return fmt.Errorf("%w: archived prefix %s", ErrNotFound, id)
+	}
+	return nil
+}
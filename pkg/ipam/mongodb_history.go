@@ -0,0 +1,105 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultHistoryCollectionName is used if MongoConfig has no HistoryCollectionName.
+const defaultHistoryCollectionName = `prefix_history`
+
+// versionDocument is the snapshot of a prefix taken when it was written.
+type versionDocument struct {
+	IDC     string     `bson:"idc"`
+	VRF     string     `bson:"vrf"`
+	Cidr    string     `bson:"cidr"`
+	Version int64      `bson:"version"`
+	Time    time.Time  `bson:"time"`
+	Deleted bool       `bson:"deleted"`
+	Prefix  prefixJSON `bson:"prefix"`
+}
+
+// historyTTLIndexName is the index removing snapshots older than MongoConfig.HistoryRetention.
+const historyTTLIndexName = `time_ttl`
+
+func newMongoHistory(ctx context.Context, c *mongo.Collection, retention time.Duration) (*mongo.Collection, error) {
+	_, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "idc", Value: 1}, {Key: "vrf", Value: 1}, {Key: "cidr", Value: 1}, {Key: "time", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the retention may have changed since the index was created, it is created again.
+	_, err = c.Indexes().DropOne(ctx, historyTTLIndexName)
+	var cmdErr mongo.CommandError
+	if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Name == "IndexNotFound") {
+		return nil, err
+	}
+	if retention > 0 {
+		_, err = c.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "time", Value: 1}},
+			Options: options.Index().SetName(historyTTLIndexName).SetExpireAfterSeconds(int32(retention / time.Second)),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// recordVersion stores a snapshot of prefix. Inside a transaction a failure aborts the transaction,
+// outside of one the prefix is written already and the failure is only reported to onHistoryError.
+func (m *mongodb) recordVersion(ctx context.Context, prefix Prefix, deleted bool) error {
+	_, err := m.history.InsertOne(ctx, versionDocument{
+		IDC:     prefix.IDC,
+		VRF:     prefix.VRF,
+		Cidr:    prefix.Cidr,
+		Version: prefix.version,
+		Time:    time.Now(),
+		Deleted: deleted,
+		Prefix:  prefix.toPrefixJSON(),
+	})
+	if err != nil {
+		err = fmt.Errorf("unable to store version %d of prefix:%s, error:%w", prefix.version, prefix.Cidr, err)
+	}
+	return m.historyError(ctx, err)
+}
+
+// historyError returns err if ctx carries a transaction, otherwise it only reports it to onHistoryError.
+func (m *mongodb) historyError(ctx context.Context, err error) error {
+	if err == nil || mongo.SessionFromContext(ctx) != nil {
+		return err
+	}
+	if m.onHistoryError != nil {
+		m.onHistoryError(err)
+	}
+	return nil
+}
+
+func (m *mongodb) PrefixVersions(ctx context.Context, namespace Namespace, cidrs []string, until time.Time) ([]PrefixVersion, error) {
+	f := bson.D{{Key: "idc", Value: namespace.IDC}, {Key: "vrf", Value: namespace.VRF}, {Key: "cidr", Value: bson.M{"$in": cidrs}}}
+	if !until.IsZero() {
+		f = append(f, bson.E{Key: "time", Value: bson.M{"$lte": until}})
+	}
+	// snapshots taken within the same millisecond keep the order of their insertion by the ObjectID.
+	o := options.Find().SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}})
+	c, err := m.history.Find(ctx, f, o)
+	if err != nil {
+		return nil, fmt.Errorf(`error reading versions of namespace %s: %w`, namespace, err)
+	}
+	var docs []versionDocument
+	if err := c.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf(`error reading versions of namespace %s: %w`, namespace, err)
+	}
+	vs := make([]PrefixVersion, 0, len(docs))
+	for _, d := range docs {
+		vs = append(vs, PrefixVersion{Prefix: d.Prefix.toPrefix(), Version: d.Version, Time: d.Time, Deleted: d.Deleted})
+	}
+	return vs, nil
+}
@@ -0,0 +1,379 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go4.org/netipx"
+)
+
+// ipDocument is a single acquired ip of a prefix in the per-ip layout.
+type ipDocument struct {
+	IDC    string   `bson:"idc"`
+	VRF    string   `bson:"vrf"`
+	Prefix string   `bson:"prefix"`
+	IP     string   `bson:"ip"`
+	Detail IPDetail `bson:"detail"`
+}
+
+// defaultIPHistoryCollectionName is used if MongoConfig has no IPHistoryCollectionName.
+const defaultIPHistoryCollectionName = `ip_history`
+
+const (
+	// ipSet is the op of an ip change storing the ip with its IPDetail.
+	ipSet = "set"
+	// ipDeleted is the op of an ip change removing the ip.
+	ipDeleted = "deleted"
+)
+
+// ipChangeDocument is a write of a single ip of a prefix in the per-ip layout.
+type ipChangeDocument struct {
+	IDC     string    `bson:"idc"`
+	VRF     string    `bson:"vrf"`
+	Cidr    string    `bson:"cidr"`
+	IP      string    `bson:"ip"`
+	Version int64     `bson:"version"`
+	Op      string    `bson:"op"`
+	Time    time.Time `bson:"time"`
+	// Detail is the IPDetail stored, for ipDeleted the one removed.
+	Detail IPDetail `bson:"detail"`
+}
+
+// mongodbIPs stores the prefixes like mongodb, but keeps every ip with an IPDetail in a document
+// of its own. Acquiring and releasing ips are single document inserts and deletes, the prefix
+// document and its version only change on structural changes. A snapshot of the prefix is only
+// taken if its version changes, the writes of ips are recorded as changes of single ips.
+type mongodbIPs struct {
+	*mongodb
+	ips       *mongo.Collection
+	ipHistory *mongo.Collection
+}
+
+func newMongoIPs(ctx context.Context, config MongoConfig) (*mongodbIPs, error) {
+	m, err := newMongo(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	ips := m.c.Database().Collection(config.IPCollectionName)
+	_, err = ips.Indexes().CreateMany(ctx, []mongo.IndexModel{{
+		Keys:    bson.D{{Key: "idc", Value: 1}, {Key: "vrf", Value: 1}, {Key: "prefix", Value: 1}, {Key: "ip", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}})
+	if err != nil {
+		return nil, err
+	}
+	ipHistoryCollection := config.IPHistoryCollectionName
+	if ipHistoryCollection == "" {
+		ipHistoryCollection = defaultIPHistoryCollectionName
+	}
+	ipHistory, err := newMongoHistory(ctx, m.c.Database().Collection(ipHistoryCollection), config.HistoryRetention)
+	if err != nil {
+		return nil, err
+	}
+	_, err = ipHistory.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "idc", Value: 1}, {Key: "vrf", Value: 1}, {Key: "ip", Value: 1}, {Key: "time", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mongodbIPs{mongodb: m, ips: ips, ipHistory: ipHistory}, nil
+}
+
+func (m *mongodbIPs) Name() string {
+	return "mongodb-ips"
+}
+
+func (m *mongodbIPs) CreatePrefix(ctx context.Context, prefix Prefix) (Prefix, error) {
+	_, err := m.createPrefix(ctx, prefix.withoutIPs())
+	if err != nil {
+		return Prefix{}, err
+	}
+	ips := make([]string, 0, len(prefix.Ips))
+	for ip := range prefix.Ips {
+		ips = append(ips, ip)
+	}
+	err = m.createIPs(ctx, prefix, ips)
+	if err != nil {
+		_, _ = m.deletePrefix(ctx, prefix)
+		return Prefix{}, fmt.Errorf("unable to insert ips of prefix:%s, error:%w", prefix.Cidr, err)
+	}
+	return prefix, m.recordVersion(ctx, prefix, false)
+}
+
+func (m *mongodbIPs) ReadPrefix(ctx context.Context, prefix string, namespace Namespace) (Prefix, error) {
+	p, err := m.mongodb.ReadPrefix(ctx, prefix, namespace)
+	if err != nil {
+		return Prefix{}, err
+	}
+	docs, err := m.readIPs(ctx, ipsFilter(p))
+	if err != nil {
+		return Prefix{}, err
+	}
+	p.mergeIPs(docs)
+	return p, nil
+}
+
+func (m *mongodbIPs) DeleteAllPrefixes(ctx context.Context) error {
+	err := m.mongodb.DeleteAllPrefixes(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = m.ips.DeleteMany(ctx, bson.D{{}})
+	if err != nil {
+		return fmt.Errorf(`error deleting all ips: %w`, err)
+	}
+	return nil
+}
+
+func (m *mongodbIPs) ReadAllPrefixes(ctx context.Context) (Prefixes, error) {
+	ps, err := m.mongodb.ReadAllPrefixes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := m.readIPs(ctx, bson.D{{}})
+	if err != nil {
+		return nil, err
+	}
+	type key struct {
+		namespace Namespace
+		cidr      string
+	}
+	byPrefix := make(map[key][]ipDocument)
+	for _, d := range docs {
+		k := key{Namespace{IDC: d.IDC, VRF: d.VRF}, d.Prefix}
+		byPrefix[k] = append(byPrefix[k], d)
+	}
+	for i := range ps {
+		ps[i].mergeIPs(byPrefix[key{ps[i].Namespace(), ps[i].Cidr}])
+	}
+	return ps, nil
+}
+
+// UpdatePrefix only writes the structural part of the prefix, ips are written with
+// CreateIPs, UpdateIPs and DeleteIPs.
+func (m *mongodbIPs) UpdatePrefix(ctx context.Context, prefix Prefix) (Prefix, error) {
+	updated, err := m.updatePrefix(ctx, prefix.withoutIPs())
+	if err != nil {
+		return Prefix{}, err
+	}
+	prefix.version = updated.version
+	return prefix, m.recordVersion(ctx, prefix, false)
+}
+
+func (m *mongodbIPs) DeletePrefix(ctx context.Context, prefix Prefix) (Prefix, error) {
+	docs, err := m.readIPs(ctx, ipsFilter(prefix))
+	if err != nil {
+		return Prefix{}, err
+	}
+	p, err := m.deletePrefix(ctx, prefix)
+	if err != nil {
+		return Prefix{}, err
+	}
+	_, err = m.ips.DeleteMany(ctx, ipsFilter(prefix))
+	if err != nil {
+		return Prefix{}, fmt.Errorf(`error deleting ips of prefix:%s, error:%w`, prefix.Cidr, err)
+	}
+	p.mergeIPs(docs)
+	return p, m.recordVersion(ctx, p, true)
+}
+
+// RunInTransaction runs fn in a multi-document transaction covering prefix and ip documents.
+func (m *mongodbIPs) RunInTransaction(ctx context.Context, fn func(ctx context.Context, tx Storage) error) error {
+	return m.runInTransaction(ctx, m, fn)
+}
+
+func (m *mongodbIPs) CreateIPs(ctx context.Context, prefix Prefix, ips []string) error {
+	if len(ips) == 0 {
+		return nil
+	}
+	err := m.createIPs(ctx, prefix, ips)
+	if err != nil {
+		return err
+	}
+	return m.recordIPChanges(ctx, prefix, ips, ipSet)
+}
+
+func (m *mongodbIPs) createIPs(ctx context.Context, prefix Prefix, ips []string) error {
+	if len(ips) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, 0, len(ips))
+	for _, ip := range ips {
+		docs = append(docs, ipDocumentOf(prefix, ip))
+	}
+	_, err := m.ips.InsertMany(ctx, docs, options.InsertMany().SetOrdered(true))
+	if err == nil {
+		return nil
+	}
+	// an ordered insert stops at the first failing document, remove the ones inserted before it.
+	// Without a write error it is unknown which were inserted, none are removed then.
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && len(bwe.WriteErrors) > 0 {
+		if inserted := bwe.WriteErrors[0].Index; inserted > 0 {
+			_ = m.deleteIPs(ctx, prefix, ips[:inserted])
+		}
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: ip acquired concurrently in prefix:%s", ErrOptimisticLockError, prefix.Cidr)
+	}
+	return fmt.Errorf("unable to insert ips of prefix:%s, error:%w", prefix.Cidr, err)
+}
+
+func (m *mongodbIPs) UpdateIPs(ctx context.Context, prefix Prefix, ips []string) error {
+	if len(ips) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(ips))
+	for _, ip := range ips {
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(append(ipsFilter(prefix), bson.E{Key: "ip", Value: ip})).
+			SetReplacement(ipDocumentOf(prefix, ip)).
+			SetUpsert(true))
+	}
+	_, err := m.ips.BulkWrite(ctx, models)
+	if err != nil {
+		return fmt.Errorf("unable to update ips of prefix:%s, error:%w", prefix.Cidr, err)
+	}
+	return m.recordIPChanges(ctx, prefix, ips, ipSet)
+}
+
+func (m *mongodbIPs) DeleteIPs(ctx context.Context, prefix Prefix, ips []string) error {
+	if len(ips) == 0 {
+		return nil
+	}
+	err := m.deleteIPs(ctx, prefix, ips)
+	if err != nil {
+		return err
+	}
+	return m.recordIPChanges(ctx, prefix, ips, ipDeleted)
+}
+
+func (m *mongodbIPs) deleteIPs(ctx context.Context, prefix Prefix, ips []string) error {
+	f := append(ipsFilter(prefix), bson.E{Key: "ip", Value: bson.M{"$in": ips}})
+	_, err := m.ips.DeleteMany(ctx, f)
+	if err != nil {
+		return fmt.Errorf("unable to delete ips of prefix:%s, error:%w", prefix.Cidr, err)
+	}
+	return nil
+}
+
+// recordIPChanges records the writes of ips of prefix with op, the prefix document does not change
+// when only ips are written. Like recordVersion a failure only aborts a transaction.
+func (m *mongodbIPs) recordIPChanges(ctx context.Context, prefix Prefix, ips []string, op string) error {
+	now := time.Now()
+	docs := make([]interface{}, 0, len(ips))
+	for _, ip := range ips {
+		docs = append(docs, ipChangeDocument{
+			IDC:     prefix.IDC,
+			VRF:     prefix.VRF,
+			Cidr:    prefix.Cidr,
+			IP:      ip,
+			Version: prefix.version,
+			Op:      op,
+			Time:    now,
+			Detail:  prefix.Ips[ip],
+		})
+	}
+	_, err := m.ipHistory.InsertMany(ctx, docs)
+	if err != nil {
+		err = fmt.Errorf("unable to store changes of ips of prefix:%s, error:%w", prefix.Cidr, err)
+	}
+	return m.historyError(ctx, err)
+}
+
+func (m *mongodbIPs) IPChanges(ctx context.Context, namespace Namespace, cidrs []string, ip string, since, until time.Time) ([]IPChange, error) {
+	f := bson.D{{Key: "idc", Value: namespace.IDC}, {Key: "vrf", Value: namespace.VRF}, {Key: "cidr", Value: bson.M{"$in": cidrs}}}
+	if ip != "" {
+		f = append(f, bson.E{Key: "ip", Value: ip})
+	}
+	t := bson.M{}
+	if !since.IsZero() {
+		t["$gte"] = since
+	}
+	if !until.IsZero() {
+		t["$lte"] = until
+	}
+	if len(t) > 0 {
+		f = append(f, bson.E{Key: "time", Value: t})
+	}
+	o := options.Find().SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}})
+	c, err := m.ipHistory.Find(ctx, f, o)
+	if err != nil {
+		return nil, fmt.Errorf(`error reading changes of ips of namespace %s: %w`, namespace, err)
+	}
+	var docs []ipChangeDocument
+	if err := c.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf(`error reading changes of ips of namespace %s: %w`, namespace, err)
+	}
+	changes := make([]IPChange, 0, len(docs))
+	for _, d := range docs {
+		changes = append(changes, IPChange{
+			Cidr:     d.Cidr,
+			IP:       d.IP,
+			Version:  d.Version,
+			Time:     d.Time,
+			Released: d.Op == ipDeleted,
+			Detail:   d.Detail,
+		})
+	}
+	return changes, nil
+}
+
+func (m *mongodbIPs) readIPs(ctx context.Context, f bson.D) ([]ipDocument, error) {
+	c, err := m.ips.Find(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf(`error reading ips: %w`, err)
+	}
+	var docs []ipDocument
+	if err := c.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf(`error reading ips: %w`, err)
+	}
+	return docs, nil
+}
+
+// ipsFilter matches all ip documents of prefix.
+func ipsFilter(prefix Prefix) bson.D {
+	return bson.D{{Key: "idc", Value: prefix.IDC}, {Key: "vrf", Value: prefix.VRF}, {Key: "prefix", Value: prefix.Cidr}}
+}
+
+func ipDocumentOf(prefix Prefix, ip string) ipDocument {
+	return ipDocument{IDC: prefix.IDC, VRF: prefix.VRF, Prefix: prefix.Cidr, IP: ip, Detail: prefix.Ips[ip]}
+}
+
+// withoutIPs returns the structural part of the prefix as stored in the prefix document
+// of the per-ip layout, only acquired ips without an IPDetail stay in its allocation index.
+func (p Prefix) withoutIPs() Prefix {
+	s := *p.deepCopy()
+	var b netipx.IPSetBuilder
+	b.AddSet(s.allocatedSet())
+	for ip := range s.Ips {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			b.Remove(addr)
+		}
+	}
+	s.allocated, _ = b.IPSet()
+	s.Ips = make(map[string]IPDetail)
+	return s
+}
+
+// mergeIPs adds the ips stored in documents of their own to the prefix.
+func (p *Prefix) mergeIPs(docs []ipDocument) {
+	if p.Ips == nil {
+		p.Ips = make(map[string]IPDetail)
+	}
+	var b netipx.IPSetBuilder
+	b.AddSet(p.allocatedSet())
+	for _, d := range docs {
+		if addr, err := netip.ParseAddr(d.IP); err == nil {
+			b.Add(addr)
+		}
+		p.Ips[d.IP] = d.Detail
+	}
+	p.allocated, _ = b.IPSet()
+}
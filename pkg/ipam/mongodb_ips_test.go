@@ -0,0 +1,53 @@
+package ipam
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"go4.org/netipx"
+)
+
+func TestWithoutIPsMergeIPs(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/24", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	if _, err := i.AcquireIP(ctx, "10.0.0.0/24", IPDetail{User: "a"}, 3, AcquireIPOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	p := i.PrefixFrom(ctx, "10.0.0.0/24")
+	// addresses acquired without an IPDetail stay in the prefix document
+	p.acquireRange(netipx.MustParseIPRange("10.0.0.20-10.0.0.23"))
+	before := *p.deepCopy()
+
+	stored := p.withoutIPs()
+	if len(stored.Ips) != 0 {
+		t.Errorf("got ips %v in the prefix document, want none", stored.Ips)
+	}
+	for ip := range p.Ips {
+		if stored.allocatedSet().Contains(netip.MustParseAddr(ip)) {
+			t.Errorf("ip %s with an IPDetail is in the allocation index of the prefix document", ip)
+		}
+	}
+	if !stored.allocatedSet().Contains(netip.MustParseAddr("10.0.0.21")) {
+		t.Error("ip 10.0.0.21 without an IPDetail is missing in the allocation index of the prefix document")
+	}
+
+	docs := make([]ipDocument, 0, len(p.Ips))
+	for ip := range p.Ips {
+		docs = append(docs, ipDocumentOf(*p, ip))
+	}
+	stored.mergeIPs(docs)
+	if !stored.allocatedSet().Equal(p.allocatedSet()) {
+		t.Errorf("got allocated %v after the round trip, want %v", encodeAllocated(stored.allocatedSet()), encodeAllocated(p.allocatedSet()))
+	}
+	if !reflect.DeepEqual(stored.Ips, p.Ips) {
+		t.Errorf("got ips %v after the round trip, want %v", stored.Ips, p.Ips)
+	}
+
+	// the prefix itself is not changed by withoutIPs
+	if !p.allocatedSet().Equal(before.allocatedSet()) || !reflect.DeepEqual(p.Ips, before.Ips) {
+		t.Error("withoutIPs changed the prefix")
+	}
+}
@@ -0,0 +1,122 @@
+package ipam
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultLockCollectionName is used if MongoConfig has no LockCollectionName.
+const defaultLockCollectionName = `locks`
+
+// lockTTL is the lease of a lock, it is renewed every third of it while the lock is held.
+const lockTTL = 30 * time.Second
+
+// lockRetryInterval is the time between two attempts to take a lock held by someone else.
+const lockRetryInterval = 100 * time.Millisecond
+
+// mongoLocker keeps every held lock as a document with its expiry in a collection shared by all
+// Ipamer instances. A TTL index removes the documents of holders which died, an expired lock
+// can also be taken over before it is removed. Every Lock call writes its own token as owner,
+// so a lock taken over after it expired is neither renewed nor released by its former holder,
+// even if that holder is a goroutine of the same process.
+type mongoLocker struct {
+	c     *mongo.Collection
+	owner string
+}
+
+func newMongoLocker(ctx context.Context, c *mongo.Collection) (*mongoLocker, error) {
+	_, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mongoLocker{c: c, owner: lockOwner()}, nil
+}
+
+// token identifies a single Lock call, it is built of the owner of the process and a random suffix.
+func (l *mongoLocker) token() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return l.owner + "/" + hex.EncodeToString(b)
+}
+
+func (l *mongoLocker) Lock(ctx context.Context, name string) (func(), error) {
+	token := l.token()
+	for {
+		ok, err := l.acquire(ctx, name, token)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-time.After(lockRetryInterval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("unable to lock %s: %w", name, ctx.Err())
+		}
+	}
+	done := make(chan struct{})
+	go l.renew(name, token, done)
+	return func() {
+		close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, _ = l.c.DeleteOne(ctx, bson.D{{Key: "_id", Value: name}, {Key: "owner", Value: token}})
+	}, nil
+}
+
+// acquire takes the lock name for token if it is free or expired, it reports false if someone else holds it.
+func (l *mongoLocker) acquire(ctx context.Context, name, token string) (bool, error) {
+	now := time.Now()
+	f := bson.D{{Key: "_id", Value: name}, {Key: "expires", Value: bson.M{"$lt": now}}}
+	u := bson.M{"$set": bson.M{"owner": token, "acquired": now, "expires": now.Add(lockTTL)}}
+	_, err := l.c.UpdateOne(ctx, f, u, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// the lock document exists and has not expired
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to lock %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// renew extends the lease of the lock name held with token until done is closed.
+func (l *mongoLocker) renew(name, token string, done chan struct{}) {
+	t := time.NewTicker(lockTTL / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			ctx, cancel := context.WithTimeout(context.Background(), lockTTL/3)
+			f := bson.D{{Key: "_id", Value: name}, {Key: "owner", Value: token}}
+			_, _ = l.c.UpdateOne(ctx, f, bson.M{"$set": bson.M{"expires": time.Now().Add(lockTTL)}})
+			cancel()
+		}
+	}
+}
+
+func (l *mongoLocker) Holders(ctx context.Context) ([]LockHolder, error) {
+	f := bson.D{{Key: "expires", Value: bson.M{"$gt": time.Now()}}}
+	c, err := l.c.Find(ctx, f, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf(`error reading locks: %w`, err)
+	}
+	holders := []LockHolder{}
+	if err := c.All(ctx, &holders); err != nil {
+		return nil, fmt.Errorf(`error reading locks: %w`, err)
+	}
+	return holders, nil
+}
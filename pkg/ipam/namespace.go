@@ -0,0 +1,29 @@
+package ipam
+
+import "context"
+
+// Namespace is the address space a Prefix lives in, it is made up of the IDC and the VRF
+// of the prefix. The same cidr can exist once in every namespace and overlap checks
+// only apply to prefixes of the same namespace.
+type Namespace struct {
+	IDC string `json:"idc"`
+	VRF string `json:"vrf"`
+}
+
+func (n Namespace) String() string {
+	return n.IDC + "/" + n.VRF
+}
+
+type namespaceContextKey struct{}
+
+// NewContextWithNamespace returns a copy of ctx which scopes all Ipamer calls made with it
+// to the given namespace.
+func NewContextWithNamespace(ctx context.Context, namespace Namespace) context.Context {
+	return context.WithValue(ctx, namespaceContextKey{}, namespace)
+}
+
+// NamespaceFromContext returns the namespace stored in ctx, the empty namespace is returned if none was set.
+func NamespaceFromContext(ctx context.Context) Namespace {
+	namespace, _ := ctx.Value(namespaceContextKey{}).(Namespace)
+	return namespace
+}
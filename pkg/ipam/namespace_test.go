@@ -0,0 +1,97 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var (
+	prod = Namespace{IDC: "bj", VRF: "prod"}
+	mgmt = Namespace{IDC: "bj", VRF: "mgmt"}
+)
+
+// newTestPrefix creates a prefix in namespace and fails the test if it can not be created.
+func newTestPrefix(t *testing.T, i Ipamer, namespace Namespace, cidr, gateway string, isParent bool, kind string) *Prefix {
+	t.Helper()
+	ctx := NewContextWithNamespace(context.Background(), namespace)
+	p, err := i.NewPrefix(ctx, cidr, gateway, "", 1, namespace.VRF, namespace.IDC, isParent, kind)
+	if err != nil {
+		t.Fatalf("unable to create prefix %s in %s: %v", cidr, namespace, err)
+	}
+	return p
+}
+
+func TestSameCidrInSeveralNamespaces(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/24", "10.0.0.1", false, "")
+	newTestPrefix(t, i, mgmt, "10.0.0.0/24", "10.0.0.1", false, "")
+
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	if _, err := i.NewPrefix(ctx, "10.0.0.0/24", "10.0.0.1", "", 1, prod.VRF, prod.IDC, false, ""); err == nil {
+		t.Fatal("expected the second 10.0.0.0/24 of the namespace to be refused")
+	}
+
+	ips, err := i.AcquireIP(ctx, "10.0.0.0/24", IPDetail{User: "a"}, 2, AcquireIPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mctx := NewContextWithNamespace(context.Background(), mgmt)
+	other := i.PrefixFrom(mctx, "10.0.0.0/24")
+	for _, ip := range ips {
+		if _, ok := other.Ips[ip]; ok {
+			t.Errorf("ip %s acquired in %s is acquired in %s too", ip, prod, mgmt)
+		}
+	}
+	// the same ips are still free in the other namespace
+	got, err := i.AcquireIP(mctx, "10.0.0.0/24", IPDetail{User: "b"}, 2, AcquireIPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != ips[0] || got[1] != ips[1] {
+		t.Errorf("got %v in %s, want %v", got, mgmt, ips)
+	}
+
+	cidrs, err := i.ReadAllPrefixCidrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cidrs) != 1 {
+		t.Errorf("got %d prefixes in %s, want 1", len(cidrs), prod)
+	}
+	all, err := i.ReadAllPrefixes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Errorf("got %d prefixes in all namespaces, want 2", len(all))
+	}
+}
+
+func TestNamespaceOfOverlappingPrefixes(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/16", "", true, "")
+	newTestPrefix(t, i, mgmt, "10.0.1.0/24", "10.0.1.1", false, "")
+
+	// the /24 of mgmt does not become a child of the /16 of prod
+	child := newTestPrefix(t, i, prod, "10.0.1.0/24", "10.0.1.1", false, "")
+	if child.ParentCidr != "10.0.0.0/16" {
+		t.Errorf("got parent %q, want 10.0.0.0/16", child.ParentCidr)
+	}
+	mctx := NewContextWithNamespace(context.Background(), mgmt)
+	if p := i.PrefixFrom(mctx, "10.0.1.0/24"); p.ParentCidr != "" {
+		t.Errorf("prefix of %s got parent %q in another namespace", mgmt, p.ParentCidr)
+	}
+
+	// deleting the prefix of one namespace keeps the other
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	if _, err := i.DeletePrefix(ctx, "10.0.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if i.PrefixFrom(mctx, "10.0.1.0/24") == nil {
+		t.Errorf("prefix of %s deleted with the one of %s", mgmt, prod)
+	}
+	if _, err := i.DeletePrefix(ctx, "10.0.1.0/24"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v deleting a deleted prefix, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,155 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"go4.org/netipx"
+)
+
+// Placements of a child prefix inside a pool of parent prefixes.
+const (
+	// PlacementBestFit takes the child out of the smallest free block it fits in, which keeps
+	// large free blocks available.
+	PlacementBestFit = "bestfit"
+	// PlacementFirstFit takes the child out of the lowest parent prefix with room for it.
+	PlacementFirstFit = "firstfit"
+)
+
+// PoolSelector selects the parent prefixes of a namespace a child prefix can be acquired from.
+type PoolSelector struct {
+	// Tags every selected parent must carry, empty selects all parents.
+	Tags []string
+	// Placement is PlacementBestFit or PlacementFirstFit, empty is PlacementBestFit.
+	Placement string
+}
+
+// matches reports whether p is a parent prefix selected by sel.
+func (sel PoolSelector) matches(p *Prefix) bool {
+	if !p.IsParent || canHoldChildren(p, "") != nil {
+		return false
+	}
+	for _, tag := range sel.Tags {
+		found := false
+		for _, t := range p.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// freeChildSet returns the addresses of the prefix not covered by child prefixes.
+func (p *Prefix) freeChildSet() (*netipx.IPSet, error) {
+	ipprefix, err := netip.ParsePrefix(p.Cidr)
+	if err != nil {
+		return nil, err
+	}
+	var ipsetBuilder netipx.IPSetBuilder
+	ipsetBuilder.AddPrefix(ipprefix)
+	for cp, available := range p.availableChildPrefixes {
+		if available {
+			continue
+		}
+		cpipprefix, err := netip.ParsePrefix(cp)
+		if err != nil {
+			return nil, err
+		}
+		ipsetBuilder.RemovePrefix(cpipprefix)
+	}
+	ipset, err := ipsetBuilder.IPSet()
+	if err != nil {
+		return nil, fmt.Errorf("error constructing ipset:%w", err)
+	}
+	return ipset, nil
+}
+
+func (i *ipamer) AcquireChildPrefixFromPool(ctx context.Context, sel PoolSelector, length uint8) (*Prefix, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var prefix *Prefix
+	err = retryOnOptimisticLock(func() error {
+		return i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+			var err error
+			prefix, err = tx.acquireChildPrefixFromPoolInternal(ctx, sel, int(length))
+			return err
+		})
+	})
+	return prefix, err
+}
+
+func (i *ipamer) acquireChildPrefixFromPoolInternal(ctx context.Context, sel PoolSelector, length int) (*Prefix, error) {
+	switch sel.Placement {
+	case "", PlacementBestFit, PlacementFirstFit:
+	default:
+		return nil, fmt.Errorf("unknown placement:%s", sel.Placement)
+	}
+	namespace := NamespaceFromContext(ctx)
+	ps, err := i.namespacePrefixes(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	var parents []*Prefix
+	for k := range ps {
+		if sel.matches(&ps[k]) {
+			parents = append(parents, &ps[k])
+		}
+	}
+	if len(parents) == 0 {
+		return nil, fmt.Errorf("%w: no parent prefix in %s with tags %s", ErrNotFound, namespace, strings.Join(sel.Tags, ","))
+	}
+	sort.Slice(parents, func(a, b int) bool {
+		pa, _ := netip.ParsePrefix(parents[a].Cidr)
+		pb, _ := netip.ParsePrefix(parents[b].Cidr)
+		return pa.Addr().Less(pb.Addr())
+	})
+
+	var parent *Prefix
+	var block netip.Prefix
+	for _, p := range parents {
+		ipnet, err := netip.ParsePrefix(p.Cidr)
+		if err != nil || ipnet.Bits() >= length || length > ipnet.Addr().BitLen() {
+			continue
+		}
+		free, err := p.freeChildSet()
+		if err != nil {
+			continue
+		}
+		for _, fb := range free.Prefixes() {
+			if fb.Bits() > length {
+				continue
+			}
+			// the smallest free block is the best fit, the first one found the first fit.
+			if parent == nil || sel.Placement != PlacementFirstFit && fb.Bits() > block.Bits() {
+				parent, block = p, fb
+			}
+		}
+		if parent != nil && sel.Placement == PlacementFirstFit {
+			break
+		}
+	}
+	if parent == nil {
+		return nil, fmt.Errorf("%w: no free /%d in the parent prefixes of %s with tags %s", ErrNoIPAvailable, length, namespace, strings.Join(sel.Tags, ","))
+	}
+	child := netip.PrefixFrom(block.Addr(), length)
+	return i.acquireChildPrefixInternal(ctx, parent.Cidr, child.String(), 0)
+}
+
+// EditPrefixTags replaces the tags of the prefix.
+func (i *ipamer) EditPrefixTags(ctx context.Context, prefixCidr string, tags []string) error {
+	return i.modifyPrefix(ctx, prefixCidr, func(p *Prefix) error {
+		p.Tags = tags
+		return nil
+	})
+}
@@ -6,8 +6,7 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
-	"ipam/utils/tools"
-	"math"
+	"math/big"
 	"net/netip"
 	"strings"
 	"time"
@@ -32,22 +31,35 @@ type IPDetail struct {
 	User        string `json:"user"`        //使用人
 	Description string `json:"description"` //描述
 	Date        string `json:"date"`        //分配时间
+	Expires     string `json:"expires"`     //到期时间,为空时永不到期,格式同分配时间
 }
 
 // Prefix is a expression of a ip with length and forms a classless network.
 type Prefix struct {
-	Gateway                string          `json:"gateway"`                //网关
-	VlanID                 int             `json:"vlanid"`                 //Vlan 号
-	VRF                    string          `json:"vrf"`                    //VRF
-	IDC                    string          `json:"idc"`                    //IDC
-	Cidr                   string          `json:"cidr"`                   // The Cidr of this prefix
-	ParentCidr             string          `json:"parentcidr"`             // if this prefix is a child this is a pointer back
-	IsParent               bool            `json:"isparent"`               // if this Prefix has child prefixes, this is set to true
-	availableChildPrefixes map[string]bool `json:"availablechildprefixes"` // available child prefixes of this prefix
+	Gateway                string              `json:"gateway"`                //网关
+	VlanID                 int                 `json:"vlanid"`                 //Vlan 号
+	VRF                    string              `json:"vrf"`                    //VRF
+	IDC                    string              `json:"idc"`                    //IDC
+	Cidr                   string              `json:"cidr"`                   // The Cidr of this prefix
+	ParentCidr             string              `json:"parentcidr"`             // if this prefix is a child this is a pointer back
+	IsParent               bool                `json:"isparent"`               // if this Prefix has child prefixes, this is set to true
+	Kind                   string              `json:"kind"`                   // kind of the prefix, decides which addresses are reserved, see KindSubnet
+	Strategy               string              `json:"strategy"`               // allocation strategy used by AcquireIP, see ParseStrategy
+	Ranges                 []ReservedRange     `json:"ranges"`                 // named ranges skipped by AcquireIP unless requested
+	Template               string              `json:"template"`               // name of the last Template applied to this prefix
+	Tags                   []string            `json:"tags"`                   // tags to select parent prefixes with AcquireChildPrefixFromPool
+	RenumberTo             string              `json:"renumberto"`             // the prefix the addresses of this prefix are renumbered to
+	RenumberFrom           string              `json:"renumberfrom"`           // the prefix whose addresses are renumbered into this prefix
+	Renumbered             map[string]string   `json:"renumbered"`             // old to new address of the applied renumbering
+	Quarantine             string              `json:"quarantine"`             // cooldown of released ips before they are acquired again, see ParseQuarantine
+	Quarantined            map[string]string   `json:"quarantined"`            // released ips and the end of their quarantine
+	Requests               map[string][]string `json:"requests"`               // ips acquired by request id, see AcquireIPOptions.RequestID
+	availableChildPrefixes map[string]bool     `json:"availablechildprefixes"` // available child prefixes of this prefix
 	// TODO remove this in the next release
 	childPrefixLength int                 `json:"childprefixlength"` // the length of the child prefixes
-	Ips               map[string]IPDetail `json:"ips"`               // The ips contained in this prefix
-	version           int64               `json:"version"`           // version is used for optimistic locking
+	Ips               map[string]IPDetail `json:"ips"`               // The ips contained in this prefix which carry an IPDetail
+	allocated         *netipx.IPSet       // all acquired ips of this prefix, including the ones without IPDetail
+	version           int64               `json:"version"` // version is used for optimistic locking
 }
 
 type Prefixes []Prefix
@@ -62,9 +74,21 @@ func (p Prefix) deepCopy() *Prefix {
 		Cidr:                   p.Cidr,
 		ParentCidr:             p.ParentCidr,
 		IsParent:               p.IsParent,
+		Kind:                   p.Kind,
+		Strategy:               p.Strategy,
+		Ranges:                 append([]ReservedRange(nil), p.Ranges...),
+		Template:               p.Template,
+		Tags:                   append([]string(nil), p.Tags...),
+		RenumberTo:             p.RenumberTo,
+		RenumberFrom:           p.RenumberFrom,
+		Renumbered:             copyStrings(p.Renumbered),
+		Quarantine:             p.Quarantine,
+		Quarantined:            copyStrings(p.Quarantined),
+		Requests:               copyRequests(p.Requests),
 		childPrefixLength:      p.childPrefixLength,
 		availableChildPrefixes: copyMap(p.availableChildPrefixes),
 		Ips:                    copyStruct(p.Ips),
+		allocated:              p.allocated,
 		version:                p.version,
 	}
 }
@@ -101,6 +125,10 @@ func (p *Prefix) GobEncode() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = encoder.Encode(encodeAllocated(p.allocated))
+	if err != nil {
+		return nil, err
+	}
 	return w.Bytes(), nil
 }
 
@@ -132,7 +160,17 @@ func (p *Prefix) GobDecode(buf []byte) error {
 	if err != nil {
 		return err
 	}
-	return decoder.Decode(&p.ParentCidr)
+	err = decoder.Decode(&p.ParentCidr)
+	if err != nil {
+		return err
+	}
+	var allocated []string
+	err = decoder.Decode(&allocated)
+	if err != nil {
+		return err
+	}
+	p.allocated = decodeAllocated(allocated)
+	return nil
 }
 
 func copyMap(m map[string]bool) map[string]bool {
@@ -143,6 +181,17 @@ func copyMap(m map[string]bool) map[string]bool {
 	return cm
 }
 
+func copyStrings(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	cm := make(map[string]string, len(m))
+	for k, v := range m {
+		cm[k] = v
+	}
+	return cm
+}
+
 func copyStruct(m map[string]IPDetail) map[string]IPDetail {
 	cm := make(map[string]IPDetail, len(m))
 	for k, v := range m {
@@ -151,77 +200,247 @@ func copyStruct(m map[string]IPDetail) map[string]IPDetail {
 	return cm
 }
 
+// AcquireIPOptions tunes how AcquireIP picks addresses.
+type AcquireIPOptions struct {
+	// Strategy overrides the allocation strategy of the prefix for this request, see ParseStrategy.
+	Strategy string
+	// Contiguous requests num consecutive addresses.
+	Contiguous bool
+	// Align places a contiguous block on a boundary of num rounded up to a power of two.
+	Align bool
+	// Range takes the addresses out of the reserved range with this name,
+	// if empty all reserved ranges are skipped.
+	Range string
+	// MAC acquires the EUI-64 address derived from this MAC address, the prefix must be an IPv6 /64.
+	MAC string
+	// RequestID makes the acquisition idempotent, repeating it with the same id returns the ips
+	// acquired the first time as long as they are not released.
+	RequestID string
+}
+
 // Usage of ips and child Prefixes of a Prefix
 type Usage struct {
 	// AvailableIPs the number of available IPs if this is not a parent prefix
-	// No more than 2^31 available IPs are reported
-	AvailableIPs uint64
+	AvailableIPs *big.Int
 	// AcquiredIPs the number of acquired IPs if this is not a parent prefix
-	AcquiredIPs uint64
+	AcquiredIPs *big.Int
 	// AvailableSmallestPrefixes is the count of available Prefixes with 2 countable Bits
-	// No more than 2^31 available Prefixes are reported
-	AvailableSmallestPrefixes uint64
+	AvailableSmallestPrefixes *big.Int
 	// AvailablePrefixes is a list of prefixes which are available
 	AvailablePrefixes []string
 	// AcquiredPrefixes the number of acquired prefixes if this is a parent prefix
 	AcquiredPrefixes uint64
+	// ReservedIPs the number of addresses inside reserved ranges
+	ReservedIPs *big.Int
+	// Ranges the usage of every reserved range
+	Ranges []RangeUsage
+}
+
+func (i *ipamer) NewPrefix(ctx context.Context, cidr, gateway, parentCidr string, vlanId int, vrf, idc string, isParent bool, kind string) (*Prefix, error) {
+	ctx = NewContextWithNamespace(ctx, Namespace{IDC: idc, VRF: vrf})
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var prefix *Prefix
+	err = i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		var err error
+		prefix, err = tx.newPrefixInternal(ctx, cidr, gateway, parentCidr, vlanId, vrf, idc, isParent, kind)
+		return err
+	})
+	return prefix, err
 }
 
-func (i *ipamer) NewPrefix(ctx context.Context, cidr, gateway, parentCidr string, vlanId int, vrf, idc string, isParent bool) (*Prefix, error) {
-	i.mu.Lock()
-	defer i.mu.Unlock()
-	existingPrefixes, err := i.storage.ReadAllPrefixCidrs(ctx)
+// PrefixOptions are the settings a Prefix is created with by NewPrefixWithOptions.
+type PrefixOptions struct {
+	// Strategy is the allocation strategy, see EditPrefixStrategy.
+	Strategy string   `json:"strategy,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	// Quarantine is the cooldown of released ips, see EditPrefixQuarantine.
+	Quarantine string `json:"quarantine,omitempty"`
+	// Template is applied to the new Prefix if set, see ApplyTemplate.
+	Template *Template `json:"template,omitempty"`
+}
+
+func (i *ipamer) NewPrefixWithOptions(ctx context.Context, cidr, gateway, parentCidr string, vlanId int, vrf, idc string, isParent bool, kind string, opts PrefixOptions) (*Prefix, error) {
+	if _, err := ParseStrategy(opts.Strategy); err != nil {
+		return nil, err
+	}
+	if _, err := ParseQuarantine(opts.Quarantine); err != nil {
+		return nil, err
+	}
+	ctx = NewContextWithNamespace(ctx, Namespace{IDC: idc, VRF: vrf})
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var prefix *Prefix
+	err = i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		p, err := tx.newPrefixInternal(ctx, cidr, gateway, parentCidr, vlanId, vrf, idc, isParent, kind)
+		if err != nil {
+			return err
+		}
+		if opts.Strategy != "" || len(opts.Tags) > 0 || opts.Quarantine != "" {
+			p = tx.PrefixFrom(ctx, p.Cidr)
+			if p == nil {
+				return fmt.Errorf("%w: created prefix:%s", ErrNotFound, cidr)
+			}
+			p.Strategy = opts.Strategy
+			p.Tags = opts.Tags
+			p.Quarantine = opts.Quarantine
+			_, err = tx.storage.UpdatePrefix(ctx, *p)
+			if err != nil {
+				return fmt.Errorf("unable to update prefix:%s error:%w", p.Cidr, err)
+			}
+		}
+		if opts.Template != nil {
+			err = tx.applyTemplateInternal(ctx, p.Cidr, *opts.Template)
+			if err != nil {
+				return err
+			}
+		}
+		prefix = tx.PrefixFrom(ctx, p.Cidr)
+		if prefix == nil {
+			return fmt.Errorf("%w: created prefix:%s", ErrNotFound, cidr)
+		}
+		return nil
+	})
+	return prefix, err
+}
+
+// newPrefixInternal creates the prefix and links it into the tree of its namespace.
+func (i *ipamer) newPrefixInternal(ctx context.Context, cidr, gateway, parentCidr string, vlanId int, vrf, idc string, isParent bool, kind string) (*Prefix, error) {
+	namespace := Namespace{IDC: idc, VRF: vrf}
+	existingPrefixes, err := i.namespacePrefixes(ctx, namespace)
 	if err != nil {
 		return nil, err
 	}
-	p, err := i.newPrefix(cidr, gateway, vlanId, vrf, idc, parentCidr, isParent)
+	p, err := i.newPrefix(cidr, gateway, vlanId, vrf, idc, parentCidr, isParent, kind)
 	if err != nil {
 		return nil, err
 	}
-	err = PrefixesOverlapping(i, existingPrefixes, []string{p.Cidr})
+	// the new prefix becomes a child of the nearest prefix enclosing it
+	// and the parent of the prefixes it encloses.
+	parent, children, err := enclosing(p, existingPrefixes)
 	if err != nil {
 		return nil, err
 	}
+	if parent != nil {
+		if p.ParentCidr != "" && p.ParentCidr != parent.Cidr {
+			return nil, fmt.Errorf("parent of %s must be the nearest enclosing prefix %s", p.Cidr, parent.Cidr)
+		}
+		err = canHoldChildren(parent, p.Cidr)
+		if err != nil {
+			return nil, err
+		}
+		p.ParentCidr = parent.Cidr
+	} else if p.ParentCidr != "" {
+		return nil, fmt.Errorf("parent prefix %s of %s not found", p.ParentCidr, p.Cidr)
+	}
+	for _, c := range children {
+		err = canHoldChildren(p, c.Cidr)
+		if err != nil {
+			return nil, err
+		}
+		p.availableChildPrefixes[c.Cidr] = false
+		p.IsParent = true
+	}
 	newPrefix, err := i.storage.CreatePrefix(ctx, *p)
 	if err != nil {
 		return nil, err
 	}
-
+	err = i.linkPrefix(ctx, &newPrefix, parent, children)
+	if err != nil {
+		return nil, err
+	}
 	return &newPrefix, nil
 }
 
 func (i *ipamer) DeletePrefix(ctx context.Context, cidr string) (*Prefix, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var prefix *Prefix
+	err = i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		var err error
+		prefix, err = tx.deletePrefixInternal(ctx, cidr)
+		return err
+	})
+	return prefix, err
+}
+
+// deletePrefixInternal deletes the prefix and removes it from the child prefixes of its parent.
+func (i *ipamer) deletePrefixInternal(ctx context.Context, cidr string) (*Prefix, error) {
 	p := i.PrefixFrom(ctx, cidr)
 	if p == nil {
 		return nil, fmt.Errorf("%w: delete prefix:%s", ErrNotFound, cidr)
 	}
-	// if p.hasIPs() {
-	// 	return nil, fmt.Errorf("prefix %s has ips, delete prefix not possible", p.Cidr)
-	// }
+	if p.hasIPs() {
+		return nil, fmt.Errorf("prefix %s has ips, delete prefix not possible", p.Cidr)
+	}
+	if p.hasChildPrefixes() {
+		return nil, fmt.Errorf("prefix %s has child prefixes, delete prefix not possible", p.Cidr)
+	}
+	if p.RenumberTo != "" || p.RenumberFrom != "" {
+		return nil, fmt.Errorf("prefix %s is being renumbered, retire or cancel the renumbering first", p.Cidr)
+	}
+	return i.removePrefix(ctx, p)
+}
+
+// removePrefix deletes p, unlinks it from its parent and keeps it in the archive.
+func (i *ipamer) removePrefix(ctx context.Context, p *Prefix) (*Prefix, error) {
 	prefix, err := i.storage.DeletePrefix(ctx, *p)
 	if err != nil {
-		return nil, fmt.Errorf("delete prefix:%s %w", cidr, err)
+		return nil, fmt.Errorf("delete prefix:%s %w", p.Cidr, err)
+	}
+	err = i.unlinkPrefix(ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("delete prefix:%s %w", p.Cidr, err)
+	}
+	err = i.archivePrefix(ctx, *p)
+	if err != nil {
+		return nil, err
 	}
 
 	return &prefix, nil
 }
 
 func (i *ipamer) AcquireChildPrefix(ctx context.Context, parentCidr string, length uint8) (*Prefix, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	var prefix *Prefix
-	return prefix, retryOnOptimisticLock(func() error {
-		var err error
-		prefix, err = i.acquireChildPrefixInternal(ctx, parentCidr, "", int(length))
-		return err
+	err = retryOnOptimisticLock(func() error {
+		return i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+			var err error
+			prefix, err = tx.acquireChildPrefixInternal(ctx, parentCidr, "", int(length))
+			return err
+		})
 	})
+	return prefix, err
 }
 
 func (i *ipamer) AcquireSpecificChildPrefix(ctx context.Context, parentCidr, childCidr string) (*Prefix, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	var prefix *Prefix
-	return prefix, retryOnOptimisticLock(func() error {
-		var err error
-		prefix, err = i.acquireChildPrefixInternal(ctx, parentCidr, childCidr, 0)
-		return err
+	err = retryOnOptimisticLock(func() error {
+		return i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+			var err error
+			prefix, err = tx.acquireChildPrefixInternal(ctx, parentCidr, childCidr, 0)
+			return err
+		})
 	})
+	return prefix, err
 }
 
 // acquireChildPrefixInternal will return a Prefix with a smaller length from the given Prefix.
@@ -250,22 +469,9 @@ func (i *ipamer) acquireChildPrefixInternal(ctx context.Context, parentCidr, chi
 		return nil, fmt.Errorf("prefix %s has ips, acquire child prefix not possible", parent.Cidr)
 	}
 
-	var ipsetBuilder netipx.IPSetBuilder
-	ipsetBuilder.AddPrefix(ipprefix)
-	for cp, available := range parent.availableChildPrefixes {
-		if available {
-			continue
-		}
-		cpipprefix, err := netip.ParsePrefix(cp)
-		if err != nil {
-			return nil, err
-		}
-		ipsetBuilder.RemovePrefix(cpipprefix)
-	}
-
-	ipset, err := ipsetBuilder.IPSet()
+	ipset, err := parent.freeChildSet()
 	if err != nil {
-		return nil, fmt.Errorf("error constructing ipset:%w", err)
+		return nil, err
 	}
 
 	var cp netip.Prefix
@@ -297,11 +503,14 @@ func (i *ipamer) acquireChildPrefixInternal(ctx context.Context, parentCidr, chi
 		cp = childprefix
 	}
 
-	child := &Prefix{
-		Cidr:       cp.String(),
-		ParentCidr: parentCidr,
+	child, err := i.newPrefix(cp.String(), childGateway(parent, cp).String(), 0, parent.VRF, parent.IDC, parent.Cidr, false, KindSubnet)
+	if err != nil {
+		return nil, fmt.Errorf("unable to persist created child:%w", err)
 	}
 
+	if parent.availableChildPrefixes == nil {
+		parent.availableChildPrefixes = make(map[string]bool)
+	}
 	parent.availableChildPrefixes[child.Cidr] = false
 	parent.IsParent = true
 
@@ -310,11 +519,6 @@ func (i *ipamer) acquireChildPrefixInternal(ctx context.Context, parentCidr, chi
 		return nil, fmt.Errorf("unable to update parent prefix:%v error:%w", parent, err)
 	}
 
-	//待修改
-	//child, err = i.newPrefix(child.Cidr, parentCidr)
-	if err != nil {
-		return nil, fmt.Errorf("unable to persist created child:%w", err)
-	}
 	_, err = i.storage.CreatePrefix(ctx, *child)
 	if err != nil {
 		return nil, fmt.Errorf("unable to update parent prefix:%v error:%w", child, err)
@@ -324,28 +528,30 @@ func (i *ipamer) acquireChildPrefixInternal(ctx context.Context, parentCidr, chi
 }
 
 func (i *ipamer) ReleaseChildPrefix(ctx context.Context, child *Prefix) error {
+	unlock, err := i.lock(NewContextWithNamespace(ctx, child.Namespace()))
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	return retryOnOptimisticLock(func() error {
-		return i.releaseChildPrefixInternal(ctx, child)
+		return i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+			return tx.releaseChildPrefixInternal(ctx, child)
+		})
 	})
 }
 
 // releaseChildPrefixInternal will mark this child Prefix as available again.
 func (i *ipamer) releaseChildPrefixInternal(ctx context.Context, child *Prefix) error {
-	parent := i.PrefixFrom(ctx, child.ParentCidr)
-
-	if parent == nil {
+	ctx = NewContextWithNamespace(ctx, child.Namespace())
+	current := i.PrefixFrom(ctx, child.Cidr)
+	if current == nil || current.ParentCidr == "" {
 		return fmt.Errorf("prefix %s is no child prefix", child.Cidr)
 	}
-	if len(child.Ips) > 2 {
+	if current.hasIPs() {
 		return fmt.Errorf("prefix %s has ips, deletion not possible", child.Cidr)
 	}
-
-	parent.availableChildPrefixes[child.Cidr] = true
-	_, err := i.DeletePrefix(ctx, child.Cidr)
-	if err != nil {
-		return fmt.Errorf("unable to release prefix %v:%w", child, err)
-	}
-	_, err = i.storage.UpdatePrefix(ctx, *parent)
+	// deletePrefixInternal removes the child from its parent.
+	_, err := i.deletePrefixInternal(ctx, child.Cidr)
 	if err != nil {
 		return fmt.Errorf("unable to release prefix %v:%w", child, err)
 	}
@@ -357,7 +563,7 @@ func (i *ipamer) PrefixFrom(ctx context.Context, cidr string) *Prefix {
 	if err != nil {
 		return nil
 	}
-	prefix, err := i.storage.ReadPrefix(ctx, ipprefix.Masked().String())
+	prefix, err := i.storage.ReadPrefix(ctx, ipprefix.Masked().String(), NamespaceFromContext(ctx))
 	if err != nil {
 		return nil
 	}
@@ -384,11 +590,15 @@ func (i *ipamer) MarkIP(ctx context.Context, prefixCidr string, ipDetail IPDetai
 	if prefix == nil {
 		return nil, fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, prefixCidr)
 	}
-	for _, v := range IPS {
-		ipstring := v.String()
-		prefix.Ips[ipstring] = ipDetail
+	if err := checkNotRenumbered(prefix); err != nil {
+		return nil, err
+	}
+	prefix.acquire(ipDetail, IPS...)
+	marked := make([]string, 0, len(IPS))
+	for _, ip := range IPS {
+		marked = append(marked, ip.String())
 	}
-	_, err = i.storage.UpdatePrefix(ctx, *prefix)
+	err = i.persistIPs(ctx, prefix, marked, false)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to write to database")
 	}
@@ -401,6 +611,7 @@ func (i *ipamer) EditIPUserFromPrefix(ctx context.Context, prefixCidr, user stri
 	if prefix == nil {
 		return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, prefixCidr)
 	}
+	var edited []string
 	for _, v := range ips {
 		if prefix.IsParent {
 			return fmt.Errorf("prefix %s has childprefixes, acquire ip not possible", prefix.Cidr)
@@ -410,15 +621,35 @@ func (i *ipamer) EditIPUserFromPrefix(ctx context.Context, prefixCidr, user stri
 			IPDetail := prefix.Ips[v]
 			IPDetail.User = user
 			prefix.Ips[v] = IPDetail
+			edited = append(edited, v)
 		}
 	}
-	_, err := i.storage.UpdatePrefix(ctx, *prefix)
+	err := i.persistIPs(ctx, prefix, edited, false)
 	if err != nil {
 		return fmt.Errorf("unable to EditIPUserFromPrefix ip:%v error:%w", prefix, err)
 	}
 	return nil
 }
 
+// EditPrefixStrategy sets the allocation strategy AcquireIP uses for the prefix.
+func (i *ipamer) EditPrefixStrategy(ctx context.Context, prefixCidr, strategy string) error {
+	if _, err := ParseStrategy(strategy); err != nil {
+		return err
+	}
+	return retryOnOptimisticLock(func() error {
+		prefix := i.PrefixFrom(ctx, prefixCidr)
+		if prefix == nil {
+			return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, prefixCidr)
+		}
+		prefix.Strategy = strategy
+		_, err := i.storage.UpdatePrefix(ctx, *prefix)
+		if err != nil {
+			return fmt.Errorf("unable to EditPrefixStrategy prefix:%s error:%w", prefixCidr, err)
+		}
+		return nil
+	})
+}
+
 func (i *ipamer) EditIPDescriptionFromPrefix(ctx context.Context, prefixCidr, description string, ip string) error {
 	prefix := i.PrefixFrom(ctx, prefixCidr)
 	if prefix == nil {
@@ -432,7 +663,7 @@ func (i *ipamer) EditIPDescriptionFromPrefix(ctx context.Context, prefixCidr, de
 		IPDetail := prefix.Ips[ip]
 		IPDetail.Description = description
 		prefix.Ips[ip] = IPDetail
-		_, err := i.storage.UpdatePrefix(ctx, *prefix)
+		err := i.persistIPs(ctx, prefix, []string{ip}, false)
 		if err != nil {
 			return fmt.Errorf("1unable to EditIPDescriptionFromPrefix ip:%v error:%w", prefix, err)
 		}
@@ -446,16 +677,22 @@ func (i *ipamer) AcquireSpecificIP(ctx context.Context, prefixCidr string, ipDet
 	var ips []string
 	return ips, retryOnOptimisticLock(func() error {
 		var err error
-		ips, err = i.acquireSpecificIPInternal(ctx, prefixCidr, ipDetail, specificIP, num)
+		ips, err = i.acquireSpecificIPInternal(ctx, prefixCidr, ipDetail, specificIP, num, AcquireIPOptions{})
 		return err
 	})
 }
 
 // acquireSpecificIPInternal will acquire given IP and mark this IP as used, if already in use, return nil.
-// If specificIP is empty, the next free IP is returned.
+// If specificIP is empty, the next free IPs picked by the allocation strategy are returned.
 // If there is no free IP an NoIPAvailableError is returned.
 // If the Prefix is not found an NotFoundError is returned.
-func (i *ipamer) acquireSpecificIPInternal(ctx context.Context, prefixCidr string, ipDetail IPDetail, specificIP string, num int) ([]string, error) {
+// MaxAcquireIPs is the most ips acquired by a single call of AcquireIP or AcquireSpecificIP.
+const MaxAcquireIPs = 4096
+
+func (i *ipamer) acquireSpecificIPInternal(ctx context.Context, prefixCidr string, ipDetail IPDetail, specificIP string, num int, opts AcquireIPOptions) ([]string, error) {
+	if num <= 0 || num > MaxAcquireIPs {
+		return nil, fmt.Errorf("unable to acquire %d ips, between 1 and %d ips can be acquired at once", num, MaxAcquireIPs)
+	}
 	prefix := i.PrefixFrom(ctx, prefixCidr)
 	if prefix == nil {
 		return nil, fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, prefixCidr)
@@ -463,11 +700,28 @@ func (i *ipamer) acquireSpecificIPInternal(ctx context.Context, prefixCidr strin
 	if prefix.IsParent {
 		return nil, fmt.Errorf("prefix %s has childprefixes, acquire ip not possible", prefix.Cidr)
 	}
+	if prefix.Kind == KindLoopbackPool {
+		return nil, fmt.Errorf("prefix %s is a loopback pool, use AcquireLoopback", prefix.Cidr)
+	}
+	if err := checkNotRenumbered(prefix); err != nil {
+		return nil, err
+	}
+	if ips, ok := prefix.requestIPs(opts.RequestID); ok {
+		return ips, nil
+	}
 	ipnet, err := netip.ParsePrefix(prefix.Cidr)
 	if err != nil {
 		return nil, err
 	}
 
+	if opts.MAC != "" && specificIP == "" {
+		eui, err := eui64(ipnet, opts.MAC)
+		if err != nil {
+			return nil, err
+		}
+		specificIP = eui.String()
+	}
+
 	var specificIPnet netip.Addr
 	if specificIP != "" {
 		specificIPnet, err = netip.ParseAddr(specificIP)
@@ -477,42 +731,98 @@ func (i *ipamer) acquireSpecificIPInternal(ctx context.Context, prefixCidr strin
 		if !ipnet.Contains(specificIPnet) {
 			return nil, fmt.Errorf("given ip:%s is not in %s", specificIP, prefixCidr)
 		}
-		_, ok := prefix.Ips[specificIPnet.String()]
-		if ok {
+		if prefix.allocatedSet().Contains(specificIPnet) {
 			return nil, fmt.Errorf("%w: given ip:%s is already allocated", ErrAlreadyAllocated, specificIPnet)
 		}
+		if prefix.quarantinedSet(time.Now()).Contains(specificIPnet) {
+			return nil, fmt.Errorf("%w: given ip:%s is in quarantine until %s", ErrAlreadyAllocated, specificIPnet, prefix.Quarantined[specificIPnet.String()])
+		}
 	}
 
-	iprange := netipx.RangeOfPrefix(ipnet)
-	anum := 0
 	ips := []string{}
-	for ip := iprange.From(); ipnet.Contains(ip); ip = ip.Next() {
-		ipstring := ip.String()
-		_, ok := prefix.Ips[ipstring]
-		if ok {
-			continue
+	if specificIP != "" {
+		prefix.acquire(ipDetail, specificIPnet)
+		ips = append(ips, specificIPnet.String())
+		num = 1
+	} else {
+		spec := prefix.Strategy
+		if opts.Strategy != "" {
+			spec = opts.Strategy
 		}
-		if specificIP == "" || specificIPnet.Compare(ip) == 0 {
-			prefix.Ips[ipstring] = ipDetail
-			ips = append(ips, ipstring)
-			anum += 1
-			if anum == num {
-				break
+		strategy, err := ParseStrategy(spec)
+		if err != nil {
+			return nil, err
+		}
+		free, err := prefix.freeIPs(ipnet)
+		if err != nil {
+			return nil, err
+		}
+		free, err = prefix.restrictToRanges(free, opts.Range)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Contiguous && num > 0 {
+			alignment := uint64(1)
+			if opts.Align {
+				alignment = blockAlignment(uint64(num))
+			}
+			block, ok := strategy.PickBlock(ipnet, free, uint64(num), alignment)
+			if !ok {
+				run, size := largestRun(free)
+				if size.Sign() == 0 {
+					return nil, fmt.Errorf("%w: no free address left in %s", ErrNoIPAvailable, prefixCidr)
+				}
+				return nil, fmt.Errorf("%w: no block of %d contiguous addresses (alignment %d) free in %s, largest free run is %s (%d addresses)",
+					ErrNoIPAvailable, num, alignment, prefixCidr, run, size)
+			}
+			var picked []netip.Addr
+			for ip := block.From(); block.Contains(ip); ip = ip.Next() {
+				picked = append(picked, ip)
+				ips = append(ips, ip.String())
 			}
+			prefix.acquire(ipDetail, picked...)
+		} else {
+			picked := strategy.Pick(ipnet, free, num)
+			for _, ip := range picked {
+				ips = append(ips, ip.String())
+			}
+			prefix.acquire(ipDetail, picked...)
 		}
 	}
+	anum := len(ips)
 	if anum < num {
 		return nil, fmt.Errorf("%s 当前只能分配出%d", prefixCidr, anum)
 	}
-	_, err = i.storage.UpdatePrefix(ctx, *prefix)
+	prefix.rememberRequest(opts.RequestID, ips)
+	if _, ok := i.storage.(IPStorage); ok && opts.RequestID != "" {
+		// the request id is written with the version check of the prefix, a concurrent repetition
+		// of the request gives up its ips and retries to find the ones of the first.
+		err = i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+			err := tx.persistIPs(ctx, prefix, ips, true)
+			if err != nil {
+				return err
+			}
+			_, err = tx.storage.UpdatePrefix(ctx, *prefix)
+			return err
+		})
+	} else {
+		err = i.persistIPs(ctx, prefix, ips, true)
+	}
+	if errors.Is(err, ErrOptimisticLockError) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to persist acquired")
 	}
 	return ips, nil
 }
 
-func (i *ipamer) AcquireIP(ctx context.Context, prefixCidr string, ipDetail IPDetail, num int) (ips []string, err error) {
-	ips, err = i.AcquireSpecificIP(ctx, prefixCidr, ipDetail, "", num)
+func (i *ipamer) AcquireIP(ctx context.Context, prefixCidr string, ipDetail IPDetail, num int, opts AcquireIPOptions) (ips []string, err error) {
+	err = retryOnOptimisticLock(func() error {
+		var innerErr error
+		ips, innerErr = i.acquireSpecificIPInternal(ctx, prefixCidr, ipDetail, "", num, opts)
+		return innerErr
+	})
 	return
 }
 
@@ -550,78 +860,25 @@ func (i *ipamer) releaseIPFromPrefixInternal(ctx context.Context, prefixCidr str
 		return nil, fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, prefixCidr)
 	}
 	res = &ReleaseIPRes{}
+	var released []netip.Addr
 	for _, v := range ips {
-		_, ok := prefix.Ips[v]
-		if !ok {
+		ip, err := netip.ParseAddr(v)
+		if err != nil || !prefix.allocatedSet().Contains(ip) {
 			res.Result = append(res.Result, ReleaseResult{v, "释放失败,ErrNotFound IP"})
 		} else {
-			delete(prefix.Ips, v)
+			released = append(released, ip)
 			res.Result = append(res.Result, ReleaseResult{v, "释放成功"})
 		}
 	}
-	_, err := i.storage.UpdatePrefix(ctx, *prefix)
+	err := i.persistRelease(ctx, prefix, released)
 	if err != nil {
 		return nil, fmt.Errorf("unable to release ip %w", err)
 	}
 	return res, nil
 }
 
-// PrefixesOverlapping will check if one ore more prefix of newPrefixes is overlapping
-// with one of existingPrefixes
-func PrefixesOverlapping(i *ipamer, existingPrefixes []string, newPrefixes []string) error {
-	for _, np := range newPrefixes {
-		nip, err := netip.ParsePrefix(np)
-		if err != nil {
-			return fmt.Errorf("parsing prefix %s failed:%w", np, err)
-		}
-		for _, ep := range existingPrefixes {
-			eip, err := netip.ParsePrefix(ep)
-			if err != nil {
-				return fmt.Errorf("parsing prefix %s failed:%w", np, err)
-			}
-			iprange := netipx.RangeOfPrefix(eip)
-			if nip.Contains(iprange.From()) {
-				return fmt.Errorf("parsing prefix %s failed,Error already has subnet", np)
-			}
-		}
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	for _, ep := range existingPrefixes {
-		eip, err := netip.ParsePrefix(ep)
-		if err != nil {
-			return fmt.Errorf("parsing prefix %s failed:%w", ep, err)
-		}
-		for _, np := range newPrefixes {
-			nip, err := netip.ParsePrefix(np)
-			if err != nil {
-				return fmt.Errorf("parsing prefix %s failed:%w", np, err)
-			}
-			iprange := netipx.RangeOfPrefix(nip)
-			if eip.Contains(iprange.From()) {
-				prefix := i.PrefixFrom(ctx, ep)
-				if prefix == nil {
-					continue
-				}
-				for ip := iprange.From(); eip.Contains(ip); ip = ip.Next() {
-					ipstring := ip.String()
-					prefix.Ips[ipstring] = IPDetail{"networkman", np, "子网段使用", tools.DateToString()}
-				}
-				_, err := i.storage.UpdatePrefix(ctx, *prefix)
-				if err != nil {
-					return err
-				}
-			}
-			// if eip.Overlaps(nip) || nip.Overlaps(eip) {
-			// 	return fmt.Errorf("%s overlaps %s", nip, eip)
-			// }
-		}
-	}
-	return nil
-}
-
 // newPrefix create a new Prefix from a string notation.
-func (i *ipamer) newPrefix(cidr, gateway string, vlanId int, vrf, idc, parentCidr string, isParent bool) (*Prefix, error) {
+func (i *ipamer) newPrefix(cidr, gateway string, vlanId int, vrf, idc, parentCidr string, isParent bool, kind string) (*Prefix, error) {
 	ipnet, err := netip.ParsePrefix(cidr)
 	if err != nil {
 		return nil, fmt.Errorf("cidr errors")
@@ -630,9 +887,20 @@ func (i *ipamer) newPrefix(cidr, gateway string, vlanId int, vrf, idc, parentCid
 	// if err != nil {
 	// 	return nil, fmt.Errorf("parentCidr errors")
 	// }
-	GW, err := netip.ParseAddr(gateway)
-	if err != nil || !ipnet.Contains(GW) {
-		return nil, fmt.Errorf("gateway errors")
+	if kind == "" {
+		kind = KindSubnet
+	}
+	err = checkKind(ipnet, kind)
+	if err != nil {
+		return nil, err
+	}
+	var GW netip.Addr
+	// parent pools only hand out child prefixes and need no gateway.
+	if gateway != "" || !gatewayOptional(kind) && !isParent {
+		GW, err = netip.ParseAddr(gateway)
+		if err != nil || !ipnet.Contains(GW) {
+			return nil, fmt.Errorf("gateway errors")
+		}
 	}
 	if parentCidr != "" {
 		ipnetParent, err := netip.ParsePrefix(parentCidr)
@@ -651,17 +919,32 @@ func (i *ipamer) newPrefix(cidr, gateway string, vlanId int, vrf, idc, parentCid
 		Ips:                    make(map[string]IPDetail),
 		availableChildPrefixes: make(map[string]bool),
 		IsParent:               isParent,
+		Kind:                   kind,
 	}
 
-	// FIXME: should this be done by the user ?
-	// First ip in the prefix and broadcast is blocked.
-	iprange := netipx.RangeOfPrefix(ipnet)
-	p.Ips[gateway] = IPDetail{"networkman", "networkman", "网关地址", tools.DateToString()}
-	p.Ips[iprange.From().String()] = IPDetail{"networkman", "networkman", "网络地址", tools.DateToString()}
-	if ipnet.Addr().Is4() {
-		// broadcast is ipv4 only
-		p.Ips[iprange.To().String()] = IPDetail{"networkman", "networkman", "广播地址", tools.DateToString()}
+	switch kind {
+	case KindSubnet:
+		// FIXME: should this be done by the user ?
+		// First ip in the prefix and broadcast is blocked.
+		// Point-to-point links (/31, /127) use both addresses, see RFC 3021 and RFC 6164.
+		iprange := netipx.RangeOfPrefix(ipnet)
+		if GW.IsValid() {
+			p.acquire(reservation(gatewayDescription), GW)
+		}
+		if !isPointToPoint(ipnet) {
+			p.acquire(reservation(networkDescription), iprange.From())
+			if ipnet.Addr().Is4() {
+				// broadcast is ipv4 only
+				p.acquire(reservation(broadcastDescription), iprange.To())
+			}
+		}
+	case KindPointToPoint:
+		// the gateway is the local end of the link if given, the peer end stays free.
+		if GW.IsValid() {
+			p.acquire(reservation(gatewayDescription), GW)
+		}
 	}
+	// hosts and loopback pools have no reserved addresses.
 
 	return p, nil
 }
@@ -703,9 +986,14 @@ func (i *ipamer) Load(ctx context.Context, dump string) error {
 	return nil
 }
 
-// ReadAllPrefixCidrs retrieves all existing Prefix CIDRs from the underlying storage
+// ReadAllPrefixCidrs retrieves all existing Prefix CIDRs of the namespace from the underlying storage
 func (i *ipamer) ReadAllPrefixCidrs(ctx context.Context) ([]string, error) {
-	return i.storage.ReadAllPrefixCidrs(ctx)
+	return i.storage.ReadAllPrefixCidrs(ctx, NamespaceFromContext(ctx))
+}
+
+// ReadAllPrefixes retrieves the prefixes of all namespaces from the underlying storage
+func (i *ipamer) ReadAllPrefixes(ctx context.Context) (Prefixes, error) {
+	return i.storage.ReadAllPrefixes(ctx)
 }
 
 func (p *Prefix) String() string {
@@ -719,6 +1007,11 @@ func (u *Usage) String() string {
 	return fmt.Sprintf("ip:%d/%d prefixes alloc:%d avail:%d", u.AcquiredIPs, u.AvailableIPs, u.AcquiredPrefixes, u.AvailableSmallestPrefixes)
 }
 
+// Namespace returns the namespace made up of IDC and VRF this Prefix lives in
+func (p *Prefix) Namespace() Namespace {
+	return Namespace{IDC: p.IDC, VRF: p.VRF}
+}
+
 // Network return the net.IP part of the Prefix
 func (p *Prefix) Network() (netip.Addr, error) {
 	ipprefix, err := netip.ParsePrefix(p.Cidr)
@@ -728,44 +1021,49 @@ func (p *Prefix) Network() (netip.Addr, error) {
 	return ipprefix.Addr(), nil
 }
 
-// hasIPs will return true if there are allocated IPs
+// hasIPs will return true if there are acquired IPs besides the reserved ones.
+// Addresses acquired without IPDetail by earlier versions for subnets inside the prefix are not counted.
 func (p *Prefix) hasIPs() bool {
-	ipprefix, err := netip.ParsePrefix(p.Cidr)
-	if err != nil {
-		return false
-	}
-	if ipprefix.Addr().Is4() && len(p.Ips) > 2 {
-		return true
-	}
-	if ipprefix.Addr().Is6() && len(p.Ips) > 1 {
-		return true
+	for _, detail := range p.Ips {
+		if !isReservation(detail) {
+			return true
+		}
 	}
 	return false
 }
 
+// freeIPs returns the addresses of ipnet which are neither acquired nor in quarantine
+func (p *Prefix) freeIPs(ipnet netip.Prefix) (*netipx.IPSet, error) {
+	var b netipx.IPSetBuilder
+	b.AddPrefix(ipnet)
+	b.RemoveSet(p.allocatedSet())
+	b.RemoveSet(p.quarantinedSet(time.Now()))
+	free, err := b.IPSet()
+	if err != nil {
+		return nil, fmt.Errorf("error constructing ipset:%w", err)
+	}
+	return free, nil
+}
+
 // availableips return the number of ips available in this Prefix
-func (p *Prefix) availableips() uint64 {
+func (p *Prefix) availableips() *big.Int {
 	ipprefix, err := netip.ParsePrefix(p.Cidr)
 	if err != nil {
-		return 0
-	}
-	// We don't report more than 2^31 available IPs by design
-	if (ipprefix.Addr().BitLen() - ipprefix.Bits()) > 31 {
-		return math.MaxInt32
+		return new(big.Int)
 	}
-	return 1 << (ipprefix.Addr().BitLen() - ipprefix.Bits())
+	return prefixSize(ipprefix)
 }
 
 // acquiredips return the number of ips acquired in this Prefix
-func (p *Prefix) acquiredips() uint64 {
-	return uint64(len(p.Ips))
+func (p *Prefix) acquiredips() *big.Int {
+	return setSize(p.allocatedSet())
 }
 
 // availablePrefixes will return the amount of prefixes allocatable and the amount of smallest 2 bit prefixes
-func (p *Prefix) availablePrefixes() (uint64, []string) {
+func (p *Prefix) availablePrefixes() (*big.Int, []string) {
 	prefix, err := netip.ParsePrefix(p.Cidr)
 	if err != nil {
-		return 0, nil
+		return new(big.Int), nil
 	}
 	var ipsetBuilder netipx.IPSetBuilder
 	ipsetBuilder.AddPrefix(prefix)
@@ -782,23 +1080,20 @@ func (p *Prefix) availablePrefixes() (uint64, []string) {
 
 	ipset, err := ipsetBuilder.IPSet()
 	if err != nil {
-		return 0, []string{}
+		return new(big.Int), []string{}
 	}
 
 	// Only 2 Bit Prefixes are usable, set max bits available 2 less than max in family
 	maxBits := prefix.Addr().BitLen() - 2
 	pfxs := ipset.Prefixes()
-	totalAvailable := uint64(0)
+	totalAvailable := new(big.Int)
 	availablePrefixes := []string{}
 	for _, pfx := range pfxs {
-		// same as: totalAvailable += uint64(math.Pow(float64(2), float64(maxBits-pfx.Bits)))
-		totalAvailable += 1 << (maxBits - pfx.Bits())
+		if pfx.Bits() <= maxBits {
+			totalAvailable.Add(totalAvailable, new(big.Int).Lsh(big.NewInt(1), uint(maxBits-pfx.Bits())))
+		}
 		availablePrefixes = append(availablePrefixes, pfx.String())
 	}
-	// we are not reporting more that 2^31 available prefixes
-	if totalAvailable > math.MaxInt32 {
-		totalAvailable = math.MaxInt32
-	}
 	return totalAvailable, availablePrefixes
 }
 
@@ -816,12 +1111,15 @@ func (p *Prefix) acquiredPrefixes() uint64 {
 // Usage report Prefix usage.
 func (p *Prefix) Usage() Usage {
 	sp, ap := p.availablePrefixes()
+	reserved, ranges := p.rangesUsage()
 	return Usage{
 		AvailableIPs:              p.availableips(),
 		AcquiredIPs:               p.acquiredips(),
 		AcquiredPrefixes:          p.acquiredPrefixes(),
 		AvailableSmallestPrefixes: sp,
 		AvailablePrefixes:         ap,
+		ReservedIPs:               reserved,
+		Ranges:                    ranges,
 	}
 }
 
@@ -873,4 +1171,3 @@ func retryOnOptimisticLock(retryableFunc retry.RetryableFunc) error {
 		retry.DelayType(retry.CombineDelay(retry.BackOffDelay, retry.RandomDelay)),
 		retry.LastErrorOnly(true))
 }
-
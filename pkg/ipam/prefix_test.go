@@ -0,0 +1,75 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAcquireIPContiguous(t *testing.T) {
+	tests := []struct {
+		name string
+		opts AcquireIPOptions
+		want string
+	}{
+		{name: "lowest block", opts: AcquireIPOptions{Contiguous: true}, want: "10.0.0.2-10.0.0.5"},
+		{name: "aligned block", opts: AcquireIPOptions{Contiguous: true, Align: true}, want: "10.0.0.4-10.0.0.7"},
+		{name: "highest aligned block", opts: AcquireIPOptions{Contiguous: true, Align: true, Strategy: StrategyLastFree}, want: "10.0.0.24-10.0.0.27"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := New()
+			newTestPrefix(t, i, prod, "10.0.0.0/27", "10.0.0.1", false, "")
+			ctx := NewContextWithNamespace(context.Background(), prod)
+			// splits the free addresses into 10.0.0.2-10.0.0.8 and 10.0.0.10-10.0.0.30
+			if _, err := i.AcquireSpecificIP(ctx, "10.0.0.0/27", IPDetail{}, "10.0.0.9", 1); err != nil {
+				t.Fatal(err)
+			}
+			got, err := i.AcquireIP(ctx, "10.0.0.0/27", IPDetail{User: "a"}, 4, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 4 || got[0]+"-"+got[3] != tt.want {
+				t.Fatalf("got %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcquireIPContiguousExhausted(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/28", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	if _, err := i.AcquireSpecificIP(ctx, "10.0.0.0/28", IPDetail{}, "10.0.0.8", 1); err != nil {
+		t.Fatal(err)
+	}
+	// 10.0.0.2-10.0.0.7 and 10.0.0.9-10.0.0.14 are free, 12 addresses but no 8 in a row
+	_, err := i.AcquireIP(ctx, "10.0.0.0/28", IPDetail{User: "a"}, 8, AcquireIPOptions{Contiguous: true})
+	if !errors.Is(err, ErrNoIPAvailable) {
+		t.Fatalf("got %v, want %v", err, ErrNoIPAvailable)
+	}
+	if got := i.PrefixFrom(ctx, "10.0.0.0/28").Usage().AcquiredIPs; got.Int64() != 4 {
+		t.Errorf("got %d acquired ips after the failed request, want 4", got)
+	}
+	if _, err := i.AcquireIP(ctx, "10.0.0.0/28", IPDetail{User: "a"}, 6, AcquireIPOptions{Contiguous: true}); err != nil {
+		t.Errorf("expected the 6 addresses of a free run to be acquired: %v", err)
+	}
+}
+
+func TestAcquireIPNum(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/16", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	for _, num := range []int{-1, 0, MaxAcquireIPs + 1} {
+		if _, err := i.AcquireIP(ctx, "10.0.0.0/16", IPDetail{User: "a"}, num, AcquireIPOptions{}); err == nil {
+			t.Errorf("expected acquiring %d ips to be refused", num)
+		}
+	}
+	ips, err := i.AcquireIP(ctx, "10.0.0.0/16", IPDetail{User: "a"}, MaxAcquireIPs, AcquireIPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != MaxAcquireIPs {
+		t.Errorf("got %d ips, want %d", len(ips), MaxAcquireIPs)
+	}
+}
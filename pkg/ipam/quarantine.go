@@ -0,0 +1,118 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	"go4.org/netipx"
+)
+
+// QuarantinedIP is a released ip which is not acquired again before Until.
+type QuarantinedIP struct {
+	IP    string `json:"ip"`    //地址
+	Until string `json:"until"` //隔离结束时间
+}
+
+// ParseQuarantine parses the quarantine cooldown of a prefix, e.g. 30m or 24h, an empty string disables it.
+func ParseQuarantine(cooldown string) (time.Duration, error) {
+	if cooldown == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(cooldown)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid quarantine cooldown %q, expected a duration like 30m or 24h", cooldown)
+	}
+	return d, nil
+}
+
+// quarantinedSet returns the ips whose quarantine has not ended at now.
+func (p *Prefix) quarantinedSet(now time.Time) *netipx.IPSet {
+	var b netipx.IPSetBuilder
+	for ip, until := range p.Quarantined {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		t, err := ParseExpires(until)
+		if err != nil || !t.After(now) {
+			continue
+		}
+		b.Add(addr)
+	}
+	set, _ := b.IPSet()
+	return set
+}
+
+// quarantine puts the released ips into quarantine for the cooldown of the prefix and
+// drops the entries whose quarantine has ended.
+func (p *Prefix) quarantine(ips []netip.Addr, now time.Time) {
+	for ip, until := range p.Quarantined {
+		t, err := ParseExpires(until)
+		if err != nil || !t.After(now) {
+			delete(p.Quarantined, ip)
+		}
+	}
+	cooldown, err := ParseQuarantine(p.Quarantine)
+	if err != nil || cooldown == 0 || len(ips) == 0 {
+		return
+	}
+	if p.Quarantined == nil {
+		p.Quarantined = make(map[string]string)
+	}
+	until := now.Add(cooldown).In(time.Local).Format(ExpiresLayout)
+	for _, ip := range ips {
+		p.Quarantined[ip.String()] = until
+	}
+}
+
+// EditPrefixQuarantine sets the cooldown released ips of the prefix stay in quarantine, an empty cooldown disables it.
+func (i *ipamer) EditPrefixQuarantine(ctx context.Context, prefixCidr, cooldown string) error {
+	if _, err := ParseQuarantine(cooldown); err != nil {
+		return err
+	}
+	return i.modifyPrefix(ctx, prefixCidr, func(p *Prefix) error {
+		p.Quarantine = cooldown
+		return nil
+	})
+}
+
+// QuarantinedIPs returns the ips of the prefix which are in quarantine.
+func (i *ipamer) QuarantinedIPs(ctx context.Context, prefixCidr string) ([]QuarantinedIP, error) {
+	prefix := i.PrefixFrom(ctx, prefixCidr)
+	if prefix == nil {
+		return nil, fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, prefixCidr)
+	}
+	active := prefix.quarantinedSet(time.Now())
+	qs := []QuarantinedIP{}
+	for ip, until := range prefix.Quarantined {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !active.Contains(addr) {
+			continue
+		}
+		qs = append(qs, QuarantinedIP{IP: ip, Until: until})
+	}
+	sort.Slice(qs, func(a, b int) bool {
+		return netip.MustParseAddr(qs[a].IP).Less(netip.MustParseAddr(qs[b].IP))
+	})
+	return qs, nil
+}
+
+// ReleaseQuarantined ends the quarantine of the given ips at once, all ips of the prefix if none are given.
+func (i *ipamer) ReleaseQuarantined(ctx context.Context, prefixCidr string, ips []string) error {
+	return i.modifyPrefix(ctx, prefixCidr, func(p *Prefix) error {
+		if len(ips) == 0 {
+			p.Quarantined = nil
+			return nil
+		}
+		for _, ip := range ips {
+			if _, ok := p.Quarantined[ip]; !ok {
+				return fmt.Errorf("%w: ip %s is not in quarantine in %s", ErrNotFound, ip, p.Cidr)
+			}
+			delete(p.Quarantined, ip)
+		}
+		return nil
+	})
+}
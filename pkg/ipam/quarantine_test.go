@@ -0,0 +1,113 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newQuarantine creates 10.0.0.0/28 with a cooldown of an hour and acquires and releases ips in it.
+func newQuarantine(t *testing.T, released ...string) (*ipamer, context.Context) {
+	t.Helper()
+	i := New().(*ipamer)
+	newTestPrefix(t, i, prod, "10.0.0.0/28", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	if err := i.EditPrefixQuarantine(ctx, "10.0.0.0/28", "1h"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range released {
+		if _, err := i.AcquireSpecificIP(ctx, "10.0.0.0/28", IPDetail{User: "a"}, ip, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := i.ReleaseIPFromPrefix(ctx, "10.0.0.0/28", released); err != nil {
+		t.Fatal(err)
+	}
+	return i, ctx
+}
+
+// quarantined returns the ips of 10.0.0.0/28 in quarantine.
+func quarantined(t *testing.T, i Ipamer, ctx context.Context) []string {
+	t.Helper()
+	qs, err := i.QuarantinedIPs(ctx, "10.0.0.0/28")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips := []string{}
+	for _, q := range qs {
+		ips = append(ips, q.IP)
+	}
+	return ips
+}
+
+func TestQuarantineSkipsReleasedIPs(t *testing.T) {
+	i, ctx := newQuarantine(t, "10.0.0.2")
+	if got := quarantined(t, i, ctx); len(got) != 1 || got[0] != "10.0.0.2" {
+		t.Fatalf("got quarantined %v, want [10.0.0.2]", got)
+	}
+
+	ips, err := i.AcquireIP(ctx, "10.0.0.0/28", IPDetail{User: "b"}, 1, AcquireIPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ips[0] != "10.0.0.3" {
+		t.Errorf("got %s, want the quarantined 10.0.0.2 to be skipped", ips[0])
+	}
+	if _, err := i.AcquireSpecificIP(ctx, "10.0.0.0/28", IPDetail{User: "b"}, "10.0.0.2", 1); err == nil {
+		t.Error("expected the quarantined 10.0.0.2 not to be acquired")
+	}
+
+	// the cooldown of 10.0.0.2 has ended
+	err = i.modifyPrefix(ctx, "10.0.0.0/28", func(p *Prefix) error {
+		p.Quarantined["10.0.0.2"] = time.Now().Add(-time.Minute).Format(ExpiresLayout)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := quarantined(t, i, ctx); len(got) != 0 {
+		t.Errorf("got quarantined %v after the cooldown, want none", got)
+	}
+	ips, err = i.AcquireIP(ctx, "10.0.0.0/28", IPDetail{User: "b"}, 1, AcquireIPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ips[0] != "10.0.0.2" {
+		t.Errorf("got %s, want 10.0.0.2 after the cooldown", ips[0])
+	}
+}
+
+func TestReleaseQuarantined(t *testing.T) {
+	i, ctx := newQuarantine(t, "10.0.0.4", "10.0.0.5", "10.0.0.6")
+
+	if err := i.ReleaseQuarantined(ctx, "10.0.0.0/28", []string{"10.0.0.4"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := quarantined(t, i, ctx); len(got) != 2 || got[0] != "10.0.0.5" || got[1] != "10.0.0.6" {
+		t.Fatalf("got quarantined %v, want [10.0.0.5 10.0.0.6]", got)
+	}
+	if _, err := i.AcquireSpecificIP(ctx, "10.0.0.0/28", IPDetail{User: "b"}, "10.0.0.4", 1); err != nil {
+		t.Errorf("expected 10.0.0.4 to be acquired after its quarantine was ended: %v", err)
+	}
+
+	err := i.ReleaseQuarantined(ctx, "10.0.0.0/28", []string{"10.0.0.5", "10.0.0.9"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want %v for 10.0.0.9 which is not in quarantine", err, ErrNotFound)
+	}
+	if got := quarantined(t, i, ctx); len(got) != 2 {
+		t.Errorf("got quarantined %v after a refused release, want 10.0.0.5 and 10.0.0.6", got)
+	}
+
+	if err := i.ReleaseQuarantined(ctx, "10.0.0.0/28", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := quarantined(t, i, ctx); len(got) != 0 {
+		t.Fatalf("got quarantined %v, want none", got)
+	}
+	for _, ip := range []string{"10.0.0.5", "10.0.0.6"} {
+		if _, err := i.AcquireSpecificIP(ctx, "10.0.0.0/28", IPDetail{User: "b"}, ip, 1); err != nil {
+			t.Errorf("expected %s to be acquired after the quarantine was ended: %v", ip, err)
+		}
+	}
+}
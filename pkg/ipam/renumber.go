@@ -0,0 +1,352 @@
+package ipam
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RenumberMapping moves one acquired address of the old prefix to an address of the new prefix.
+type RenumberMapping struct {
+	Old    string   `json:"old"`    //旧地址
+	New    string   `json:"new"`    //新地址
+	Detail IPDetail `json:"detail"` //旧地址的分配信息,迁移到新地址
+}
+
+// RenumberPlan is the proposed mapping of all acquired addresses of OldCidr into NewCidr.
+type RenumberPlan struct {
+	OldCidr  string            `json:"oldcidr"`
+	NewCidr  string            `json:"newcidr"`
+	Mappings []RenumberMapping `json:"mappings"`
+}
+
+// renumberPrefixes reads the old and new prefix of a renumbering.
+func (i *ipamer) renumberPrefixes(ctx context.Context, oldCidr, newCidr string) (*Prefix, *Prefix, error) {
+	old := i.PrefixFrom(ctx, oldCidr)
+	if old == nil {
+		return nil, nil, fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, oldCidr)
+	}
+	new := i.PrefixFrom(ctx, newCidr)
+	if new == nil {
+		return nil, nil, fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, newCidr)
+	}
+	if old.Cidr == new.Cidr {
+		return nil, nil, fmt.Errorf("prefix %s can not be renumbered into itself", old.Cidr)
+	}
+	if old.RenumberTo != "" {
+		return nil, nil, fmt.Errorf("prefix %s is renumbered to %s already", old.Cidr, old.RenumberTo)
+	}
+	if new.RenumberFrom != "" {
+		return nil, nil, fmt.Errorf("prefix %s is renumbered from %s already", new.Cidr, new.RenumberFrom)
+	}
+	if old.hasChildPrefixes() || new.hasChildPrefixes() {
+		return nil, nil, fmt.Errorf("only prefixes without child prefixes can be renumbered")
+	}
+	return old, new, nil
+}
+
+// unmappedIPs returns the acquired addresses of old which are missing from mapped, sorted.
+func unmappedIPs(old *Prefix, mapped map[string]string) []string {
+	var addrs []netip.Addr
+	for ip, detail := range old.Ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || isReservation(detail) {
+			continue
+		}
+		if _, ok := mapped[addr.String()]; !ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(a, b int) bool { return addrs[a].Less(addrs[b]) })
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.String())
+	}
+	return ips
+}
+
+// checkNotRenumbered refuses to acquire addresses in a renumbered prefix,
+// they would not be part of the applied mapping and get lost by RetireRenumber.
+func checkNotRenumbered(p *Prefix) error {
+	if p.RenumberTo != "" {
+		return fmt.Errorf("prefix %s is renumbered to %s, acquire ip not possible", p.Cidr, p.RenumberTo)
+	}
+	return nil
+}
+
+// PlanRenumber proposes a mapping of the acquired addresses of oldCidr into newCidr, keeping the host
+// offset of an address where the new prefix has it free and filling up with the lowest free addresses otherwise.
+func (i *ipamer) PlanRenumber(ctx context.Context, oldCidr, newCidr string) (*RenumberPlan, error) {
+	old, new, err := i.renumberPrefixes(ctx, oldCidr, newCidr)
+	if err != nil {
+		return nil, err
+	}
+	oldnet, err := netip.ParsePrefix(old.Cidr)
+	if err != nil {
+		return nil, err
+	}
+	newnet, err := netip.ParsePrefix(new.Cidr)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []netip.Addr
+	for ip, detail := range old.Ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || isReservation(detail) {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(a, b int) bool { return addrs[a].Less(addrs[b]) })
+
+	free, err := new.freeIPs(newnet)
+	if err != nil {
+		return nil, err
+	}
+	free, err = new.restrictToRanges(free, "")
+	if err != nil {
+		return nil, err
+	}
+	plan := &RenumberPlan{OldCidr: old.Cidr, NewCidr: new.Cidr, Mappings: []RenumberMapping{}}
+	var unmapped []netip.Addr
+	for _, addr := range addrs {
+		offset := addrOffset(oldnet, addr)
+		if offset.Cmp(prefixSize(newnet)) >= 0 {
+			unmapped = append(unmapped, addr)
+			continue
+		}
+		target := addrAt(newnet, offset)
+		if !free.Contains(target) {
+			unmapped = append(unmapped, addr)
+			continue
+		}
+		free = without(free, target)
+		plan.Mappings = append(plan.Mappings, RenumberMapping{Old: addr.String(), New: target.String(), Detail: old.Ips[addr.String()]})
+	}
+	for _, addr := range unmapped {
+		targets := firstFree{}.Pick(newnet, free, 1)
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("%w: prefix %s has no room for ip %s of %s", ErrNoIPAvailable, new.Cidr, addr, old.Cidr)
+		}
+		free = without(free, targets[0])
+		plan.Mappings = append(plan.Mappings, RenumberMapping{Old: addr.String(), New: targets[0].String(), Detail: old.Ips[addr.String()]})
+	}
+	sort.Slice(plan.Mappings, func(a, b int) bool {
+		return netip.MustParseAddr(plan.Mappings[a].Old).Less(netip.MustParseAddr(plan.Mappings[b].Old))
+	})
+	return plan, nil
+}
+
+// ApplyRenumber acquires all new addresses of plan at once with the IPDetail of their old address and links
+// both prefixes until RetireRenumber. The plan must map every acquired address of the old prefix.
+// The old addresses stay acquired during the transition, no further addresses can be acquired in the old prefix.
+func (i *ipamer) ApplyRenumber(ctx context.Context, plan RenumberPlan) error {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		return tx.applyRenumberInternal(ctx, plan)
+	})
+}
+
+func (i *ipamer) applyRenumberInternal(ctx context.Context, plan RenumberPlan) error {
+	old, new, err := i.renumberPrefixes(ctx, plan.OldCidr, plan.NewCidr)
+	if err != nil {
+		return err
+	}
+	newnet, err := netip.ParsePrefix(new.Cidr)
+	if err != nil {
+		return err
+	}
+	mapped := make(map[string]string)
+	ips := make([]string, 0, len(plan.Mappings))
+	for _, m := range plan.Mappings {
+		detail, ok := old.Ips[m.Old]
+		if !ok || isReservation(detail) {
+			return fmt.Errorf("%w: ip %s is not acquired in %s", ErrNotFound, m.Old, old.Cidr)
+		}
+		if _, ok := mapped[m.Old]; ok {
+			return fmt.Errorf("ip %s is mapped twice", m.Old)
+		}
+		addr, err := netip.ParseAddr(m.New)
+		if err != nil || !newnet.Contains(addr) {
+			return fmt.Errorf("ip %s is not in %s", m.New, new.Cidr)
+		}
+		if new.allocatedSet().Contains(addr) || new.quarantinedSet(time.Now()).Contains(addr) {
+			return fmt.Errorf("%w: ip %s of %s", ErrAlreadyAllocated, addr, new.Cidr)
+		}
+		new.acquire(detail, addr)
+		mapped[m.Old] = addr.String()
+		ips = append(ips, addr.String())
+	}
+	if unmapped := unmappedIPs(old, mapped); len(unmapped) > 0 {
+		return fmt.Errorf("plan does not map the ips %s of %s, all acquired ips must be mapped", strings.Join(unmapped, ","), old.Cidr)
+	}
+	new.RenumberFrom = old.Cidr
+	err = i.persistIPs(ctx, new, ips, true)
+	if err != nil {
+		return fmt.Errorf("unable to renumber %s to %s: %w", old.Cidr, new.Cidr, err)
+	}
+	if _, ok := i.storage.(IPStorage); ok {
+		_, err = i.storage.UpdatePrefix(ctx, *new)
+		if err != nil {
+			return fmt.Errorf("unable to link prefix %s to %s: %w", new.Cidr, old.Cidr, err)
+		}
+	}
+	return i.modifyPrefix(ctx, old.Cidr, func(p *Prefix) error {
+		p.RenumberTo = new.Cidr
+		p.Renumbered = mapped
+		return nil
+	})
+}
+
+// RenumberPlanOf returns the applied mapping of the renumbered prefix oldCidr.
+func (i *ipamer) RenumberPlanOf(ctx context.Context, oldCidr string) (*RenumberPlan, error) {
+	old := i.PrefixFrom(ctx, oldCidr)
+	if old == nil {
+		return nil, fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, oldCidr)
+	}
+	if old.RenumberTo == "" {
+		return nil, fmt.Errorf("%w: prefix %s is not renumbered", ErrNotFound, old.Cidr)
+	}
+	plan := &RenumberPlan{OldCidr: old.Cidr, NewCidr: old.RenumberTo, Mappings: []RenumberMapping{}}
+	for o, n := range old.Renumbered {
+		plan.Mappings = append(plan.Mappings, RenumberMapping{Old: o, New: n, Detail: old.Ips[o]})
+	}
+	sort.Slice(plan.Mappings, func(a, b int) bool {
+		return netip.MustParseAddr(plan.Mappings[a].Old).Less(netip.MustParseAddr(plan.Mappings[b].Old))
+	})
+	return plan, nil
+}
+
+// unlinkRenumber removes the link between the renumbered prefix old and its new prefix.
+func (i *ipamer) unlinkRenumber(ctx context.Context, old *Prefix) error {
+	err := i.modifyPrefix(ctx, old.RenumberTo, func(p *Prefix) error {
+		p.RenumberFrom = ""
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return i.modifyPrefix(ctx, old.Cidr, func(p *Prefix) error {
+		p.RenumberTo = ""
+		p.Renumbered = nil
+		return nil
+	})
+}
+
+// RetireRenumber ends the transition of the renumbered prefix oldCidr, it releases its addresses and deletes it.
+// It is refused while the prefix holds addresses which are not part of the applied mapping.
+func (i *ipamer) RetireRenumber(ctx context.Context, oldCidr string) (*Prefix, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var prefix *Prefix
+	err = i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		var err error
+		prefix, err = tx.retireRenumberInternal(ctx, oldCidr)
+		return err
+	})
+	return prefix, err
+}
+
+func (i *ipamer) retireRenumberInternal(ctx context.Context, oldCidr string) (*Prefix, error) {
+	old := i.PrefixFrom(ctx, oldCidr)
+	if old == nil {
+		return nil, fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, oldCidr)
+	}
+	if old.RenumberTo == "" {
+		return nil, fmt.Errorf("prefix %s is not renumbered", old.Cidr)
+	}
+	if unmapped := unmappedIPs(old, old.Renumbered); len(unmapped) > 0 {
+		return nil, fmt.Errorf("prefix %s holds the ips %s which are not renumbered to %s, cancel the renumbering and apply a new plan",
+			old.Cidr, strings.Join(unmapped, ","), old.RenumberTo)
+	}
+	err := i.unlinkRenumber(ctx, old)
+	if err != nil {
+		return nil, err
+	}
+	old = i.PrefixFrom(ctx, oldCidr)
+	var addrs []netip.Addr
+	for ip, detail := range old.Ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || isReservation(detail) {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) > 0 {
+		err = i.persistRelease(ctx, old, addrs)
+		if err != nil {
+			return nil, fmt.Errorf("unable to release ips of %s: %w", old.Cidr, err)
+		}
+	}
+	return i.deletePrefixInternal(ctx, old.Cidr)
+}
+
+// CancelRenumber undoes ApplyRenumber, it releases the new addresses of the mapping and unlinks both prefixes.
+func (i *ipamer) CancelRenumber(ctx context.Context, oldCidr string) error {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		return tx.cancelRenumberInternal(ctx, oldCidr)
+	})
+}
+
+func (i *ipamer) cancelRenumberInternal(ctx context.Context, oldCidr string) error {
+	old := i.PrefixFrom(ctx, oldCidr)
+	if old == nil {
+		return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, oldCidr)
+	}
+	if old.RenumberTo == "" {
+		return fmt.Errorf("prefix %s is not renumbered", old.Cidr)
+	}
+	new := i.PrefixFrom(ctx, old.RenumberTo)
+	if new == nil {
+		return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, old.RenumberTo)
+	}
+	var addrs []netip.Addr
+	for _, ip := range old.Renumbered {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !new.allocatedSet().Contains(addr) {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) > 0 {
+		err := i.persistRelease(ctx, new, addrs)
+		if err != nil {
+			return fmt.Errorf("unable to release ips of %s: %w", new.Cidr, err)
+		}
+	}
+	return i.unlinkRenumber(ctx, old)
+}
+
+// WriteCSV writes the mapping of plan as csv with a header line, for the teams configuring the servers.
+func (plan *RenumberPlan) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"old", "new", "user", "description", "operator", "date"})
+	if err != nil {
+		return err
+	}
+	for _, m := range plan.Mappings {
+		err = cw.Write([]string{m.Old, m.New, m.Detail.User, m.Detail.Description, m.Detail.Operator, m.Detail.Date})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
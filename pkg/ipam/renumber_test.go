@@ -0,0 +1,186 @@
+package ipam
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// newRenumbering creates the old prefix 10.0.0.0/24 with 10.0.0.2, 10.0.0.3 and 10.0.0.10 acquired
+// and the new prefix 10.1.0.0/24 with 10.1.0.3 acquired.
+func newRenumbering(t *testing.T) (*ipamer, context.Context) {
+	t.Helper()
+	i := New().(*ipamer)
+	newTestPrefix(t, i, prod, "10.0.0.0/24", "10.0.0.1", false, "")
+	newTestPrefix(t, i, prod, "10.1.0.0/24", "10.1.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	for _, ip := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.10"} {
+		if _, err := i.AcquireSpecificIP(ctx, "10.0.0.0/24", IPDetail{User: "host" + ip}, ip, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := i.AcquireSpecificIP(ctx, "10.1.0.0/24", IPDetail{User: "other"}, "10.1.0.3", 1); err != nil {
+		t.Fatal(err)
+	}
+	return i, ctx
+}
+
+func TestPlanRenumber(t *testing.T) {
+	i, ctx := newRenumbering(t)
+	plan, err := i.PlanRenumber(ctx, "10.0.0.0/24", "10.1.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// host offsets are kept where they are free, 10.1.0.3 is taken
+	want := map[string]string{"10.0.0.2": "10.1.0.2", "10.0.0.3": "10.1.0.4", "10.0.0.10": "10.1.0.10"}
+	if len(plan.Mappings) != len(want) {
+		t.Fatalf("got mappings %+v, want %v", plan.Mappings, want)
+	}
+	for _, m := range plan.Mappings {
+		if want[m.Old] != m.New {
+			t.Errorf("got %s mapped to %s, want %s", m.Old, m.New, want[m.Old])
+		}
+		if m.Detail.User != "host"+m.Old {
+			t.Errorf("got user %q for %s, want the one of the old ip", m.Detail.User, m.Old)
+		}
+	}
+	if _, err := i.PlanRenumber(ctx, "10.0.0.0/24", "10.0.0.0/24"); err == nil {
+		t.Error("expected a renumbering into the same prefix to be refused")
+	}
+}
+
+func TestApplyRenumber(t *testing.T) {
+	i, ctx := newRenumbering(t)
+	plan, err := i.PlanRenumber(ctx, "10.0.0.0/24", "10.1.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partial := *plan
+	partial.Mappings = plan.Mappings[:2]
+	err = i.ApplyRenumber(ctx, partial)
+	if err == nil || !strings.Contains(err.Error(), "10.0.0.10") {
+		t.Fatalf("got %v, want the plan without 10.0.0.10 to be refused", err)
+	}
+	if p := i.PrefixFrom(ctx, "10.1.0.0/24"); p.RenumberFrom != "" || len(p.Ips) != 4 {
+		t.Fatalf("refused plan changed the new prefix: linked from %q with %d ips", p.RenumberFrom, len(p.Ips))
+	}
+
+	if err := i.ApplyRenumber(ctx, *plan); err != nil {
+		t.Fatal(err)
+	}
+	new := i.PrefixFrom(ctx, "10.1.0.0/24")
+	for _, m := range plan.Mappings {
+		if new.Ips[m.New].User != "host"+m.Old {
+			t.Errorf("got %s acquired for %q, want the user of %s", m.New, new.Ips[m.New].User, m.Old)
+		}
+	}
+	// the old ips stay acquired but no more can be acquired in the old prefix
+	old := i.PrefixFrom(ctx, "10.0.0.0/24")
+	if old.RenumberTo != "10.1.0.0/24" || len(old.Renumbered) != 3 {
+		t.Fatalf("got old prefix renumbered to %q with %d mappings", old.RenumberTo, len(old.Renumbered))
+	}
+	if _, err := i.AcquireIP(ctx, "10.0.0.0/24", IPDetail{User: "late"}, 1, AcquireIPOptions{}); err == nil {
+		t.Error("expected AcquireIP in the renumbered prefix to be refused")
+	}
+	if _, err := i.AcquireSpecificIP(ctx, "10.0.0.0/24", IPDetail{User: "late"}, "10.0.0.20", 1); err == nil {
+		t.Error("expected AcquireSpecificIP in the renumbered prefix to be refused")
+	}
+	if err := i.ApplyRenumber(ctx, *plan); err == nil {
+		t.Error("expected a second apply to be refused")
+	}
+
+	applied, err := i.RenumberPlanOf(ctx, "10.0.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied.Mappings) != 3 || applied.Mappings[0].Old != "10.0.0.2" {
+		t.Errorf("got applied plan %+v", applied.Mappings)
+	}
+}
+
+func TestRetireRenumber(t *testing.T) {
+	i, ctx := newRenumbering(t)
+	plan, err := i.PlanRenumber(ctx, "10.0.0.0/24", "10.1.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.ApplyRenumber(ctx, *plan); err != nil {
+		t.Fatal(err)
+	}
+
+	// an ip which is not mapped, e.g. acquired before acquisition was refused, is not released
+	err = i.modifyPrefix(ctx, "10.0.0.0/24", func(p *Prefix) error {
+		p.acquire(IPDetail{User: "late"}, netip.MustParseAddr("10.0.0.20"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.RetireRenumber(ctx, "10.0.0.0/24"); err == nil || !strings.Contains(err.Error(), "10.0.0.20") {
+		t.Fatalf("got %v, want the retire to be refused for 10.0.0.20", err)
+	}
+	if i.PrefixFrom(ctx, "10.0.0.0/24") == nil {
+		t.Fatal("old prefix deleted by the refused retire")
+	}
+	err = i.modifyPrefix(ctx, "10.0.0.0/24", func(p *Prefix) error {
+		p.release(netip.MustParseAddr("10.0.0.20"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := i.RetireRenumber(ctx, "10.0.0.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if i.PrefixFrom(ctx, "10.0.0.0/24") != nil {
+		t.Error("old prefix kept after the retire")
+	}
+	new := i.PrefixFrom(ctx, "10.1.0.0/24")
+	if new.RenumberFrom != "" {
+		t.Errorf("new prefix still linked from %s", new.RenumberFrom)
+	}
+	for _, m := range plan.Mappings {
+		if _, ok := new.Ips[m.New]; !ok {
+			t.Errorf("ip %s released by the retire", m.New)
+		}
+	}
+}
+
+func TestCancelRenumber(t *testing.T) {
+	i, ctx := newRenumbering(t)
+	plan, err := i.PlanRenumber(ctx, "10.0.0.0/24", "10.1.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.ApplyRenumber(ctx, *plan); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.CancelRenumber(ctx, "10.0.0.0/24"); err != nil {
+		t.Fatal(err)
+	}
+
+	new := i.PrefixFrom(ctx, "10.1.0.0/24")
+	for _, m := range plan.Mappings {
+		if _, ok := new.Ips[m.New]; ok {
+			t.Errorf("ip %s of the mapping is still acquired", m.New)
+		}
+	}
+	if _, ok := new.Ips["10.1.0.3"]; !ok {
+		t.Error("ip 10.1.0.3 acquired before the renumbering was released")
+	}
+	old := i.PrefixFrom(ctx, "10.0.0.0/24")
+	if old.RenumberTo != "" || new.RenumberFrom != "" || len(old.Renumbered) != 0 {
+		t.Error("prefixes are still linked after the cancel")
+	}
+	for _, m := range plan.Mappings {
+		if _, ok := old.Ips[m.Old]; !ok {
+			t.Errorf("old ip %s released by the cancel", m.Old)
+		}
+	}
+	if _, err := i.AcquireIP(ctx, "10.0.0.0/24", IPDetail{User: "late"}, 1, AcquireIPOptions{}); err != nil {
+		t.Errorf("expected ips to be acquired in the old prefix after the cancel: %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/netip"
+
+	"go4.org/netipx"
+)
+
+// ReservedRange is a named range of addresses inside a Prefix, e.g. a dhcp pool or addresses
+// kept for switches. AcquireIP skips these addresses unless the range is requested explicitly.
+type ReservedRange struct {
+	Name        string `json:"name"`        //名称
+	From        string `json:"from"`        //起始地址
+	To          string `json:"to"`          //结束地址
+	Description string `json:"description"` //描述
+}
+
+// RangeUsage of a ReservedRange
+type RangeUsage struct {
+	Name string
+	// Size the number of addresses in the range
+	Size *big.Int
+	// AcquiredIPs the number of acquired addresses inside the range
+	AcquiredIPs *big.Int
+}
+
+// ipRange returns the addresses covered by r.
+func (r ReservedRange) ipRange() (netipx.IPRange, error) {
+	from, err := netip.ParseAddr(r.From)
+	if err != nil {
+		return netipx.IPRange{}, fmt.Errorf("invalid start address of range %s:%s", r.Name, r.From)
+	}
+	to, err := netip.ParseAddr(r.To)
+	if err != nil {
+		return netipx.IPRange{}, fmt.Errorf("invalid end address of range %s:%s", r.Name, r.To)
+	}
+	ipr := netipx.IPRangeFrom(from, to)
+	if !ipr.IsValid() {
+		return netipx.IPRange{}, fmt.Errorf("invalid range %s:%s-%s", r.Name, r.From, r.To)
+	}
+	return ipr, nil
+}
+
+// reservedRange returns the range with the given name.
+func (p *Prefix) reservedRange(name string) (ReservedRange, bool) {
+	for _, r := range p.Ranges {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return ReservedRange{}, false
+}
+
+// restrictToRanges limits free to the addresses of the named range, or removes all
+// reserved ranges from free if name is empty.
+func (p *Prefix) restrictToRanges(free *netipx.IPSet, name string) (*netipx.IPSet, error) {
+	var b netipx.IPSetBuilder
+	if name != "" {
+		r, ok := p.reservedRange(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: range %s not found in prefix %s", ErrNotFound, name, p.Cidr)
+		}
+		ipr, err := r.ipRange()
+		if err != nil {
+			return nil, err
+		}
+		b.AddRange(ipr)
+		b.Intersect(free)
+	} else {
+		b.AddSet(free)
+		for _, r := range p.Ranges {
+			ipr, err := r.ipRange()
+			if err != nil {
+				continue
+			}
+			b.RemoveRange(ipr)
+		}
+	}
+	set, err := b.IPSet()
+	if err != nil {
+		return nil, fmt.Errorf("error constructing ipset:%w", err)
+	}
+	return set, nil
+}
+
+// rangesUsage reports the size and acquired addresses of every reserved range.
+func (p *Prefix) rangesUsage() (*big.Int, []RangeUsage) {
+	reserved := new(big.Int)
+	usages := []RangeUsage{}
+	for _, r := range p.Ranges {
+		ipr, err := r.ipRange()
+		if err != nil {
+			continue
+		}
+		var b netipx.IPSetBuilder
+		b.AddRange(ipr)
+		b.Intersect(p.allocatedSet())
+		acquired, _ := b.IPSet()
+		u := RangeUsage{Name: r.Name, Size: rangeSize(ipr), AcquiredIPs: setSize(acquired)}
+		reserved.Add(reserved, u.Size)
+		usages = append(usages, u)
+	}
+	return reserved, usages
+}
+
+func (i *ipamer) AddReservedRange(ctx context.Context, prefixCidr string, r ReservedRange) error {
+	return retryOnOptimisticLock(func() error {
+		prefix := i.PrefixFrom(ctx, prefixCidr)
+		if prefix == nil {
+			return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, prefixCidr)
+		}
+		if prefix.IsParent {
+			return fmt.Errorf("prefix %s has childprefixes, reserving a range is not possible", prefix.Cidr)
+		}
+		err := prefix.addRange(r)
+		if err != nil {
+			return err
+		}
+		_, err = i.storage.UpdatePrefix(ctx, *prefix)
+		if err != nil {
+			return fmt.Errorf("unable to AddReservedRange prefix:%s error:%w", prefixCidr, err)
+		}
+		return nil
+	})
+}
+
+// addRange validates r against the prefix and its existing ranges and adds it.
+func (p *Prefix) addRange(r ReservedRange) error {
+	if r.Name == "" {
+		return fmt.Errorf("range name must not be empty")
+	}
+	ipr, err := r.ipRange()
+	if err != nil {
+		return err
+	}
+	ipnet, err := netip.ParsePrefix(p.Cidr)
+	if err != nil {
+		return err
+	}
+	if !ipnet.Contains(ipr.From()) || !ipnet.Contains(ipr.To()) {
+		return fmt.Errorf("range %s:%s is not in %s", r.Name, ipr, p.Cidr)
+	}
+	for _, existing := range p.Ranges {
+		if existing.Name == r.Name {
+			return fmt.Errorf("range %s already exists in %s", r.Name, p.Cidr)
+		}
+		eipr, err := existing.ipRange()
+		if err != nil {
+			continue
+		}
+		if eipr.Overlaps(ipr) {
+			return fmt.Errorf("range %s:%s overlaps range %s:%s", r.Name, ipr, existing.Name, eipr)
+		}
+	}
+	r.From, r.To = ipr.From().String(), ipr.To().String()
+	p.Ranges = append(p.Ranges, r)
+	return nil
+}
+
+func (i *ipamer) DeleteReservedRange(ctx context.Context, prefixCidr, name string) error {
+	return retryOnOptimisticLock(func() error {
+		prefix := i.PrefixFrom(ctx, prefixCidr)
+		if prefix == nil {
+			return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, prefixCidr)
+		}
+		ranges := []ReservedRange{}
+		for _, r := range prefix.Ranges {
+			if r.Name != name {
+				ranges = append(ranges, r)
+			}
+		}
+		if len(ranges) == len(prefix.Ranges) {
+			return fmt.Errorf("%w: range %s not found in prefix %s", ErrNotFound, name, prefixCidr)
+		}
+		prefix.Ranges = ranges
+		_, err := i.storage.UpdatePrefix(ctx, *prefix)
+		if err != nil {
+			return fmt.Errorf("unable to DeleteReservedRange prefix:%s error:%w", prefixCidr, err)
+		}
+		return nil
+	})
+}
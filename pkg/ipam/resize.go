@@ -0,0 +1,375 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"go4.org/netipx"
+)
+
+// isStructuralReservation reports whether detail belongs to the gateway, network or broadcast address
+// reserved when a prefix is created, these are reserved anew whenever a prefix changes its size.
+func isStructuralReservation(detail IPDetail) bool {
+	if !isReservation(detail) {
+		return false
+	}
+	switch detail.Description {
+	case gatewayDescription, networkDescription, broadcastDescription:
+		return true
+	}
+	return false
+}
+
+// reshape creates the prefix ipnet with the attributes of like and the allocations and ranges
+// of sources which fall inside ipnet. Allocations colliding with the reserved addresses of ipnet
+// and ranges only partly inside ipnet are refused.
+func (i *ipamer) reshape(like *Prefix, ipnet netip.Prefix, gateway string, sources []*Prefix) (*Prefix, error) {
+	p, err := i.newPrefix(ipnet.String(), gateway, like.VlanID, like.VRF, like.IDC, like.ParentCidr, like.IsParent, like.Kind)
+	if err != nil {
+		return nil, err
+	}
+	p.Strategy = like.Strategy
+	p.Tags = append([]string(nil), like.Tags...)
+	p.Template = like.Template
+	p.Quarantine = like.Quarantine
+	for _, s := range sources {
+		for id, ips := range s.Requests {
+			var inside []string
+			for _, ip := range ips {
+				if addr, err := netip.ParseAddr(ip); err == nil && ipnet.Contains(addr) {
+					inside = append(inside, ip)
+				}
+			}
+			if len(inside) > 0 {
+				p.rememberRequest(id, append(p.Requests[id], inside...))
+			}
+		}
+		for ip, until := range s.Quarantined {
+			addr, err := netip.ParseAddr(ip)
+			if err != nil || !ipnet.Contains(addr) {
+				continue
+			}
+			if p.Quarantined == nil {
+				p.Quarantined = make(map[string]string)
+			}
+			p.Quarantined[ip] = until
+		}
+		for ip, detail := range s.Ips {
+			addr, err := netip.ParseAddr(ip)
+			if err != nil || !ipnet.Contains(addr) || isStructuralReservation(detail) {
+				continue
+			}
+			if p.allocatedSet().Contains(addr) {
+				return nil, fmt.Errorf("%w: ip %s of %s is a reserved address of %s", ErrAlreadyAllocated, ip, s.Cidr, p.Cidr)
+			}
+			p.acquire(detail, addr)
+		}
+		for _, r := range s.Ranges {
+			ipr, err := r.ipRange()
+			if err != nil {
+				continue
+			}
+			from, to := ipnet.Contains(ipr.From()), ipnet.Contains(ipr.To())
+			if from != to {
+				return nil, fmt.Errorf("range %s:%s of %s is only partly inside %s", r.Name, ipr, s.Cidr, p.Cidr)
+			}
+			if !from {
+				continue
+			}
+			err = p.addRange(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+// outside returns an error if an allocation or range of p falls outside ipnet.
+func outside(p *Prefix, ipnet netip.Prefix) error {
+	for ip, detail := range p.Ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || isStructuralReservation(detail) && detail.Description != gatewayDescription {
+			continue
+		}
+		if !ipnet.Contains(addr) {
+			return fmt.Errorf("ip %s of %s would fall outside of %s", ip, p.Cidr, ipnet)
+		}
+	}
+	for _, r := range p.Ranges {
+		ipr, err := r.ipRange()
+		if err != nil {
+			continue
+		}
+		if !ipnet.Contains(ipr.From()) || !ipnet.Contains(ipr.To()) {
+			return fmt.Errorf("range %s:%s of %s would fall outside of %s", r.Name, ipr, p.Cidr, ipnet)
+		}
+	}
+	return nil
+}
+
+// reshapable returns an error if the size of p can not be changed.
+func reshapable(p *Prefix) error {
+	if p.Kind == KindLoopbackPool {
+		return fmt.Errorf("loopback pool %s can not be split, merged or resized", p.Cidr)
+	}
+	if p.RenumberTo != "" || p.RenumberFrom != "" {
+		return fmt.Errorf("prefix %s is being renumbered", p.Cidr)
+	}
+	if p.hasChildPrefixes() {
+		return fmt.Errorf("prefix %s has child prefixes, only leaf prefixes can be split, merged or resized", p.Cidr)
+	}
+	return nil
+}
+
+// replacePrefixes creates created, deletes deleted and updates the child prefixes of their parent.
+func (i *ipamer) replacePrefixes(ctx context.Context, deleted []*Prefix, created []*Prefix) error {
+	for _, p := range created {
+		_, err := i.storage.CreatePrefix(ctx, *p)
+		if err != nil {
+			return fmt.Errorf("unable to create prefix %s: %w", p.Cidr, err)
+		}
+	}
+	for _, p := range deleted {
+		_, err := i.storage.DeletePrefix(ctx, *p)
+		if err != nil {
+			return fmt.Errorf("unable to delete prefix %s: %w", p.Cidr, err)
+		}
+	}
+	parentCidr := deleted[0].ParentCidr
+	if parentCidr == "" {
+		return nil
+	}
+	return i.modifyPrefix(ctx, parentCidr, func(parent *Prefix) error {
+		if parent.availableChildPrefixes == nil {
+			parent.availableChildPrefixes = make(map[string]bool)
+		}
+		for _, p := range deleted {
+			delete(parent.availableChildPrefixes, p.Cidr)
+		}
+		for _, p := range created {
+			parent.availableChildPrefixes[p.Cidr] = false
+		}
+		parent.IsParent = true
+		return nil
+	})
+}
+
+// SplitPrefix replaces the leaf prefix cidr by the prefixes of length bits it consists of.
+func (i *ipamer) SplitPrefix(ctx context.Context, cidr string, bits uint8) ([]*Prefix, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var pieces []*Prefix
+	err = i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		var err error
+		pieces, err = tx.splitPrefixInternal(ctx, cidr, bits)
+		return err
+	})
+	return pieces, err
+}
+
+func (i *ipamer) splitPrefixInternal(ctx context.Context, cidr string, bits uint8) ([]*Prefix, error) {
+	p := i.PrefixFrom(ctx, cidr)
+	if p == nil {
+		return nil, fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, cidr)
+	}
+	err := reshapable(p)
+	if err != nil {
+		return nil, err
+	}
+	ipnet, err := netip.ParsePrefix(p.Cidr)
+	if err != nil {
+		return nil, err
+	}
+	if int(bits) <= ipnet.Bits() || int(bits) > ipnet.Addr().BitLen() {
+		return nil, fmt.Errorf("given length:%d must be greater than prefix length:%d", bits, ipnet.Bits())
+	}
+	if int(bits)-ipnet.Bits() > 8 {
+		return nil, fmt.Errorf("splitting %s into more than 256 prefixes is not possible", p.Cidr)
+	}
+	gw, _ := netip.ParseAddr(p.Gateway)
+	var pieces []*Prefix
+	for piece := netip.PrefixFrom(ipnet.Addr(), int(bits)); ipnet.Contains(piece.Addr()); {
+		gateway := ""
+		if gw.IsValid() && piece.Contains(gw) {
+			gateway = gw.String()
+		} else if p.Kind == KindSubnet || p.Kind == "" {
+			gateway = childGateway(p, piece).String()
+		}
+		np, err := i.reshape(p, piece, gateway, []*Prefix{p})
+		if err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, np)
+		last := netipx.PrefixLastIP(piece)
+		if !last.Next().IsValid() {
+			break
+		}
+		piece = netip.PrefixFrom(last.Next(), int(bits))
+	}
+	err = i.replacePrefixes(ctx, []*Prefix{p}, pieces)
+	if err != nil {
+		return nil, err
+	}
+	return pieces, nil
+}
+
+// MergePrefixes replaces the adjacent sibling leaf prefixes cidrs by their supernet.
+func (i *ipamer) MergePrefixes(ctx context.Context, cidrs []string) (*Prefix, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var merged *Prefix
+	err = i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		var err error
+		merged, err = tx.mergePrefixesInternal(ctx, cidrs)
+		return err
+	})
+	return merged, err
+}
+
+func (i *ipamer) mergePrefixesInternal(ctx context.Context, cidrs []string) (*Prefix, error) {
+	if len(cidrs) < 2 {
+		return nil, fmt.Errorf("at least two prefixes are needed for a merge")
+	}
+	var sources []*Prefix
+	var b netipx.IPSetBuilder
+	for _, cidr := range cidrs {
+		p := i.PrefixFrom(ctx, cidr)
+		if p == nil {
+			return nil, fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, cidr)
+		}
+		err := reshapable(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(sources) > 0 && (p.ParentCidr != sources[0].ParentCidr || p.Kind != sources[0].Kind) {
+			return nil, fmt.Errorf("prefixes %s and %s are no siblings of the same kind", sources[0].Cidr, p.Cidr)
+		}
+		ipnet, err := netip.ParsePrefix(p.Cidr)
+		if err != nil {
+			return nil, err
+		}
+		b.AddPrefix(ipnet)
+		sources = append(sources, p)
+	}
+	set, err := b.IPSet()
+	if err != nil {
+		return nil, fmt.Errorf("error constructing ipset:%w", err)
+	}
+	supernet, ok := set.Ranges()[0].Prefix()
+	if len(set.Ranges()) != 1 || !ok {
+		return nil, fmt.Errorf("prefixes %v do not form a single supernet", cidrs)
+	}
+	existing, err := i.namespacePrefixes(ctx, NamespaceFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var others Prefixes
+	for _, e := range existing {
+		if !set.ContainsPrefix(netip.MustParsePrefix(e.Cidr)) {
+			others = append(others, e)
+		}
+	}
+	_, children, err := enclosing(&Prefix{Cidr: supernet.String()}, others)
+	if err != nil {
+		return nil, err
+	}
+	if len(children) > 0 {
+		return nil, fmt.Errorf("supernet %s would enclose %s", supernet, children[0].Cidr)
+	}
+	// the lowest prefix gives gateway and attributes of the supernet.
+	sort.Slice(sources, func(a, b int) bool {
+		return netip.MustParsePrefix(sources[a].Cidr).Addr().Less(netip.MustParsePrefix(sources[b].Cidr).Addr())
+	})
+	merged, err := i.reshape(sources[0], supernet, sources[0].Gateway, sources)
+	if err != nil {
+		return nil, err
+	}
+	err = i.replacePrefixes(ctx, sources, []*Prefix{merged})
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// ResizePrefix changes the length of the leaf prefix cidr to bits, keeping its network address.
+func (i *ipamer) ResizePrefix(ctx context.Context, cidr string, bits uint8) (*Prefix, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var prefix *Prefix
+	err = i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		var err error
+		prefix, err = tx.resizePrefixInternal(ctx, cidr, bits)
+		return err
+	})
+	return prefix, err
+}
+
+func (i *ipamer) resizePrefixInternal(ctx context.Context, cidr string, bits uint8) (*Prefix, error) {
+	p := i.PrefixFrom(ctx, cidr)
+	if p == nil {
+		return nil, fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, cidr)
+	}
+	err := reshapable(p)
+	if err != nil {
+		return nil, err
+	}
+	ipnet, err := netip.ParsePrefix(p.Cidr)
+	if err != nil {
+		return nil, err
+	}
+	resized, err := ipnet.Addr().Prefix(int(bits))
+	if err != nil {
+		return nil, err
+	}
+	if resized.Bits() == ipnet.Bits() {
+		return nil, fmt.Errorf("prefix %s has length %d already", p.Cidr, bits)
+	}
+	if resized.Addr() != ipnet.Addr() {
+		return nil, fmt.Errorf("%s can not grow to /%d, its network address would change to %s", p.Cidr, bits, resized.Addr())
+	}
+	err = outside(p, resized)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := i.namespacePrefixes(ctx, NamespaceFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var others Prefixes
+	for _, e := range existing {
+		if e.Cidr != p.Cidr {
+			others = append(others, e)
+		}
+	}
+	parent, children, err := enclosing(&Prefix{Cidr: resized.String()}, others)
+	if err != nil {
+		return nil, err
+	}
+	if len(children) > 0 {
+		return nil, fmt.Errorf("%s would enclose %s", resized, children[0].Cidr)
+	}
+	if parent == nil && p.ParentCidr != "" || parent != nil && parent.Cidr != p.ParentCidr {
+		return nil, fmt.Errorf("%s would not fit into its parent %s", resized, p.ParentCidr)
+	}
+	np, err := i.reshape(p, resized, p.Gateway, []*Prefix{p})
+	if err != nil {
+		return nil, err
+	}
+	err = i.replacePrefixes(ctx, []*Prefix{p}, []*Prefix{np})
+	if err != nil {
+		return nil, err
+	}
+	return np, nil
+}
@@ -0,0 +1,153 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// acquireUsers acquires each ip of users in cidr for its user.
+func acquireUsers(t *testing.T, i Ipamer, ctx context.Context, cidr string, users map[string]string) {
+	t.Helper()
+	for ip, user := range users {
+		if _, err := i.AcquireSpecificIP(ctx, cidr, IPDetail{User: user}, ip, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkUsers fails if an ip of users is not acquired for its user in p.
+func checkUsers(t *testing.T, p *Prefix, users map[string]string) {
+	t.Helper()
+	for ip, user := range users {
+		d, ok := p.Ips[ip]
+		if !ok || d.User != user {
+			t.Errorf("got %s of %s acquired %v for %q, want %q", ip, p.Cidr, ok, d.User, user)
+		}
+	}
+}
+
+func TestSplitPrefix(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/24", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	acquireUsers(t, i, ctx, "10.0.0.0/24", map[string]string{"10.0.0.10": "a", "10.0.0.200": "b"})
+
+	pieces, err := i.SplitPrefix(ctx, "10.0.0.0/24", 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pieces) != 2 || pieces[0].Cidr != "10.0.0.0/25" || pieces[1].Cidr != "10.0.0.128/25" {
+		t.Fatalf("got pieces %v, want 10.0.0.0/25 and 10.0.0.128/25", pieces)
+	}
+	checkUsers(t, i.PrefixFrom(ctx, "10.0.0.0/25"), map[string]string{"10.0.0.10": "a"})
+	checkUsers(t, i.PrefixFrom(ctx, "10.0.0.128/25"), map[string]string{"10.0.0.200": "b"})
+	if i.PrefixFrom(ctx, "10.0.0.0/24") != nil {
+		t.Error("split prefix is kept")
+	}
+	if _, ok := i.PrefixFrom(ctx, "10.0.0.0/25").Ips["10.0.0.200"]; ok {
+		t.Error("ip 10.0.0.200 is carried over into 10.0.0.0/25")
+	}
+}
+
+func TestSplitPrefixReservedAddress(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/24", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	// 10.0.0.127 becomes the broadcast address of 10.0.0.0/25
+	acquireUsers(t, i, ctx, "10.0.0.0/24", map[string]string{"10.0.0.127": "a"})
+
+	if _, err := i.SplitPrefix(ctx, "10.0.0.0/24", 25); !errors.Is(err, ErrAlreadyAllocated) {
+		t.Fatalf("got %v, want %v", err, ErrAlreadyAllocated)
+	}
+	p := i.PrefixFrom(ctx, "10.0.0.0/24")
+	if p == nil {
+		t.Fatal("prefix deleted by the refused split")
+	}
+	checkUsers(t, p, map[string]string{"10.0.0.127": "a"})
+}
+
+func TestMergePrefixes(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/25", "10.0.0.1", false, "")
+	newTestPrefix(t, i, prod, "10.0.0.128/25", "10.0.0.129", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	acquireUsers(t, i, ctx, "10.0.0.0/25", map[string]string{"10.0.0.10": "a"})
+	acquireUsers(t, i, ctx, "10.0.0.128/25", map[string]string{"10.0.0.200": "b"})
+
+	merged, err := i.MergePrefixes(ctx, []string{"10.0.0.128/25", "10.0.0.0/25"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Cidr != "10.0.0.0/24" || merged.Gateway != "10.0.0.1" {
+		t.Fatalf("got %s with gateway %s, want 10.0.0.0/24 with the gateway of the lowest prefix", merged.Cidr, merged.Gateway)
+	}
+	checkUsers(t, i.PrefixFrom(ctx, "10.0.0.0/24"), map[string]string{"10.0.0.10": "a", "10.0.0.200": "b"})
+	for _, cidr := range []string{"10.0.0.0/25", "10.0.0.128/25"} {
+		if i.PrefixFrom(ctx, cidr) != nil {
+			t.Errorf("merged prefix %s is kept", cidr)
+		}
+	}
+}
+
+func TestMergePrefixesNotAdjacent(t *testing.T) {
+	tests := []struct {
+		name  string
+		cidrs []string
+	}{
+		{name: "gap", cidrs: []string{"10.0.0.0/26", "10.0.0.128/26"}},
+		{name: "no supernet", cidrs: []string{"10.0.0.128/25", "10.0.1.0/25"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := New()
+			for _, cidr := range tt.cidrs {
+				gateway := netip.MustParsePrefix(cidr).Addr().Next().String()
+				newTestPrefix(t, i, prod, cidr, gateway, false, "")
+			}
+			ctx := NewContextWithNamespace(context.Background(), prod)
+			_, err := i.MergePrefixes(ctx, tt.cidrs)
+			if err == nil || !strings.Contains(err.Error(), "single supernet") {
+				t.Fatalf("got %v, want the merge to be refused", err)
+			}
+			for _, cidr := range tt.cidrs {
+				if i.PrefixFrom(ctx, cidr) == nil {
+					t.Errorf("prefix %s deleted by the refused merge", cidr)
+				}
+			}
+		})
+	}
+}
+
+func TestResizePrefix(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/24", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	users := map[string]string{"10.0.0.10": "a", "10.0.0.200": "b"}
+	acquireUsers(t, i, ctx, "10.0.0.0/24", users)
+
+	_, err := i.ResizePrefix(ctx, "10.0.0.0/24", 25)
+	if err == nil || !strings.Contains(err.Error(), "10.0.0.200") {
+		t.Fatalf("got %v, want the shrink to be refused for 10.0.0.200", err)
+	}
+	checkUsers(t, i.PrefixFrom(ctx, "10.0.0.0/24"), users)
+
+	grown, err := i.ResizePrefix(ctx, "10.0.0.0/24", 23)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grown.Cidr != "10.0.0.0/23" {
+		t.Fatalf("got %s, want 10.0.0.0/23", grown.Cidr)
+	}
+	p := i.PrefixFrom(ctx, "10.0.0.0/23")
+	checkUsers(t, p, users)
+	// the old broadcast address is free in the grown prefix
+	if _, err := i.AcquireSpecificIP(ctx, "10.0.0.0/23", IPDetail{User: "c"}, "10.0.0.255", 1); err != nil {
+		t.Errorf("expected the old broadcast address to be free: %v", err)
+	}
+	if i.PrefixFrom(ctx, "10.0.0.0/24") != nil {
+		t.Error("resized prefix is kept")
+	}
+}
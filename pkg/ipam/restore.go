@@ -0,0 +1,146 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+)
+
+// RestoreIPs acquires the ips of the Prefix again with the given IPDetail, e.g. to undo their release.
+// If one of them is acquired an AlreadyAllocatedError is returned and nothing is changed,
+// the quarantine of the restored ips ends.
+func (i *ipamer) RestoreIPs(ctx context.Context, prefixCidr string, ips map[string]IPDetail) error {
+	return retryOnOptimisticLock(func() error {
+		return i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+			return tx.restoreIPsInternal(ctx, prefixCidr, ips)
+		})
+	})
+}
+
+func (i *ipamer) restoreIPsInternal(ctx context.Context, prefixCidr string, ips map[string]IPDetail) error {
+	prefix := i.PrefixFrom(ctx, prefixCidr)
+	if prefix == nil {
+		return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, prefixCidr)
+	}
+	if prefix.IsParent {
+		return fmt.Errorf("prefix %s has childprefixes, acquire ip not possible", prefix.Cidr)
+	}
+	if err := checkNotRenumbered(prefix); err != nil {
+		return err
+	}
+	ipnet, err := netip.ParsePrefix(prefix.Cidr)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(ips))
+	quarantined := false
+	for ip, detail := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !ipnet.Contains(addr) {
+			return fmt.Errorf("given ip:%s is not in %s", ip, prefix.Cidr)
+		}
+		if prefix.allocatedSet().Contains(addr) {
+			return fmt.Errorf("%w: given ip:%s is already allocated", ErrAlreadyAllocated, addr)
+		}
+		if _, ok := prefix.Quarantined[addr.String()]; ok {
+			delete(prefix.Quarantined, addr.String())
+			quarantined = true
+		}
+		prefix.acquire(detail, addr)
+		keys = append(keys, addr.String())
+	}
+	sort.Strings(keys)
+	err = i.persistIPs(ctx, prefix, keys, true)
+	if err != nil {
+		return err
+	}
+	// the quarantine is part of the prefix document, persistIPs only wrote the ips.
+	if _, ok := i.storage.(IPStorage); ok && quarantined {
+		_, err = i.storage.UpdatePrefix(ctx, *prefix)
+	}
+	return err
+}
+
+// RestorePrefix creates the deleted Prefix p again with its ips, ranges and settings below the same parent.
+// It is refused if p or a prefix inside of it exists, or if the nearest enclosing prefix is not its parent anymore.
+func (i *ipamer) RestorePrefix(ctx context.Context, p Prefix) (*Prefix, error) {
+	ctx = NewContextWithNamespace(ctx, p.Namespace())
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var prefix *Prefix
+	err = i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+		var err error
+		prefix, err = tx.restorePrefixInternal(ctx, p)
+		return err
+	})
+	return prefix, err
+}
+
+func (i *ipamer) restorePrefixInternal(ctx context.Context, p Prefix) (*Prefix, error) {
+	ipnet, err := netip.ParsePrefix(p.Cidr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse cidr:%s %w", p.Cidr, err)
+	}
+	existing, err := i.namespacePrefixes(ctx, p.Namespace())
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range existing {
+		enet, err := netip.ParsePrefix(e.Cidr)
+		if err != nil {
+			continue
+		}
+		if enet.Bits() >= ipnet.Bits() && ipnet.Contains(enet.Addr()) {
+			return nil, fmt.Errorf("%w: prefix %s overlaps %s", ErrAlreadyAllocated, e.Cidr, p.Cidr)
+		}
+	}
+	if p.Kind == KindHost && p.ParentCidr != "" {
+		if pool := i.PrefixFrom(ctx, p.ParentCidr); pool != nil && pool.Kind == KindLoopbackPool {
+			return i.restoreLoopbackInternal(ctx, p, pool)
+		}
+	}
+	_, err = i.newPrefixInternal(ctx, p.Cidr, p.Gateway, p.ParentCidr, p.VlanID, p.VRF, p.IDC, p.IsParent, p.Kind)
+	if err != nil {
+		return nil, err
+	}
+	restored := i.PrefixFrom(ctx, p.Cidr)
+	if restored == nil {
+		return nil, fmt.Errorf("%w: restored prefix:%s", ErrNotFound, p.Cidr)
+	}
+	restored.Strategy = p.Strategy
+	restored.Ranges = p.Ranges
+	restored.Template = p.Template
+	restored.Tags = p.Tags
+	restored.Quarantine = p.Quarantine
+	restored.Quarantined = copyStrings(p.Quarantined)
+	restored.Requests = copyRequests(p.Requests)
+	keys := make([]string, 0, len(p.Ips))
+	for ip, detail := range p.Ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !ipnet.Contains(addr) {
+			continue
+		}
+		restored.acquire(detail, addr)
+		keys = append(keys, addr.String())
+	}
+	// addresses acquired without an IPDetail are only part of the allocation index.
+	for _, r := range p.allocatedSet().Ranges() {
+		restored.acquireRange(r)
+	}
+	sort.Strings(keys)
+	if s, ok := i.storage.(IPStorage); ok {
+		err = s.UpdateIPs(ctx, *restored, keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	updated, err := i.storage.UpdatePrefix(ctx, *restored)
+	if err != nil {
+		return nil, fmt.Errorf("unable to update prefix:%s error:%w", p.Cidr, err)
+	}
+	return &updated, nil
+}
@@ -0,0 +1,100 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRestoreIPs(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/24", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	if err := i.EditPrefixQuarantine(ctx, "10.0.0.0/24", "24h"); err != nil {
+		t.Fatal(err)
+	}
+	ips, err := i.AcquireIP(ctx, "10.0.0.0/24", IPDetail{User: "bob"}, 2, AcquireIPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.ReleaseIPFromPrefix(ctx, "10.0.0.0/24", ips); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.AcquireSpecificIP(ctx, "10.0.0.0/24", IPDetail{User: "carol"}, "10.0.0.9", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// one of the ips is acquired, none is restored
+	restore := map[string]IPDetail{ips[0]: {User: "bob"}, "10.0.0.9": {User: "bob"}}
+	if err := i.RestoreIPs(ctx, "10.0.0.0/24", restore); !errors.Is(err, ErrAlreadyAllocated) {
+		t.Fatalf("got %v, want %v", err, ErrAlreadyAllocated)
+	}
+	p := i.PrefixFrom(ctx, "10.0.0.0/24")
+	if _, ok := p.Ips[ips[0]]; ok {
+		t.Fatalf("ip %s restored although the restore failed", ips[0])
+	}
+
+	restore = map[string]IPDetail{ips[0]: {User: "bob"}, ips[1]: {User: "bob"}}
+	if err := i.RestoreIPs(ctx, "10.0.0.0/24", restore); err != nil {
+		t.Fatal(err)
+	}
+	p = i.PrefixFrom(ctx, "10.0.0.0/24")
+	for _, ip := range ips {
+		if p.Ips[ip].User != "bob" {
+			t.Errorf("got %s acquired for %q, want bob", ip, p.Ips[ip].User)
+		}
+		if _, ok := p.Quarantined[ip]; ok {
+			t.Errorf("restored ip %s is still in quarantine", ip)
+		}
+	}
+}
+
+func TestRestorePrefix(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/16", "", true, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	child, err := i.AcquireSpecificChildPrefix(ctx, "10.0.0.0/16", "10.0.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.EditPrefixTags(ctx, child.Cidr, []string{"web"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.AddReservedRange(ctx, child.Cidr, ReservedRange{Name: "dhcp", From: "10.0.1.100", To: "10.0.1.199"}); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := i.DeletePrefix(ctx, child.Cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a prefix inside of the deleted one was created since
+	inside, err := i.AcquireSpecificChildPrefix(ctx, "10.0.0.0/16", "10.0.1.128/25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.RestorePrefix(ctx, *deleted); err == nil {
+		t.Fatal("expected the restore to be refused while a prefix inside of it exists")
+	}
+	if err := i.ReleaseChildPrefix(ctx, inside); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := i.RestorePrefix(ctx, *deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ParentCidr != "10.0.0.0/16" || len(restored.Tags) != 1 || len(restored.Ranges) != 1 {
+		t.Errorf("got parent %q, tags %v and ranges %v, want the ones before the deletion", restored.ParentCidr, restored.Tags, restored.Ranges)
+	}
+	if _, err := i.RestorePrefix(ctx, *deleted); err == nil {
+		t.Error("expected an existing prefix not to be restored")
+	}
+	r, err := i.CheckConsistency(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Findings) != 0 {
+		t.Errorf("got findings %v after the restore", r.Findings)
+	}
+}
@@ -1,15 +1,66 @@
 package ipam
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // Storage is a interface to store ipam objects.
+// Prefixes are stored per Namespace, the namespace of a given Prefix is taken from its IDC and VRF.
 type Storage interface {
 	Name() string
 	CreatePrefix(ctx context.Context, prefix Prefix) (Prefix, error)
-	ReadPrefix(ctx context.Context, prefix string) (Prefix, error)
+	ReadPrefix(ctx context.Context, prefix string, namespace Namespace) (Prefix, error)
 	DeleteAllPrefixes(ctx context.Context) error
+	// ReadAllPrefixes returns the prefixes of all namespaces.
 	ReadAllPrefixes(ctx context.Context) (Prefixes, error)
-	ReadAllPrefixCidrs(ctx context.Context) ([]string, error)
+	ReadAllPrefixCidrs(ctx context.Context, namespace Namespace) ([]string, error)
 	UpdatePrefix(ctx context.Context, prefix Prefix) (Prefix, error)
 	DeletePrefix(ctx context.Context, prefix Prefix) (Prefix, error)
-}
\ No newline at end of file
+	// RunInTransaction calls fn with a Storage whose reads and writes form one transaction,
+	// they become visible together if fn returns nil and are discarded if it returns an error.
+	// Only the Storage and the context passed to fn may be used inside fn.
+	RunInTransaction(ctx context.Context, fn func(ctx context.Context, tx Storage) error) error
+}
+
+// IPStorage is implemented by storages which keep every acquired ip in a document of its own.
+// The Ipamer writes acquired, changed and released ips through it instead of UpdatePrefix,
+// which then only persists the structural part of a prefix.
+type IPStorage interface {
+	// CreateIPs stores the given ips of prefix with their IPDetail,
+	// if one of them is stored already nothing is stored and an OptimisticLockError is returned.
+	CreateIPs(ctx context.Context, prefix Prefix, ips []string) error
+	// UpdateIPs stores the given ips of prefix with their IPDetail, overwriting existing ones.
+	UpdateIPs(ctx context.Context, prefix Prefix, ips []string) error
+	// DeleteIPs removes the given ips of prefix.
+	DeleteIPs(ctx context.Context, prefix Prefix, ips []string) error
+}
+
+// HistoryStorage is implemented by storages which keep a snapshot of a prefix every time it is written.
+// Snapshots older than the history retention configured for the storage are dropped.
+type HistoryStorage interface {
+	// PrefixVersions returns the snapshots of the given cidrs of namespace taken up to until, the oldest first.
+	// A zero until returns all snapshots.
+	PrefixVersions(ctx context.Context, namespace Namespace, cidrs []string, until time.Time) ([]PrefixVersion, error)
+}
+
+// IPChangeStorage is implemented by HistoryStorages which keep ips in records of their own. They take
+// a snapshot of a prefix only if the prefix itself changes and record every write of an ip as an IPChange.
+type IPChangeStorage interface {
+	HistoryStorage
+	// IPChanges returns the changes of ips of the given cidrs of namespace recorded from since up to until,
+	// the oldest first. A zero since or until is not limited, if ip is not empty only its changes are returned.
+	IPChanges(ctx context.Context, namespace Namespace, cidrs []string, ip string, since, until time.Time) ([]IPChange, error)
+}
+
+// ArchiveStorage is implemented by storages which keep deleted prefixes in an archive until they are purged.
+type ArchiveStorage interface {
+	// ArchivePrefix stores the deleted prefix in the archive and returns it with its ID.
+	ArchivePrefix(ctx context.Context, a ArchivedPrefix) (ArchivedPrefix, error)
+	// ReadArchivedPrefixes returns the archived prefixes of all namespaces, the latest deleted first.
+	ReadArchivedPrefixes(ctx context.Context) ([]ArchivedPrefix, error)
+	// ReadArchivedPrefix returns the archived prefix with the given ID, ErrNotFound if there is none.
+	ReadArchivedPrefix(ctx context.Context, id string) (ArchivedPrefix, error)
+	// DeleteArchivedPrefix removes the archived prefix with the given ID, ErrNotFound if there is none.
+	DeleteArchivedPrefix(ctx context.Context, id string) error
+}
@@ -0,0 +1,295 @@
+package ipam
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"go4.org/netipx"
+)
+
+// Names of the built-in allocation strategies.
+// skipfirst takes the number of addresses to skip as argument, e.g. "skipfirst:10".
+const (
+	StrategyFirstFree = "firstfree"
+	StrategyLastFree  = "lastfree"
+	StrategyRandom    = "random"
+	StrategySparse    = "sparse"
+	StrategySkipFirst = "skipfirst"
+)
+
+// Strategy decides which free addresses of a prefix are handed out by AcquireIP.
+type Strategy interface {
+	// Pick returns up to num addresses out of free, free only contains addresses of ipnet.
+	Pick(ipnet netip.Prefix, free *netipx.IPSet, num int) []netip.Addr
+	// PickBlock returns a range of size consecutive addresses out of free whose first address
+	// is a multiple of alignment, false is returned if there is no such range.
+	PickBlock(ipnet netip.Prefix, free *netipx.IPSet, size, alignment uint64) (netipx.IPRange, bool)
+}
+
+// ParseStrategy returns the Strategy described by spec, an empty spec selects firstfree.
+func ParseStrategy(spec string) (Strategy, error) {
+	name, arg, hasArg := strings.Cut(spec, ":")
+	switch name {
+	case "", StrategyFirstFree:
+		return firstFree{}, nil
+	case StrategyLastFree:
+		return lastFree{}, nil
+	case StrategyRandom:
+		return random{}, nil
+	case StrategySparse:
+		return sparse{}, nil
+	case StrategySkipFirst:
+		if !hasArg {
+			return nil, fmt.Errorf("strategy %s needs the number of addresses to skip, e.g. %s:10", name, name)
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid number of addresses to skip:%s", arg)
+		}
+		return skipFirst{n: n}, nil
+	}
+	return nil, fmt.Errorf("unknown allocation strategy:%s", spec)
+}
+
+// firstFree hands out the lowest free addresses.
+type firstFree struct{}
+
+func (firstFree) Pick(_ netip.Prefix, free *netipx.IPSet, num int) []netip.Addr {
+	var ips []netip.Addr
+	for _, r := range free.Ranges() {
+		for ip := r.From(); len(ips) < num; ip = ip.Next() {
+			ips = append(ips, ip)
+			if ip == r.To() {
+				break
+			}
+		}
+		if len(ips) == num {
+			break
+		}
+	}
+	return ips
+}
+
+func (firstFree) PickBlock(ipnet netip.Prefix, free *netipx.IPSet, size, alignment uint64) (netipx.IPRange, bool) {
+	for _, r := range free.Ranges() {
+		if block, ok := lowestBlock(ipnet, r, size, alignment); ok {
+			return block, true
+		}
+	}
+	return netipx.IPRange{}, false
+}
+
+// lastFree hands out the highest free addresses.
+type lastFree struct{}
+
+func (lastFree) Pick(_ netip.Prefix, free *netipx.IPSet, num int) []netip.Addr {
+	var ips []netip.Addr
+	ranges := free.Ranges()
+	for i := len(ranges) - 1; i >= 0 && len(ips) < num; i-- {
+		r := ranges[i]
+		for ip := r.To(); len(ips) < num; ip = ip.Prev() {
+			ips = append(ips, ip)
+			if ip == r.From() {
+				break
+			}
+		}
+	}
+	return ips
+}
+
+func (lastFree) PickBlock(ipnet netip.Prefix, free *netipx.IPSet, size, alignment uint64) (netipx.IPRange, bool) {
+	ranges := free.Ranges()
+	for i := len(ranges) - 1; i >= 0; i-- {
+		if block, ok := highestBlock(ipnet, ranges[i], size, alignment); ok {
+			return block, true
+		}
+	}
+	return netipx.IPRange{}, false
+}
+
+// random hands out free addresses at random.
+type random struct{}
+
+func (random) Pick(_ netip.Prefix, free *netipx.IPSet, num int) []netip.Addr {
+	var ips []netip.Addr
+	for len(ips) < num {
+		total := setSize(free)
+		if total.Sign() == 0 {
+			break
+		}
+		ip := nthAddr(free, randomInt(total))
+		ips = append(ips, ip)
+		free = without(free, ip)
+	}
+	return ips
+}
+
+func (random) PickBlock(ipnet netip.Prefix, free *netipx.IPSet, size, alignment uint64) (netipx.IPRange, bool) {
+	var fits []netipx.IPRange
+	for _, r := range free.Ranges() {
+		if _, ok := lowestBlock(ipnet, r, size, alignment); ok {
+			fits = append(fits, r)
+		}
+	}
+	if len(fits) == 0 {
+		return netipx.IPRange{}, false
+	}
+	r := fits[randomInt(big.NewInt(int64(len(fits)))).Int64()]
+	return blockIn(ipnet, r, size, alignment, randomInt)
+}
+
+// sparse hands out the middle address of the largest free run, which keeps the acquired
+// addresses of large prefixes far apart from each other.
+type sparse struct{}
+
+func (sparse) Pick(_ netip.Prefix, free *netipx.IPSet, num int) []netip.Addr {
+	var ips []netip.Addr
+	for len(ips) < num {
+		r, size := largestRun(free)
+		if size.Sign() == 0 {
+			break
+		}
+		half := new(big.Int).Rsh(size, 1)
+		ip := intAddr(half.Add(half, addrInt(r.From())), r.From().Is4())
+		ips = append(ips, ip)
+		free = without(free, ip)
+	}
+	return ips
+}
+
+func (sparse) PickBlock(ipnet netip.Prefix, free *netipx.IPSet, size, alignment uint64) (netipx.IPRange, bool) {
+	var largest netipx.IPRange
+	largestSize := new(big.Int)
+	for _, r := range free.Ranges() {
+		if _, ok := lowestBlock(ipnet, r, size, alignment); !ok {
+			continue
+		}
+		if s := rangeSize(r); s.Cmp(largestSize) > 0 {
+			largest, largestSize = r, s
+		}
+	}
+	if largestSize.Sign() == 0 {
+		return netipx.IPRange{}, false
+	}
+	return blockIn(ipnet, largest, size, alignment, func(starts *big.Int) *big.Int {
+		return new(big.Int).Rsh(starts, 1)
+	})
+}
+
+// blockIn returns one of the aligned blocks of size addresses inside r, r must hold at least one.
+// choose gets the number of possible blocks and returns the index of the block to use.
+func blockIn(ipnet netip.Prefix, r netipx.IPRange, size, alignment uint64, choose func(starts *big.Int) *big.Int) (netipx.IPRange, bool) {
+	low, _ := lowestBlock(ipnet, r, size, alignment)
+	high, _ := highestBlock(ipnet, r, size, alignment)
+	align := new(big.Int).SetUint64(alignment)
+	lowStart := addrOffset(ipnet, low.From())
+	starts := new(big.Int).Sub(addrOffset(ipnet, high.From()), lowStart)
+	starts.Div(starts, align).Add(starts, big.NewInt(1))
+	start := choose(starts)
+	start.Mul(start, align).Add(start, lowStart)
+	return blockAt(ipnet, start, addrOffset(ipnet, r.To()), size)
+}
+
+// without returns set without ip.
+func without(set *netipx.IPSet, ip netip.Addr) *netipx.IPSet {
+	var b netipx.IPSetBuilder
+	b.AddSet(set)
+	b.Remove(ip)
+	rest, err := b.IPSet()
+	if err != nil {
+		return &netipx.IPSet{}
+	}
+	return rest
+}
+
+// skipFirst hands out the lowest free addresses after the first n addresses of the prefix.
+type skipFirst struct {
+	n int
+}
+
+func (s skipFirst) Pick(ipnet netip.Prefix, free *netipx.IPSet, num int) []netip.Addr {
+	return firstFree{}.Pick(ipnet, s.skip(ipnet, free), num)
+}
+
+func (s skipFirst) PickBlock(ipnet netip.Prefix, free *netipx.IPSet, size, alignment uint64) (netipx.IPRange, bool) {
+	return firstFree{}.PickBlock(ipnet, s.skip(ipnet, free), size, alignment)
+}
+
+// skip removes the first n addresses of ipnet from free.
+func (s skipFirst) skip(ipnet netip.Prefix, free *netipx.IPSet) *netipx.IPSet {
+	if s.n == 0 {
+		return free
+	}
+	last := big.NewInt(int64(s.n - 1))
+	if last.Cmp(prefixSize(ipnet)) >= 0 {
+		return &netipx.IPSet{}
+	}
+	var b netipx.IPSetBuilder
+	b.AddSet(free)
+	b.RemoveRange(netipx.IPRangeFrom(ipnet.Masked().Addr(), addrAt(ipnet, last)))
+	rest, err := b.IPSet()
+	if err != nil {
+		return &netipx.IPSet{}
+	}
+	return rest
+}
+
+// lowestBlock returns the lowest aligned block of size addresses inside r.
+func lowestBlock(ipnet netip.Prefix, r netipx.IPRange, size, alignment uint64) (netipx.IPRange, bool) {
+	from, to := addrOffset(ipnet, r.From()), addrOffset(ipnet, r.To())
+	align := new(big.Int).SetUint64(alignment)
+	start := new(big.Int).Add(from, align)
+	start.Sub(start, big.NewInt(1)).Div(start, align).Mul(start, align)
+	return blockAt(ipnet, start, to, size)
+}
+
+// highestBlock returns the highest aligned block of size addresses inside r.
+func highestBlock(ipnet netip.Prefix, r netipx.IPRange, size, alignment uint64) (netipx.IPRange, bool) {
+	from, to := addrOffset(ipnet, r.From()), addrOffset(ipnet, r.To())
+	align := new(big.Int).SetUint64(alignment)
+	start := new(big.Int).Sub(to, new(big.Int).SetUint64(size))
+	start.Add(start, big.NewInt(1))
+	if start.Cmp(from) < 0 {
+		return netipx.IPRange{}, false
+	}
+	start.Div(start, align).Mul(start, align)
+	if start.Cmp(from) < 0 {
+		return netipx.IPRange{}, false
+	}
+	return blockAt(ipnet, start, to, size)
+}
+
+// blockAt returns the block of size addresses at offset start of ipnet,
+// false is returned if it ends after offset to.
+func blockAt(ipnet netip.Prefix, start, to *big.Int, size uint64) (netipx.IPRange, bool) {
+	end := new(big.Int).Add(start, new(big.Int).SetUint64(size))
+	end.Sub(end, big.NewInt(1))
+	if end.Cmp(to) > 0 {
+		return netipx.IPRange{}, false
+	}
+	return netipx.IPRangeFrom(addrAt(ipnet, start), addrAt(ipnet, end)), true
+}
+
+// largestRun returns the longest range of free.
+func largestRun(free *netipx.IPSet) (netipx.IPRange, *big.Int) {
+	var largest netipx.IPRange
+	size := new(big.Int)
+	for _, r := range free.Ranges() {
+		if s := rangeSize(r); s.Cmp(size) > 0 {
+			largest, size = r, s
+		}
+	}
+	return largest, size
+}
+
+// blockAlignment returns the smallest power of two which is not smaller than size.
+func blockAlignment(size uint64) uint64 {
+	alignment := uint64(1)
+	for alignment < size {
+		alignment <<= 1
+	}
+	return alignment
+}
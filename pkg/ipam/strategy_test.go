@@ -0,0 +1,92 @@
+package ipam
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+)
+
+func TestAcquireIPStrategies(t *testing.T) {
+	tests := []struct {
+		strategy string
+		num      int
+		want     []string
+	}{
+		{strategy: "", num: 2, want: []string{"10.0.0.2", "10.0.0.3"}},
+		{strategy: StrategyFirstFree, num: 2, want: []string{"10.0.0.2", "10.0.0.3"}},
+		{strategy: StrategyLastFree, num: 2, want: []string{"10.0.0.14", "10.0.0.13"}},
+		{strategy: StrategySkipFirst + ":5", num: 2, want: []string{"10.0.0.5", "10.0.0.6"}},
+		{strategy: StrategySparse, num: 1, want: []string{"10.0.0.8"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			i := New()
+			// network, gateway and broadcast address are acquired
+			newTestPrefix(t, i, prod, "10.0.0.0/28", "10.0.0.1", false, "")
+			ctx := NewContextWithNamespace(context.Background(), prod)
+			got, err := i.AcquireIP(ctx, "10.0.0.0/28", IPDetail{User: "a"}, tt.num, AcquireIPOptions{Strategy: tt.strategy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for n := range got {
+				if got[n] != tt.want[n] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAcquireIPRandomStrategy(t *testing.T) {
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/28", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	if err := i.EditPrefixStrategy(ctx, "10.0.0.0/28", StrategyRandom); err != nil {
+		t.Fatal(err)
+	}
+	// the strategy of the prefix hands out all free addresses once
+	seen := make(map[string]bool)
+	for n := 0; n < 13; n++ {
+		ips, err := i.AcquireIP(ctx, "10.0.0.0/28", IPDetail{User: "a"}, 1, AcquireIPOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := netip.MustParseAddr(ips[0])
+		if !netip.MustParsePrefix("10.0.0.0/28").Contains(ip) || ips[0] == "10.0.0.0" || ips[0] == "10.0.0.1" || ips[0] == "10.0.0.15" {
+			t.Fatalf("got %s which is not free", ip)
+		}
+		if seen[ips[0]] {
+			t.Fatalf("got %s twice", ip)
+		}
+		seen[ips[0]] = true
+	}
+	if _, err := i.AcquireIP(ctx, "10.0.0.0/28", IPDetail{User: "a"}, 1, AcquireIPOptions{}); err == nil {
+		t.Fatal("expected no free ip to be left")
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, spec := range []string{"", StrategyFirstFree, StrategyLastFree, StrategyRandom, StrategySparse, "skipfirst:10"} {
+		if _, err := ParseStrategy(spec); err != nil {
+			t.Errorf("ParseStrategy(%q): %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"bogus", StrategySkipFirst, "skipfirst:-1", "skipfirst:x"} {
+		if _, err := ParseStrategy(spec); err == nil {
+			t.Errorf("ParseStrategy(%q) did not fail", spec)
+		}
+	}
+
+	i := New()
+	newTestPrefix(t, i, prod, "10.0.0.0/28", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	if err := i.EditPrefixStrategy(ctx, "10.0.0.0/28", "bogus"); err == nil {
+		t.Error("expected an unknown strategy to be refused")
+	}
+	if _, err := i.AcquireIP(ctx, "10.0.0.0/28", IPDetail{}, 1, AcquireIPOptions{Strategy: "bogus"}); err == nil {
+		t.Error("expected an unknown strategy to be refused")
+	}
+}
@@ -0,0 +1,191 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"strings"
+
+	"go4.org/netipx"
+)
+
+// maxTemplateAcquire is the largest number of addresses a single acquiring TemplateRule may cover.
+const maxTemplateAcquire = 4096
+
+// Template is a named layout of reserved addresses which can be applied to prefixes,
+// e.g. "server-vlan": .1-.3 for HSRP/VRRP, .4-.10 for switches and the last 5 for OOB.
+type Template struct {
+	Name        string         `json:"name"`        //模板名称
+	Description string         `json:"description"` //描述
+	Rules       []TemplateRule `json:"rules"`       //保留规则
+}
+
+// TemplateRule reserves the addresses From to To of a prefix, both given as offsets from its network address.
+// Negative offsets count from the end of the prefix, -1 is the last address.
+type TemplateRule struct {
+	Name        string `json:"name"`        //名称
+	From        int64  `json:"from"`        //起始偏移
+	To          int64  `json:"to"`          //结束偏移
+	Description string `json:"description"` //描述
+	// Acquire marks the addresses as acquired instead of adding a ReservedRange with the name of the rule.
+	Acquire bool `json:"acquire"` //直接占用地址,否则作为保留地址段
+}
+
+// Validate checks the rules of the template independent of a prefix.
+func (t Template) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("template name must not be empty")
+	}
+	names := map[string]bool{}
+	for _, r := range t.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("rule name of template %s must not be empty", t.Name)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("rule %s of template %s exists already", r.Name, t.Name)
+		}
+		names[r.Name] = true
+		if (r.From < 0) != (r.To < 0) || r.From > r.To {
+			return fmt.Errorf("rule %s of template %s: from %d and to %d do not form a range", r.Name, t.Name, r.From, r.To)
+		}
+	}
+	return nil
+}
+
+// ipRange resolves the offsets of the rule inside ipnet.
+func (r TemplateRule) ipRange(ipnet netip.Prefix) (netipx.IPRange, error) {
+	size := prefixSize(ipnet)
+	offset := func(o int64) (netip.Addr, error) {
+		n := big.NewInt(o)
+		if o < 0 {
+			n.Add(n, size)
+		}
+		if n.Sign() < 0 || n.Cmp(size) >= 0 {
+			return netip.Addr{}, fmt.Errorf("offset %d of rule %s is outside of %s", o, r.Name, ipnet)
+		}
+		return addrAt(ipnet, n), nil
+	}
+	from, err := offset(r.From)
+	if err != nil {
+		return netipx.IPRange{}, err
+	}
+	to, err := offset(r.To)
+	if err != nil {
+		return netipx.IPRange{}, err
+	}
+	return netipx.IPRangeFrom(from, to), nil
+}
+
+// CheckTemplate returns an error if template t can not be applied to a prefix with the given cidr.
+func CheckTemplate(t Template, cidr string) error {
+	ipnet, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return fmt.Errorf("unable to parse cidr:%s %w", cidr, err)
+	}
+	p := &Prefix{Cidr: ipnet.Masked().String()}
+	return p.applyTemplate(t)
+}
+
+// applyTemplate adds the ranges and acquires the addresses of the rules of t.
+// Addresses reserved on creation of the prefix are kept, any other acquired address covered
+// by a rule is a conflict.
+func (p *Prefix) applyTemplate(t Template) error {
+	err := t.Validate()
+	if err != nil {
+		return err
+	}
+	ipnet, err := netip.ParsePrefix(p.Cidr)
+	if err != nil {
+		return err
+	}
+	var conflicts []string
+	for _, rule := range t.Rules {
+		ipr, err := rule.ipRange(ipnet)
+		if err != nil {
+			return err
+		}
+		var b netipx.IPSetBuilder
+		b.AddRange(ipr)
+		b.Intersect(p.allocatedSet())
+		acquired, _ := b.IPSet()
+		for _, ar := range acquired.Ranges() {
+			for ip := ar.From(); ar.Contains(ip); ip = ip.Next() {
+				if detail, ok := p.Ips[ip.String()]; ok && isReservation(detail) {
+					continue
+				}
+				conflicts = append(conflicts, ip.String())
+				if len(conflicts) > 10 {
+					break
+				}
+			}
+		}
+		if rule.Acquire {
+			if rangeSize(ipr).Cmp(big.NewInt(maxTemplateAcquire)) > 0 {
+				return fmt.Errorf("rule %s of template %s acquires more than %d addresses", rule.Name, t.Name, maxTemplateAcquire)
+			}
+			var ips []netip.Addr
+			for ip := ipr.From(); ipr.Contains(ip); ip = ip.Next() {
+				if !p.allocatedSet().Contains(ip) {
+					ips = append(ips, ip)
+				}
+			}
+			description := rule.Description
+			if description == "" {
+				description = rule.Name
+			}
+			p.acquire(reservation(description), ips...)
+			continue
+		}
+		r := ReservedRange{Name: rule.Name, From: ipr.From().String(), To: ipr.To().String(), Description: rule.Description}
+		err = p.addRange(r)
+		if err != nil {
+			return fmt.Errorf("template %s: %w", t.Name, err)
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w: template %s covers acquired addresses %s", ErrAlreadyAllocated, t.Name, strings.Join(conflicts, ","))
+	}
+	p.Template = t.Name
+	return nil
+}
+
+func (i *ipamer) ApplyTemplate(ctx context.Context, prefixCidr string, t Template) error {
+	return retryOnOptimisticLock(func() error {
+		return i.transaction(ctx, func(ctx context.Context, tx *ipamer) error {
+			return tx.applyTemplateInternal(ctx, prefixCidr, t)
+		})
+	})
+}
+
+func (i *ipamer) applyTemplateInternal(ctx context.Context, prefixCidr string, t Template) error {
+	prefix := i.PrefixFrom(ctx, prefixCidr)
+	if prefix == nil {
+		return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, prefixCidr)
+	}
+	if prefix.IsParent {
+		return fmt.Errorf("prefix %s has childprefixes, apply template not possible", prefix.Cidr)
+	}
+	before := prefix.deepCopy()
+	err := prefix.applyTemplate(t)
+	if err != nil {
+		return err
+	}
+	var acquired []string
+	for ip := range prefix.Ips {
+		if _, ok := before.Ips[ip]; !ok {
+			acquired = append(acquired, ip)
+		}
+	}
+	if s, ok := i.storage.(IPStorage); ok {
+		err = s.CreateIPs(ctx, *prefix, acquired)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = i.storage.UpdatePrefix(ctx, *prefix)
+	if err != nil {
+		return fmt.Errorf("unable to apply template %s to prefix:%s error:%w", t.Name, prefixCidr, err)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package ipam
+
+import "context"
+
+// transaction runs fn with an ipamer on a transaction of the storage, the prefixes read and written
+// through tx are changed atomically. fn must not use i itself. Called inside a transaction fn joins it.
+func (i *ipamer) transaction(ctx context.Context, fn func(ctx context.Context, tx *ipamer) error) error {
+	if i.tx {
+		return fn(ctx, i)
+	}
+	return i.storage.RunInTransaction(ctx, func(ctx context.Context, s Storage) error {
+		return fn(ctx, &ipamer{storage: s, tx: true})
+	})
+}
@@ -0,0 +1,110 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var errInjected = errors.New("injected failure")
+
+// failingStorage fails to create the prefixes for which fail returns true, inside transactions too.
+type failingStorage struct {
+	Storage
+	fail func(p Prefix) bool
+}
+
+func (f failingStorage) CreatePrefix(ctx context.Context, p Prefix) (Prefix, error) {
+	if f.fail(p) {
+		return Prefix{}, errInjected
+	}
+	return f.Storage.CreatePrefix(ctx, p)
+}
+
+func (f failingStorage) RunInTransaction(ctx context.Context, fn func(ctx context.Context, tx Storage) error) error {
+	return f.Storage.RunInTransaction(ctx, func(ctx context.Context, tx Storage) error {
+		return fn(ctx, failingStorage{Storage: tx, fail: f.fail})
+	})
+}
+
+func TestMemoryRunInTransaction(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+	err := m.RunInTransaction(ctx, func(ctx context.Context, tx Storage) error {
+		if _, err := tx.CreatePrefix(ctx, Prefix{Cidr: "10.0.0.0/24", IDC: prod.IDC, VRF: prod.VRF}); err != nil {
+			return err
+		}
+		return errInjected
+	})
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("got %v, want %v", err, errInjected)
+	}
+	if ps, _ := m.ReadAllPrefixes(ctx); len(ps) != 0 {
+		t.Fatalf("got %d prefixes after a failed transaction, want 0", len(ps))
+	}
+
+	err = m.RunInTransaction(ctx, func(ctx context.Context, tx Storage) error {
+		_, err := tx.CreatePrefix(ctx, Prefix{Cidr: "10.0.0.0/24", IDC: prod.IDC, VRF: prod.VRF})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps, _ := m.ReadAllPrefixes(ctx); len(ps) != 1 {
+		t.Fatalf("got %d prefixes after a transaction, want 1", len(ps))
+	}
+}
+
+func TestSplitPrefixRollback(t *testing.T) {
+	s := failingStorage{Storage: NewMemory(), fail: func(p Prefix) bool { return p.Cidr == "10.0.0.128/25" }}
+	i := NewWithStorage(s)
+	newTestPrefix(t, i, prod, "10.0.0.0/24", "10.0.0.1", false, "")
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	ips, err := i.AcquireIP(ctx, "10.0.0.0/24", IPDetail{User: "a"}, 3, AcquireIPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second piece can not be created, the first one must not be kept either
+	if _, err := i.SplitPrefix(ctx, "10.0.0.0/24", 25); !errors.Is(err, errInjected) {
+		t.Fatalf("got %v, want %v", err, errInjected)
+	}
+	cidrs, err := i.ReadAllPrefixCidrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cidrs) != 1 || cidrs[0] != "10.0.0.0/24" {
+		t.Fatalf("got prefixes %v after the failed split, want [10.0.0.0/24]", cidrs)
+	}
+	p := i.PrefixFrom(ctx, "10.0.0.0/24")
+	for _, ip := range ips {
+		if _, ok := p.Ips[ip]; !ok {
+			t.Errorf("ip %s lost by the failed split", ip)
+		}
+	}
+}
+
+func TestNewPrefixWithOptionsRollback(t *testing.T) {
+	i := New()
+	ctx := NewContextWithNamespace(context.Background(), prod)
+	opts := PrefixOptions{
+		Tags: []string{"web"},
+		// the rule is outside of the prefix, the template can not be applied
+		Template: &Template{Name: "bad", Rules: []TemplateRule{{Name: "x", From: 500, To: 600}}},
+	}
+	if _, err := i.NewPrefixWithOptions(ctx, "10.0.0.0/24", "10.0.0.1", "", 1, prod.VRF, prod.IDC, false, "", opts); err == nil {
+		t.Fatal("expected the template to be refused")
+	}
+	if i.PrefixFrom(ctx, "10.0.0.0/24") != nil {
+		t.Fatal("prefix kept although its template was refused")
+	}
+
+	opts.Template = &Template{Name: "ok", Rules: []TemplateRule{{Name: "x", From: 2, To: 9}}}
+	p, err := i.NewPrefixWithOptions(ctx, "10.0.0.0/24", "10.0.0.1", "", 1, prod.VRF, prod.IDC, false, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Tags) != 1 || len(p.Ranges) == 0 {
+		t.Errorf("got tags %v and ranges %v, want the tags and ranges of opts", p.Tags, p.Ranges)
+	}
+}
@@ -0,0 +1,242 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"sort"
+)
+
+// PrefixNode is a Prefix with the prefixes directly enclosed by it.
+type PrefixNode struct {
+	Prefix   Prefix
+	Children []*PrefixNode
+}
+
+// namespacePrefixes returns all prefixes of namespace.
+func (i *ipamer) namespacePrefixes(ctx context.Context, namespace Namespace) (Prefixes, error) {
+	all, err := i.storage.ReadAllPrefixes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ps Prefixes
+	for _, p := range all {
+		if p.Namespace() == namespace {
+			ps = append(ps, p)
+		}
+	}
+	return ps, nil
+}
+
+// enclosing returns the nearest prefix of existing which encloses p and the prefixes of existing
+// which p encloses directly, i.e. without another enclosed prefix in between.
+func enclosing(p *Prefix, existing Prefixes) (*Prefix, []Prefix, error) {
+	np, err := netip.ParsePrefix(p.Cidr)
+	if err != nil {
+		return nil, nil, err
+	}
+	var parent *Prefix
+	var parentBits int
+	var enclosed []netip.Prefix
+	byCidr := map[netip.Prefix]Prefix{}
+	for k := range existing {
+		ep, err := netip.ParsePrefix(existing[k].Cidr)
+		if err != nil {
+			continue
+		}
+		switch {
+		case ep == np:
+			return nil, nil, fmt.Errorf("prefix %s exists already", p.Cidr)
+		case ep.Bits() < np.Bits() && ep.Contains(np.Addr()):
+			if parent == nil || ep.Bits() > parentBits {
+				parent, parentBits = &existing[k], ep.Bits()
+			}
+		case np.Bits() < ep.Bits() && np.Contains(ep.Addr()):
+			enclosed = append(enclosed, ep)
+			byCidr[ep] = existing[k]
+		}
+	}
+	var children []Prefix
+	for _, e := range enclosed {
+		direct := true
+		for _, o := range enclosed {
+			if o.Bits() < e.Bits() && o.Contains(e.Addr()) {
+				direct = false
+				break
+			}
+		}
+		if direct {
+			children = append(children, byCidr[e])
+		}
+	}
+	return parent, children, nil
+}
+
+// canHoldChildren returns an error if p can not become the parent of child.
+func canHoldChildren(p *Prefix, child string) error {
+	switch p.Kind {
+	case KindPointToPoint, KindHost, KindLoopbackPool:
+		return fmt.Errorf("%s prefix %s can not hold child prefix %s", p.Kind, p.Cidr, child)
+	}
+	if p.hasIPs() {
+		return fmt.Errorf("prefix %s has ips, child prefix %s not possible", p.Cidr, child)
+	}
+	return nil
+}
+
+// linkPrefix registers the new prefix p as child of parent and as parent of children,
+// p must be created already.
+func (i *ipamer) linkPrefix(ctx context.Context, p *Prefix, parent *Prefix, children []Prefix) error {
+	if parent != nil {
+		err := i.modifyPrefix(ctx, parent.Cidr, func(parent *Prefix) error {
+			if parent.availableChildPrefixes == nil {
+				parent.availableChildPrefixes = make(map[string]bool)
+			}
+			for _, c := range children {
+				delete(parent.availableChildPrefixes, c.Cidr)
+			}
+			parent.availableChildPrefixes[p.Cidr] = false
+			parent.IsParent = true
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("unable to link prefix %s to parent %s: %w", p.Cidr, parent.Cidr, err)
+		}
+	}
+	for _, c := range children {
+		err := i.modifyPrefix(ctx, c.Cidr, func(child *Prefix) error {
+			child.ParentCidr = p.Cidr
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("unable to link prefix %s to parent %s: %w", c.Cidr, p.Cidr, err)
+		}
+	}
+	return nil
+}
+
+// unlinkPrefix removes p from the child prefixes of its parent.
+func (i *ipamer) unlinkPrefix(ctx context.Context, p *Prefix) error {
+	if p.ParentCidr == "" {
+		return nil
+	}
+	parent := i.PrefixFrom(ctx, p.ParentCidr)
+	if parent == nil {
+		return nil
+	}
+	if _, ok := parent.availableChildPrefixes[p.Cidr]; !ok {
+		return nil
+	}
+	return i.modifyPrefix(ctx, p.ParentCidr, func(parent *Prefix) error {
+		delete(parent.availableChildPrefixes, p.Cidr)
+		return nil
+	})
+}
+
+// hasChildPrefixes returns true if child prefixes were acquired from or created inside p.
+func (p *Prefix) hasChildPrefixes() bool {
+	for _, available := range p.availableChildPrefixes {
+		if !available {
+			return true
+		}
+	}
+	return false
+}
+
+// PrefixTree returns the prefixes of the namespace of ctx, each prefix below the nearest prefix enclosing it.
+func (i *ipamer) PrefixTree(ctx context.Context) ([]*PrefixNode, error) {
+	ps, err := i.namespacePrefixes(ctx, NamespaceFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	type entry struct {
+		ipnet netip.Prefix
+		node  *PrefixNode
+	}
+	var entries []entry
+	for _, p := range ps {
+		ipnet, err := netip.ParsePrefix(p.Cidr)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{ipnet, &PrefixNode{Prefix: p}})
+	}
+	// shorter prefixes first, so the parent of every prefix is placed before it.
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].ipnet.Bits() != entries[b].ipnet.Bits() {
+			return entries[a].ipnet.Bits() < entries[b].ipnet.Bits()
+		}
+		return entries[a].ipnet.Addr().Less(entries[b].ipnet.Addr())
+	})
+	var roots []*PrefixNode
+	for k, e := range entries {
+		var parent *PrefixNode
+		for j := k - 1; j >= 0; j-- {
+			if entries[j].ipnet.Bits() < e.ipnet.Bits() && entries[j].ipnet.Contains(e.ipnet.Addr()) {
+				parent = entries[j].node
+				break
+			}
+		}
+		if parent == nil {
+			roots = append(roots, e.node)
+			continue
+		}
+		parent.Children = append(parent.Children, e.node)
+	}
+	return roots, nil
+}
+
+// childGateway returns the gateway of the child prefix ipnet acquired from parent. The gateway
+// keeps the distance of the gateway of parent from the start or, if closer, the end of the prefix,
+// e.g. the last usable address of a parent makes the last usable address of every child the gateway.
+// Without gateway of parent or if the distance does not fit, the first address after the network
+// address is used.
+func childGateway(parent *Prefix, ipnet netip.Prefix) netip.Addr {
+	ipnet = ipnet.Masked()
+	first := ipnet.Addr()
+	if ipnet.Bits() < ipnet.Addr().BitLen()-1 {
+		first = first.Next()
+	}
+	parentnet, err := netip.ParsePrefix(parent.Cidr)
+	if err != nil {
+		return first
+	}
+	gw, err := netip.ParseAddr(parent.Gateway)
+	if err != nil || !parentnet.Contains(gw) {
+		return first
+	}
+	offset := addrOffset(parentnet, gw)
+	fromEnd := new(big.Int).Sub(prefixSize(parentnet), offset)
+	fromEnd.Sub(fromEnd, big.NewInt(1))
+	size := prefixSize(ipnet)
+	if fromEnd.Cmp(offset) < 0 {
+		offset = new(big.Int).Sub(size, fromEnd)
+		offset.Sub(offset, big.NewInt(1))
+	}
+	if offset.Sign() <= 0 || offset.Cmp(size) >= 0 {
+		return first
+	}
+	return addrAt(ipnet, offset)
+}
+
+// modifyPrefix reads the prefix cidr from the namespace of ctx, applies modify and writes it back,
+// it is retried if the prefix was changed in the meantime.
+func (i *ipamer) modifyPrefix(ctx context.Context, cidr string, modify func(p *Prefix) error) error {
+	return retryOnOptimisticLock(func() error {
+		p := i.PrefixFrom(ctx, cidr)
+		if p == nil {
+			return fmt.Errorf("%w: unable to find prefix for cidr:%s", ErrNotFound, cidr)
+		}
+		err := modify(p)
+		if err != nil {
+			return err
+		}
+		_, err = i.storage.UpdatePrefix(ctx, *p)
+		if err != nil && !errors.Is(err, ErrOptimisticLockError) {
+			return fmt.Errorf("unable to update prefix:%s error:%w", cidr, err)
+		}
+		return err
+	})
+}
@@ -0,0 +1,142 @@
+package template
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	goipam "ipam/pkg/ipam"
+	"ipam/utils/logging"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongo 连接
+type mongodb struct {
+	c    *mongo.Collection
+	lock sync.RWMutex
+}
+
+var cli *mongodb
+
+// 网段模板
+type Template struct {
+	goipam.Template `bson:",inline"`
+	Operator        string `bson:"operator" json:"operator"` //操作员
+	Date            string `bson:"date" json:"date"`         //创建时间
+}
+
+func (t *Template) CreateTemplate() error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	filter := bson.M{"name": t.Name}
+	var tOld Template
+	cli.lock.Lock()
+	cli.c.FindOne(ctx, filter).Decode(&tOld)
+	cli.lock.Unlock()
+	if tOld.Name == t.Name {
+		return errors.New("模板重名")
+	}
+	cli.lock.Lock()
+	_, cErr := cli.c.InsertOne(ctx, &t)
+	cli.lock.Unlock()
+	if cErr != nil {
+		logging.Error("insert mongo error:", cErr)
+		return errors.New("数据录入数据库失败")
+	}
+	return nil
+}
+
+func (t *Template) DeleteTemplate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	filter := bson.M{"name": t.Name}
+	cli.lock.Lock()
+	_, cErr := cli.c.DeleteOne(ctx, filter)
+	cli.lock.Unlock()
+	if cErr != nil {
+		logging.Error("delete mongo error:", cErr)
+		return errors.New("删除数据失败")
+	}
+	return nil
+}
+
+// 按名称读取模板
+func (t *Template) GetTemplate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	filter := bson.M{"name": t.Name}
+	cli.lock.RLock()
+	err := cli.c.FindOne(ctx, filter).Decode(t)
+	cli.lock.RUnlock()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("模板%s不存在", t.Name)
+	}
+	if err != nil {
+		logging.Error("获取数据失败", err)
+		return err
+	}
+	return nil
+}
+
+func (t *Template) TemplateList() ([]Template, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	cur, cErr := cli.c.Find(ctx, bson.D{})
+	if cErr != nil {
+		logging.Error("获取数据失败", cErr)
+		return nil, cErr
+	}
+	defer cur.Close(ctx)
+	var templates []Template
+	if err := cur.All(ctx, &templates); err != nil {
+		logging.Error("获取数据失败", err)
+		return nil, err
+	}
+	return templates, nil
+}
+
+func init() {
+	ctx := context.Background()
+	opts := options.Client()
+	opts.ApplyURI(fmt.Sprintf(`mongodb://%s:%s`, "192.168.152.92", "27017"))
+	opts.Auth = &options.Credential{
+		AuthMechanism: `SCRAM-SHA-1`,
+		Username:      `ipam`,
+		Password:      `123456`,
+	}
+
+	conf := goipam.MongoConfig{
+		DatabaseName:       `ipam`,
+		CollectionName:     `template`,
+		MongoClientOptions: opts,
+	}
+	cli, _ = newMongo(ctx, conf)
+}
+
+func newMongo(ctx context.Context, config goipam.MongoConfig) (*mongodb, error) {
+	m, err := mongo.NewClient(config.MongoClientOptions)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Ping(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := m.Database(config.DatabaseName).Collection(config.CollectionName)
+	return &mongodb{c, sync.RWMutex{}}, nil
+}
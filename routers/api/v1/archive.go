@@ -0,0 +1,157 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ipam/pkg/audit"
+	goipam "ipam/pkg/ipam"
+	"ipam/utils/logging"
+	conf "ipam/utils/options"
+	"ipam/utils/tools"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 查看回收站中删除的网段
+type ArchivedPrefixesReq struct {
+	IDC string `json:"idc"` //IDC,为空时不过滤
+	VRF string `json:"vrf"` //VRF,为空时不过滤
+}
+
+type ArchivedPrefixesRes struct {
+	Prefixes []goipam.ArchivedPrefix `json:"prefixes"` //最近删除的在前
+}
+
+func (*InstanceResource) ArchivedPrefixes(c *gin.Context) {
+	method := "ArchivedPrefixes"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req ArchivedPrefixesReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		archived, err := ipam.ArchivedPrefixes(ctx)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		res := ArchivedPrefixesRes{Prefixes: []goipam.ArchivedPrefix{}}
+		for _, a := range archived {
+			if (req.IDC == "" || a.Prefix.IDC == req.IDC) && (req.VRF == "" || a.Prefix.VRF == req.VRF) {
+				res.Prefixes = append(res.Prefixes, a)
+			}
+		}
+		resp.Render(c, 200, res, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 从回收站恢复删除的网段,之后创建了重叠的网段或者父网段变化时不能恢复,需要管理员权限
+type RestoreArchivedPrefixReq struct {
+	ID string `json:"id"` //回收站中网段的id
+}
+
+func (*InstanceResource) RestoreArchivedPrefix(c *gin.Context) {
+	method := "RestoreArchivedPrefix"
+	logging.Info("开始", method)
+	if _, ok := tools.AdminAuth(c); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req RestoreArchivedPrefixReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.ID == "" {
+			resp.Render(c, 200, nil, errors.New("id不能为空"))
+			return
+		}
+		// 网段的机房和VRF取自回收站
+		ctx, cancel := namespaceContext(c, "", "")
+		defer cancel()
+		p, err := ipam.RestoreArchivedPrefix(ctx, req.ID)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, GetPrefixRes{*p, usageInfo(p.Usage())}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 清理回收站中在某个时间之前删除的网段,清理后不能恢复,需要管理员权限
+type PurgeArchivedPrefixesReq struct {
+	Before string `json:"before"` //时间,格式2006-01-02 15:04:05
+}
+
+type PurgeArchivedPrefixesRes struct {
+	Prefixes []goipam.ArchivedPrefix `json:"prefixes"` //清理的网段
+}
+
+func (*InstanceResource) PurgeArchivedPrefixes(c *gin.Context) {
+	method := "PurgeArchivedPrefixes"
+	logging.Info("开始", method)
+	if _, ok := tools.AdminAuth(c); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req PurgeArchivedPrefixesReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Before == "" {
+			resp.Render(c, 200, nil, errors.New("时间不能为空"))
+			return
+		}
+		before, err := parseTime(req.Before)
+		if err != nil {
+			resp.Render(c, 200, nil, errors.New("时间格式错误"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, "", "")
+		defer cancel()
+		purged, err := ipam.PurgeArchivedPrefixes(ctx, before)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		if purged == nil {
+			purged = []goipam.ArchivedPrefix{}
+		}
+		resp.Render(c, 200, PurgeArchivedPrefixesRes{purged}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 启动回收站清理,保留天数或间隔为0时不清理
+func StartPurger(c conf.Archive) {
+	if c.Retention <= 0 || c.Interval <= 0 {
+		return
+	}
+	retention := time.Duration(c.Retention) * 24 * time.Hour
+	go func() {
+		ctx := audit.NewContextWithOperator(context.Background(), "purger")
+		ticker := time.NewTicker(time.Duration(c.Interval) * time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			purged, err := ipam.PurgeArchivedPrefixes(ctx, now.Add(-retention))
+			if err != nil {
+				logging.Error("清理回收站失败:", err)
+				continue
+			}
+			for _, a := range purged {
+				logging.Info("清理回收站", a.Prefix.IDC, a.Prefix.VRF, a.Prefix.Cidr)
+			}
+		}
+	}()
+}
@@ -0,0 +1,28 @@
+package v1
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestArchiveNeedsAdmin(t *testing.T) {
+	handlers := map[string]struct {
+		handler gin.HandlerFunc
+		body    string
+	}{
+		"RestoreArchivedPrefix": {handler: (&InstanceResource{}).RestoreArchivedPrefix, body: `{"id":"unknown"}`},
+		"PurgeArchivedPrefixes": {handler: (&InstanceResource{}).PurgeArchivedPrefixes, body: `{"before":"2000-01-01 00:00:00"}`},
+	}
+	for method, h := range handlers {
+		for _, roles := range [][]string{{modelIPAM}, {method}} {
+			if w := serve(h.handler, h.body, roles...); w.Code != http.StatusForbidden {
+				t.Errorf("got status %d for %s with roles %v, want %d", w.Code, method, roles, http.StatusForbidden)
+			}
+		}
+		if w := serve(h.handler, h.body, "admin"); w.Code != http.StatusOK {
+			t.Errorf("got status %d for %s as admin, want %d", w.Code, method, http.StatusOK)
+		}
+	}
+}
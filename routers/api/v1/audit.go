@@ -0,0 +1,251 @@
+package v1
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"ipam/component"
+	"ipam/pkg/audit"
+	"ipam/pkg/idc"
+	"ipam/pkg/note"
+	"ipam/utils/logging"
+	"ipam/utils/tools"
+
+	"github.com/gin-gonic/gin"
+)
+
+const modelAUDIT string = "AUDIT"
+
+// 审计日志中代替路由器密码
+const redacted = "******"
+
+// 计算路由器密码指纹的盐,每次启动随机生成,指纹不能用于还原密码,只能比较同一进程记录的前后快照
+var passwordSalt = func() []byte {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return b
+}()
+
+// 路由器密码在审计日志中的表示,密码修改后指纹不同
+func passwordFingerprint(password string) string {
+	h := hmac.New(sha256.New, passwordSalt)
+	h.Write([]byte(password))
+	return redacted + hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// 审计日志,记录网段,地址,机房和备注的每次变更
+var auditLog audit.Store
+
+// 记录审计日志的ipam,用于撤销操作
+var auditIpam *audit.Ipamer
+
+type AUDITResource struct {
+}
+
+// 注册路由
+func AUDITRouter() {
+	APIs["/audit"] = map[UriInterface]interface{}{
+		NewUri("POST", "/Query"): (&AUDITResource{}).Query,
+		NewUri("GET", "/Export"): (&AUDITResource{}).Export,
+		NewUri("POST", "/Undo"):  (&AUDITResource{}).Undo,
+	}
+}
+
+// 查询审计日志,条件为空时不过滤
+type AuditQueryReq struct {
+	Cidr  string `json:"cidr"`
+	IP    string `json:"ip"`
+	User  string `json:"user"`  //操作员或地址使用人
+	From  string `json:"from"`  //开始时间,格式2006-01-02 15:04:05
+	To    string `json:"to"`    //结束时间,不包含
+	Limit int    `json:"limit"` //最多返回多少条,最新的在前,默认100
+}
+
+type AuditQueryRes struct {
+	Entries []audit.Entry `json:"entries"`
+}
+
+func (*AUDITResource) Query(c *gin.Context) {
+	method := "AuditQuery"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelAUDIT, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req AuditQueryReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		q := audit.Query{Cidr: req.Cidr, IP: req.IP, User: req.User, Limit: req.Limit}
+		if q.Limit <= 0 {
+			q.Limit = 100
+		}
+		var err error
+		if q.From, err = parseTime(req.From); err != nil {
+			resp.Render(c, 200, nil, errors.New("开始时间格式错误"))
+			return
+		}
+		if q.To, err = parseTime(req.To); err != nil {
+			resp.Render(c, 200, nil, errors.New("结束时间格式错误"))
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		entries, err := auditLog.Query(ctx, q)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, errors.New("查询审计日志失败"))
+			return
+		}
+		resp.Render(c, 200, AuditQueryRes{entries}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 导出审计日志的哈希链,每行一条JSON,可以用auditverify离线校验
+func (*AUDITResource) Export(c *gin.Context) {
+	method := "AuditExport"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelAUDIT, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	// 导出时间可能超过服务的写超时
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(30 * time.Minute)); err != nil {
+		logging.Error(err)
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	c.Status(200)
+	enc := json.NewEncoder(c.Writer)
+	n := 0
+	err := auditLog.Walk(c.Request.Context(), func(e audit.Entry) error {
+		n++
+		if n%1000 == 0 {
+			c.Writer.Flush()
+		}
+		return enc.Encode(e)
+	})
+	if err != nil {
+		// 已经开始输出,只能记录错误,导出的最后一条不是最新的记录
+		logging.Error("导出审计日志失败", err)
+	}
+	c.Writer.Flush()
+}
+
+// 撤销一条审计日志记录的操作,支持ReleaseIP,ReleaseIPFromPrefix和DeletePrefix
+type UndoReq struct {
+	ID     string `json:"id"`     //审计日志的id
+	DryRun bool   `json:"dryrun"` //只预览要恢复的地址或网段,不执行
+}
+
+type UndoRes struct {
+	Plan *audit.UndoPlan `json:"plan"` //要恢复的地址或网段,以及之后有冲突的变更
+}
+
+func (*AUDITResource) Undo(c *gin.Context) {
+	method := "AuditUndo"
+	logging.Info("开始", method)
+	// 撤销会恢复删除的网段和释放的地址,只有管理员可以执行,审计日志的查看权限不够
+	if _, ok := tools.AdminAuth(c); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req UndoReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.ID == "" {
+			resp.Render(c, 200, nil, errors.New("id不能为空"))
+			return
+		}
+		ctx, cancel := context.WithTimeout(audit.NewContextWithOperator(context.Background(), operator(c)), 10*time.Second)
+		defer cancel()
+		plan, err := auditIpam.Undo(ctx, req.ID, req.DryRun)
+		if err != nil {
+			logging.Error(err)
+			// 有冲突时返回冲突的变更
+			if plan != nil {
+				resp.Render(c, 200, UndoRes{plan}, err)
+				return
+			}
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, UndoRes{plan}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 解析本地时间,为空时返回零值
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+}
+
+// 当前登录用户,c为空或没有登录信息时返回空
+func operator(c *gin.Context) string {
+	if c == nil {
+		return ""
+	}
+	claims, ok := c.Get("claims")
+	if !ok {
+		return ""
+	}
+	if user, ok := claims.(*component.CustomClaims); ok {
+		return user.Name
+	}
+	return ""
+}
+
+// 记录机房和备注的变更,before和after为变更前后的对象
+func recordAudit(c *gin.Context, operation, idcName string, before, after interface{}, err error) {
+	ctx, cancel := context.WithTimeout(audit.NewContextWithOperator(context.Background(), operator(c)), 5*time.Second)
+	defer cancel()
+	e := audit.Entry{Operation: operation, IDC: idcName}
+	if rerr := audit.Record(ctx, auditLog, e, before, after, err); rerr != nil {
+		logging.Error("记录审计日志失败", operation, rerr)
+	}
+}
+
+// 机房当前的信息,路由器密码被替换为加盐的指纹,机房不存在时返回nil
+func idcSnapshot(name string) *idc.IDC {
+	for _, i := range idc.GetIDC() {
+		if i.IDCName == name {
+			s := i
+			s.VRF = append([]string(nil), i.VRF...)
+			s.Router = append([]idc.Router(nil), i.Router...)
+			for k := range s.Router {
+				if s.Router[k].Password != "" {
+					s.Router[k].Password = passwordFingerprint(s.Router[k].Password)
+				}
+			}
+			return &s
+		}
+	}
+	return nil
+}
+
+// 实例当前的备注,不存在时返回nil
+func noteSnapshot(instance string) *note.Note {
+	n := note.Note{}
+	notes, err := n.NoteList()
+	if err != nil {
+		return nil
+	}
+	for _, n := range notes {
+		if n.Instance == instance {
+			return &n
+		}
+	}
+	return nil
+}
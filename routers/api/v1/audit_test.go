@@ -0,0 +1,62 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"ipam/component"
+	"ipam/pkg/audit"
+	goipam "ipam/pkg/ipam"
+	"ipam/utils/logging"
+	conf "ipam/utils/options"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ipam-v1-test")
+	if err != nil {
+		panic(err)
+	}
+	conf.Conf = &conf.Config{Log: conf.Log{LogDir: dir + "/", LogFile: "ipam", LogFileExt: "log", TimeFormat: "200601"}}
+	logging.ConfigInit()
+	gin.SetMode(gin.TestMode)
+	auditLog = audit.NewMemory()
+	auditIpam = audit.NewIpamer(goipam.New(), auditLog)
+	ipam = auditIpam
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// serve calls handler with the JSON body as a user with the given roles and returns the response.
+func serve(handler gin.HandlerFunc, body string, roles ...string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("claims", &component.CustomClaims{Name: "alice", Role: roles})
+	handler(c)
+	return w
+}
+
+func TestUndoNeedsAdmin(t *testing.T) {
+	tests := []struct {
+		roles []string
+		want  int
+	}{
+		{roles: []string{modelAUDIT}, want: http.StatusForbidden},
+		{roles: []string{"AuditUndo"}, want: http.StatusForbidden},
+		{roles: []string{modelAUDIT, modelIPAM}, want: http.StatusForbidden},
+		{roles: []string{"admin"}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := serve((&AUDITResource{}).Undo, `{"id":"unknown","dryrun":true}`, tt.roles...)
+		if w.Code != tt.want {
+			t.Errorf("got status %d for roles %v, want %d", w.Code, tt.roles, tt.want)
+		}
+	}
+}
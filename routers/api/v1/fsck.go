@@ -0,0 +1,66 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"ipam/pkg/audit"
+	"ipam/utils/logging"
+	"ipam/utils/tools"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 网段一致性检查
+type CheckConsistencyReq struct {
+	Repair bool `json:"repair"` //修复能自动修复的问题
+}
+
+func (*InstanceResource) CheckConsistency(c *gin.Context) {
+	method := "CheckConsistency"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req CheckConsistencyReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		// 修复会删除地址和改写父子关系,需要管理员权限
+		if req.Repair {
+			if _, ok := tools.AdminAuth(c); !ok {
+				resp.Render(c, 403, nil, errors.New("没有权限访问"))
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(audit.NewContextWithOperator(context.Background(), operator(c)), time.Minute)
+		defer cancel()
+		report, err := ipam.CheckConsistency(ctx, req.Repair)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, report, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 命令行检查网段一致性,返回进程退出码:0 一致,1 有未修复的问题,2 检查失败
+func Fsck(repair bool) int {
+	report, err := ipam.CheckConsistency(audit.NewContextWithOperator(context.Background(), "fsck"), repair)
+	if err != nil {
+		logging.Error("一致性检查失败", err)
+		return 2
+	}
+	if err := report.WriteText(os.Stdout); err != nil {
+		return 2
+	}
+	if !report.Consistent() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+package v1
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCheckConsistencyRepairNeedsAdmin(t *testing.T) {
+	tests := []struct {
+		body  string
+		roles []string
+		want  int
+	}{
+		{body: `{"repair":false}`, roles: []string{modelIPAM}, want: http.StatusOK},
+		{body: `{"repair":true}`, roles: []string{modelIPAM}, want: http.StatusForbidden},
+		{body: `{"repair":true}`, roles: []string{"CheckConsistency"}, want: http.StatusForbidden},
+		{body: `{"repair":true}`, roles: []string{"admin"}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := serve((&InstanceResource{}).CheckConsistency, tt.body, tt.roles...)
+		if w.Code != tt.want {
+			t.Errorf("got status %d for %s with roles %v, want %d", w.Code, tt.body, tt.roles, tt.want)
+		}
+	}
+}
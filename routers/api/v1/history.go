@@ -0,0 +1,125 @@
+package v1
+
+import (
+	"errors"
+	"time"
+
+	goipam "ipam/pkg/ipam"
+	"ipam/utils/logging"
+	"ipam/utils/tools"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 查看网段在某个时间的状态
+type PrefixAtReq struct {
+	Cidr string `json:"cidr"`
+	IDC  string `json:"idc"` //IDC
+	VRF  string `json:"vrf"` //VRF
+	At   string `json:"at"`  //时间,格式2006-01-02 15:04:05
+}
+
+func (*InstanceResource) PrefixAt(c *gin.Context) {
+	method := "PrefixAt"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req PrefixAtReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" || req.At == "" {
+			resp.Render(c, 200, nil, errors.New("网段和时间不能为空"))
+			return
+		}
+		at, err := parseTime(req.At)
+		if err != nil {
+			resp.Render(c, 200, nil, errors.New("时间格式错误"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		p, err := ipam.PrefixAt(ctx, req.Cidr, at)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, GetPrefixRes{*p, usageInfo(p.Usage())}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 查看地址的分配历史
+type IPHistoryReq struct {
+	IP   string `json:"ip"`
+	IDC  string `json:"idc"`  //IDC
+	VRF  string `json:"vrf"`  //VRF
+	From string `json:"from"` //开始时间,格式2006-01-02 15:04:05,为空时不过滤
+	To   string `json:"to"`   //结束时间,不包含
+	At   string `json:"at"`   //查询这个时间的使用人,为空时不查询
+}
+
+type IPHistoryRes struct {
+	Events []goipam.IPEvent `json:"events"`
+	Holder *goipam.IPDetail `json:"holder"` //at时间地址的使用信息,地址空闲时为空
+}
+
+func (*InstanceResource) IPHistory(c *gin.Context) {
+	method := "IPHistory"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req IPHistoryReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.IP == "" {
+			resp.Render(c, 200, nil, errors.New("地址不能为空"))
+			return
+		}
+		var from, to, at time.Time
+		var err error
+		if from, err = parseTime(req.From); err != nil {
+			resp.Render(c, 200, nil, errors.New("开始时间格式错误"))
+			return
+		}
+		if to, err = parseTime(req.To); err != nil {
+			resp.Render(c, 200, nil, errors.New("结束时间格式错误"))
+			return
+		}
+		if at, err = parseTime(req.At); err != nil {
+			resp.Render(c, 200, nil, errors.New("查询时间格式错误"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		events, err := ipam.IPHistory(ctx, req.IP)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		res := IPHistoryRes{Events: []goipam.IPEvent{}}
+		for _, e := range events {
+			// 事件按时间排序,at之前的最后一个事件决定当时的使用人
+			if !at.IsZero() && !e.Time.After(at) {
+				res.Holder = nil
+				if e.Kind != goipam.IPReleased {
+					detail := e.Detail
+					res.Holder = &detail
+				}
+			}
+			if (!from.IsZero() && e.Time.Before(from)) || (!to.IsZero() && !e.Time.Before(to)) {
+				continue
+			}
+			res.Events = append(res.Events, e)
+		}
+		resp.Render(c, 200, res, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
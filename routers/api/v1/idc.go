@@ -71,7 +71,10 @@ func (*IDCResource) CreateIDC(c *gin.Context) {
 	var req Req
 	if c.ShouldBind(&req.IDC) == nil {
 		r := &req.IDC
-		if err := r.CreateIDC(); err != nil {
+		before := idcSnapshot(r.IDCName)
+		err := r.CreateIDC()
+		recordAudit(c, method, r.IDCName, before, idcSnapshot(r.IDCName), err)
+		if err != nil {
 			logging.Info("录入数据库失败", err)
 			resp.Render(c, 200, nil, err)
 			return
@@ -95,7 +98,10 @@ func (*IDCResource) DeleteIDC(c *gin.Context) {
 	if c.ShouldBind(&req.IDC) == nil {
 		logging.Debug(req)
 		r := &req.IDC
-		if err := r.DeleteIDC(); err != nil {
+		before := idcSnapshot(r.IDCName)
+		err := r.DeleteIDC()
+		recordAudit(c, method, r.IDCName, before, idcSnapshot(r.IDCName), err)
+		if err != nil {
 			logging.Info("录入数据库失败", err)
 			resp.Render(c, 200, nil, err)
 			return
@@ -118,7 +124,10 @@ func (*IDCResource) CreateVRF(c *gin.Context) {
 	if c.ShouldBind(&req.IDC) == nil {
 		logging.Debug(req)
 		r := &req.IDC
-		if err := r.CreateVRF(); err != nil {
+		before := idcSnapshot(r.IDCName)
+		err := r.CreateVRF()
+		recordAudit(c, method, r.IDCName, before, idcSnapshot(r.IDCName), err)
+		if err != nil {
 			logging.Info("录入数据库失败", err)
 			resp.Render(c, 200, nil, err)
 			return
@@ -141,7 +150,10 @@ func (*IDCResource) DeleteVRF(c *gin.Context) {
 	if c.ShouldBind(&req.IDC) == nil {
 		logging.Debug(req)
 		r := &req.IDC
-		if err := r.DeleteVRF(); err != nil {
+		before := idcSnapshot(r.IDCName)
+		err := r.DeleteVRF()
+		recordAudit(c, method, r.IDCName, before, idcSnapshot(r.IDCName), err)
+		if err != nil {
 			logging.Info("录入数据库失败", err)
 			resp.Render(c, 200, nil, err)
 			return
@@ -164,7 +176,10 @@ func (*IDCResource) CreateRouter(c *gin.Context) {
 	if c.ShouldBind(&req.IDC) == nil {
 		logging.Debug(req)
 		r := &req.IDC
-		if err := r.CreateRouter(); err != nil {
+		before := idcSnapshot(r.IDCName)
+		err := r.CreateRouter()
+		recordAudit(c, method, r.IDCName, before, idcSnapshot(r.IDCName), err)
+		if err != nil {
 			logging.Info("录入数据库失败", err)
 			resp.Render(c, 200, nil, err)
 			return
@@ -187,7 +202,10 @@ func (*IDCResource) DeleteRouter(c *gin.Context) {
 	if c.ShouldBind(&req.IDC) == nil {
 		logging.Debug(req)
 		r := &req.IDC
-		if err := r.DeleteRouter(); err != nil {
+		before := idcSnapshot(r.IDCName)
+		err := r.DeleteRouter()
+		recordAudit(c, method, r.IDCName, before, idcSnapshot(r.IDCName), err)
+		if err != nil {
 			logging.Info("录入数据库失败", err)
 			resp.Render(c, 200, nil, err)
 			return
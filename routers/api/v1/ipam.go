@@ -1,6 +1,7 @@
 package v1
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -14,8 +15,10 @@ import (
 	"strings"
 	"time"
 
+	"ipam/pkg/audit"
 	"ipam/pkg/idc"
 	goipam "ipam/pkg/ipam"
+	"ipam/pkg/template"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -36,7 +39,38 @@ func IPAMRouter() {
 		NewUri("POST", "/EditIPUserFromPrefix"):        (&InstanceResource{}).EditIPUserFromPrefix,
 		NewUri("POST", "/EditIPDescriptionFromPrefix"): (&InstanceResource{}).EditIPDescriptionFromPrefix,
 		NewUri("POST", "/DeletePrefix"):                (&InstanceResource{}).DeletePrefix,
+		NewUri("POST", "/EditPrefixStrategy"):          (&InstanceResource{}).EditPrefixStrategy,
+		NewUri("POST", "/AddReservedRange"):            (&InstanceResource{}).AddReservedRange,
+		NewUri("POST", "/DeleteReservedRange"):         (&InstanceResource{}).DeleteReservedRange,
 		NewUri("POST", "/GetIP"):                       (&InstanceResource{}).GetIP,
+		NewUri("POST", "/AcquireLoopback"):             (&InstanceResource{}).AcquireLoopback,
+		NewUri("POST", "/ReleaseLoopback"):             (&InstanceResource{}).ReleaseLoopback,
+		NewUri("POST", "/PrefixTree"):                  (&InstanceResource{}).PrefixTree,
+		NewUri("POST", "/AcquireChildPrefix"):          (&InstanceResource{}).AcquireChildPrefix,
+		NewUri("POST", "/AcquireSpecificChildPrefix"):  (&InstanceResource{}).AcquireSpecificChildPrefix,
+		NewUri("POST", "/ReleaseChildPrefix"):          (&InstanceResource{}).ReleaseChildPrefix,
+		NewUri("POST", "/AcquireChildPrefixFromPool"):  (&InstanceResource{}).AcquireChildPrefixFromPool,
+		NewUri("POST", "/EditPrefixTags"):              (&InstanceResource{}).EditPrefixTags,
+		NewUri("POST", "/SplitPrefix"):                 (&InstanceResource{}).SplitPrefix,
+		NewUri("POST", "/MergePrefixes"):               (&InstanceResource{}).MergePrefixes,
+		NewUri("POST", "/ResizePrefix"):                (&InstanceResource{}).ResizePrefix,
+		NewUri("POST", "/PlanRenumber"):                (&InstanceResource{}).PlanRenumber,
+		NewUri("POST", "/ApplyRenumber"):               (&InstanceResource{}).ApplyRenumber,
+		NewUri("POST", "/RetireRenumber"):              (&InstanceResource{}).RetireRenumber,
+		NewUri("POST", "/CancelRenumber"):              (&InstanceResource{}).CancelRenumber,
+		NewUri("POST", "/RenumberCSV"):                 (&InstanceResource{}).RenumberCSV,
+		NewUri("POST", "/RenewIP"):                     (&InstanceResource{}).RenewIP,
+		NewUri("POST", "/ExpiringIPs"):                 (&InstanceResource{}).ExpiringIPs,
+		NewUri("POST", "/EditPrefixQuarantine"):        (&InstanceResource{}).EditPrefixQuarantine,
+		NewUri("POST", "/QuarantinedIPs"):              (&InstanceResource{}).QuarantinedIPs,
+		NewUri("POST", "/ReleaseQuarantined"):          (&InstanceResource{}).ReleaseQuarantined,
+		NewUri("GET", "/Locks"):                        (&InstanceResource{}).Locks,
+		NewUri("POST", "/CheckConsistency"):            (&InstanceResource{}).CheckConsistency,
+		NewUri("POST", "/PrefixAt"):                    (&InstanceResource{}).PrefixAt,
+		NewUri("POST", "/IPHistory"):                   (&InstanceResource{}).IPHistory,
+		NewUri("POST", "/ArchivedPrefixes"):            (&InstanceResource{}).ArchivedPrefixes,
+		NewUri("POST", "/RestoreArchivedPrefix"):       (&InstanceResource{}).RestoreArchivedPrefix,
+		NewUri("POST", "/PurgeArchivedPrefixes"):       (&InstanceResource{}).PurgeArchivedPrefixes,
 	}
 }
 
@@ -72,11 +106,17 @@ type CidrInfo struct {
 
 // 创建prefix
 type CreatePrefixReq struct {
-	Cidr    string `json:"cidr"`
-	Gateway string `json:"gateway"`
-	VlanID  int    `json:"vlanid"`
-	VRF     string `json:"vrf"` //VRF
-	IDC     string `json:"idc"` //IDC
+	Cidr       string   `json:"cidr"`
+	Gateway    string   `json:"gateway"`
+	VlanID     int      `json:"vlanid"`
+	VRF        string   `json:"vrf"`        //VRF
+	IDC        string   `json:"idc"`        //IDC
+	Strategy   string   `json:"strategy"`   //分配策略
+	Kind       string   `json:"kind"`       //网段类型 subnet(默认), p2p, host, loopbackpool
+	Template   string   `json:"template"`   //网段模板
+	IsParent   bool     `json:"isparent"`   //父网段(地址池),只用于划分子网段
+	Tags       []string `json:"tags"`       //标签,按标签选择父网段
+	Quarantine string   `json:"quarantine"` //释放的地址隔离多久后才能再分配,如30m,24h,为空时不隔离
 }
 type CreatePrefixRes struct {
 	OK int `json:"ok"`
@@ -85,9 +125,30 @@ type CreatePrefixRes struct {
 // 申请ip
 type AcquireIPReq struct {
 	Cidr        string `json:"cidr"`
+	IDC         string `json:"idc"` //IDC
+	VRF         string `json:"vrf"` //VRF
 	Description string `json:"description"`
 	Num         int    `json:"num"`
 	User        string `json:"user"`
+	Strategy    string `json:"strategy"`   //分配策略,为空时使用网段的策略
+	Contiguous  bool   `json:"contiguous"` //分配连续地址
+	Align       bool   `json:"align"`      //连续地址按2的幂对齐
+	Range       string `json:"range"`      //从指定的保留地址段中分配
+	MAC         string `json:"mac"`        //按MAC生成EUI-64地址,仅限ipv6 /64网段
+	Expires     string `json:"expires"`    //到期时间,格式2006-01-02 15:04:05,为空时永不到期
+	RequestID   string `json:"requestid"`  //幂等键,重复请求返回第一次分配的地址
+	Hostname    string `json:"hostname"`   //主机名,没有幂等键时作为幂等键,同一主机重复请求返回已分配的地址
+}
+
+// 幂等键,主机名加前缀避免和请求id冲突
+func (req AcquireIPReq) requestID() string {
+	if req.RequestID != "" {
+		return req.RequestID
+	}
+	if req.Hostname != "" {
+		return "hostname:" + req.Hostname
+	}
+	return ""
 }
 
 type AcquireIPRes struct {
@@ -98,21 +159,61 @@ type AcquireIPRes struct {
 // 获取prefix详细信息
 type GetPrefixReq struct {
 	Cidr string `json:"cidr"`
+	IDC  string `json:"idc"` //IDC
+	VRF  string `json:"vrf"` //VRF
 }
 
 type GetPrefixRes struct {
 	Prefix goipam.Prefix `json:"prefix"`
+	Usage  UsageInfo     `json:"usage"`
+}
+
+// 网段使用情况,ipv6的地址数超出json数字的精度,数量都以字符串返回
+type UsageInfo struct {
+	AvailableIPs              string           `json:"availableips"`
+	AcquiredIPs               string           `json:"acquiredips"`
+	AvailableSmallestPrefixes string           `json:"availablesmallestprefixes"`
+	AvailablePrefixes         []string         `json:"availableprefixes"`
+	AcquiredPrefixes          string           `json:"acquiredprefixes"`
+	ReservedIPs               string           `json:"reservedips"`
+	Ranges                    []RangeUsageInfo `json:"ranges"`
+}
+
+type RangeUsageInfo struct {
+	Name        string `json:"name"`
+	Size        string `json:"size"`
+	AcquiredIPs string `json:"acquiredips"`
+}
+
+func usageInfo(u goipam.Usage) UsageInfo {
+	info := UsageInfo{
+		AvailableIPs:              u.AvailableIPs.String(),
+		AcquiredIPs:               u.AcquiredIPs.String(),
+		AvailableSmallestPrefixes: u.AvailableSmallestPrefixes.String(),
+		AvailablePrefixes:         u.AvailablePrefixes,
+		AcquiredPrefixes:          strconv.FormatUint(u.AcquiredPrefixes, 10),
+		ReservedIPs:               u.ReservedIPs.String(),
+		Ranges:                    []RangeUsageInfo{},
+	}
+	for _, r := range u.Ranges {
+		info.Ranges = append(info.Ranges, RangeUsageInfo{r.Name, r.Size.String(), r.AcquiredIPs.String()})
+	}
+	return info
 }
 
 // 释放ip
 type ReleaseIPReq struct {
 	Cidr   string   `json:"cidr"`
+	IDC    string   `json:"idc"` //IDC
+	VRF    string   `json:"vrf"` //VRF
 	IPList []string `json:"iplist"`
 }
 
 // 删除Prefix
 type DeletePrefixReq struct {
 	Cidr string `json:"cidr"`
+	IDC  string `json:"idc"` //IDC
+	VRF  string `json:"vrf"` //VRF
 }
 
 type DeletePrefixRes struct {
@@ -141,6 +242,8 @@ type IPInfo struct {
 // MarkIP请求信息
 type MarkIPReq struct {
 	Cidr        string   `json:"cidr"`
+	IDC         string   `json:"idc"` //IDC
+	VRF         string   `json:"vrf"` //VRF
 	Ips         []string `json:"ips"`
 	User        string   `json:"user"`
 	Description string   `json:"description"`
@@ -164,15 +267,14 @@ func (*InstanceResource) GetIP(c *gin.Context) {
 		}
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		cidrs, err := ipam.ReadAllPrefixCidrs(ctx)
+		prefixes, err := ipam.ReadAllPrefixes(ctx)
 		if err != nil {
 			logging.Error("获取cidrs 失败", err)
 			resp.Render(c, 200, nil, errors.New("获取cidrs 失败"))
 			return
 		}
 		var items []IPInfo
-		for _, v := range cidrs {
-			p := ipam.PrefixFrom(ctx, v)
+		for _, p := range prefixes {
 			for k, v := range p.Ips {
 				if v.User == req.User {
 					items = append(items, IPInfo{
@@ -209,7 +311,7 @@ func (*InstanceResource) MarkIP(c *gin.Context) {
 			resp.Render(c, 200, nil, errors.New("参数不能为空"))
 			return
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
 		defer cancel()
 		res, err := ipam.MarkIP(ctx, req.Cidr, goipam.IPDetail{Operator: username, User: req.User, Description: req.Description, Date: tools.DateToString()}, req.Ips)
 		logging.Error(err)
@@ -230,7 +332,7 @@ func (*InstanceResource) CidrsList(c *gin.Context) {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	cidrs, err := ipam.ReadAllPrefixCidrs(ctx)
+	prefixes, err := ipam.ReadAllPrefixes(ctx)
 	m := make(map[string]map[string][]string)
 	idcs := idc.IDCINFO
 	for _, i := range idcs {
@@ -240,10 +342,12 @@ func (*InstanceResource) CidrsList(c *gin.Context) {
 		}
 		m[i.IDCName] = v_n
 	}
-	for _, v := range cidrs {
-		prefix := ipam.PrefixFrom(ctx, v)
+	for _, prefix := range prefixes {
+		if m[prefix.IDC] == nil {
+			m[prefix.IDC] = map[string][]string{}
+		}
 		cs := m[prefix.IDC][prefix.VRF]
-		m[prefix.IDC][prefix.VRF] = append(cs, v)
+		m[prefix.IDC][prefix.VRF] = append(cs, prefix.Cidr)
 	}
 	if err != nil {
 		logging.Error("获取cidrs 失败", err)
@@ -265,15 +369,14 @@ func (*InstanceResource) CidrsInfo(c *gin.Context) {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	cidrs, err := ipam.ReadAllPrefixCidrs(ctx)
+	prefixes, err := ipam.ReadAllPrefixes(ctx)
 	if err != nil {
 		logging.Error("获取cidrs 失败", err)
 		resp.Render(c, 200, nil, errors.New("获取cidrs 失败"))
 		return
 	}
 	var items []CidrInfo
-	for _, v := range cidrs {
-		p := ipam.PrefixFrom(ctx, v)
+	for _, p := range prefixes {
 		items = append(items, CidrInfo{
 			p.Cidr,
 			p.Gateway,
@@ -282,7 +385,7 @@ func (*InstanceResource) CidrsInfo(c *gin.Context) {
 			p.VRF,
 			p.IDC,
 			p.IsParent,
-			strconv.FormatUint(p.Usage().AcquiredIPs, 10),
+			p.Usage().AcquiredIPs.String(),
 		})
 	}
 	resp.Render(c, 200, CidrsInfoRes{items}, nil)
@@ -304,15 +407,15 @@ func (*InstanceResource) GetPrefix(c *gin.Context) {
 			return
 		}
 		logging.Debug(req)
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
 		defer cancel()
 		p := ipam.PrefixFrom(ctx, req.Cidr)
 		if p != nil {
-			arp(req.Cidr, p.IDC, p.VlanID)
+			arp(req.Cidr, p.IDC, p.VRF, p.VlanID)
 			a := ipam.PrefixFrom(ctx, req.Cidr)
 			if a != nil {
 				logging.Debug(*a)
-				resp.Render(c, 200, GetPrefixRes{*a}, nil)
+				resp.Render(c, 200, GetPrefixRes{*a, usageInfo(a.Usage())}, nil)
 				return
 			}
 		}
@@ -333,7 +436,9 @@ func (*InstanceResource) CreatePrefix(c *gin.Context) {
 	var req CreatePrefixReq
 	if c.ShouldBind(&req) == nil {
 		logging.Debug(req)
-		if req.Cidr == "" || req.IDC == "" || req.Gateway == "" || req.VlanID == 0 || req.VRF == "" {
+		// 点对点,主机路由,loopback地址池和父网段不需要网关和vlan
+		subnet := (req.Kind == "" || req.Kind == goipam.KindSubnet) && !req.IsParent
+		if req.Cidr == "" || req.IDC == "" || req.VRF == "" || subnet && (req.Gateway == "" || req.VlanID == 0) {
 			resp.Render(c, 200, nil, errors.New("参数不能为空"))
 			return
 		}
@@ -343,14 +448,40 @@ func (*InstanceResource) CreatePrefix(c *gin.Context) {
 			return
 		}
 		IP, err := netip.ParseAddr(req.Gateway)
-		if err != nil || !ipnet.Contains(IP) {
+		if req.Gateway != "" && (err != nil || !ipnet.Contains(IP)) {
 			resp.Render(c, 200, nil, errors.New("gateway 输入有错误"))
 			return
 		} else {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if _, err := goipam.ParseStrategy(req.Strategy); err != nil {
+				resp.Render(c, 200, nil, err)
+				return
+			}
+			if _, err := goipam.ParseQuarantine(req.Quarantine); err != nil {
+				resp.Render(c, 200, nil, err)
+				return
+			}
+			t := template.Template{}
+			if req.Template != "" {
+				t.Name = req.Template
+				if err := t.GetTemplate(); err != nil {
+					resp.Render(c, 200, nil, err)
+					return
+				}
+				if err := goipam.CheckTemplate(t.Template, req.Cidr); err != nil {
+					resp.Render(c, 200, nil, err)
+					return
+				}
+			}
+			// 策略,标签,冷却期和模板与网段在同一个事务中创建,有一项失败时不创建网段
+			opts := goipam.PrefixOptions{Strategy: req.Strategy, Tags: req.Tags, Quarantine: req.Quarantine}
+			if req.Template != "" {
+				opts.Template = &t.Template
+			}
+			ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
 			defer cancel()
-			_, err := ipam.NewPrefix(ctx, req.Cidr, req.Gateway, "", req.VlanID, req.VRF, req.IDC, false)
+			_, err := ipam.NewPrefixWithOptions(ctx, req.Cidr, req.Gateway, "", req.VlanID, req.VRF, req.IDC, req.IsParent, req.Kind, opts)
 			if err != nil {
+				logging.Error(err)
 				resp.Render(c, 200, nil, err)
 				return
 			}
@@ -377,12 +508,22 @@ func (*InstanceResource) AcquireIP(c *gin.Context) {
 			resp.Render(c, 200, nil, errors.New("用户或描述不能为空"))
 			return
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		// 数量在调用ipam之前检查,避免一次申请过多地址
+		if req.Num <= 0 || req.Num > goipam.MaxAcquireIPs {
+			logging.Error("申请数量超出范围", req.Num)
+			resp.Render(c, 200, nil, fmt.Errorf("申请数量需要在1到%d之间", goipam.MaxAcquireIPs))
+			return
+		}
+		if _, err := goipam.ParseExpires(req.Expires); err != nil {
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
 		defer cancel()
 		p := ipam.PrefixFrom(ctx, req.Cidr)
 		if p != nil {
-			arp(req.Cidr, p.IDC, p.VlanID)
-			ips, err := ipam.AcquireIP(ctx, req.Cidr, goipam.IPDetail{Operator: username, User: req.User, Description: req.Description, Date: tools.DateToString()}, req.Num)
+			arp(req.Cidr, p.IDC, p.VRF, p.VlanID)
+			ips, err := ipam.AcquireIP(ctx, req.Cidr, goipam.IPDetail{Operator: username, User: req.User, Description: req.Description, Date: tools.DateToString(), Expires: req.Expires}, req.Num, goipam.AcquireIPOptions{Strategy: req.Strategy, Contiguous: req.Contiguous, Align: req.Align, Range: req.Range, MAC: req.MAC, RequestID: req.requestID()})
 			if err != nil {
 				logging.Error(err)
 				resp.Render(c, 200, nil, err)
@@ -413,7 +554,7 @@ func (*InstanceResource) ReleaseIP(c *gin.Context) {
 			resp.Render(c, 200, nil, errors.New("网段或ips不能为空"))
 			return
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
 		defer cancel()
 		if res, err := ipam.ReleaseIPFromPrefix(ctx, req.Cidr, req.IPList); err != nil {
 			logging.Error(err)
@@ -431,6 +572,8 @@ func (*InstanceResource) ReleaseIP(c *gin.Context) {
 
 // 修改用户ip请求数据
 type EditIPReq struct {
+	IDC    string              `json:"idc"` //IDC
+	VRF    string              `json:"vrf"` //VRF
 	User   string              `json:"user"`
 	IPList map[string][]string `json:"iplist"`
 }
@@ -438,6 +581,8 @@ type EditIPReq struct {
 // 修改描述ip请求数据
 type EditDescriptionReq struct {
 	Cidr        string `json:"cidr"`
+	IDC         string `json:"idc"` //IDC
+	VRF         string `json:"vrf"` //VRF
 	Description string `json:"description"`
 	IP          string `json:"ip"`
 }
@@ -460,7 +605,7 @@ func (*InstanceResource) EditIPUserFromPrefix(c *gin.Context) {
 		}
 		var err error
 		for k, v := range req.IPList {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
 			defer cancel()
 			if err = ipam.EditIPUserFromPrefix(ctx, k, req.User, v); err != nil {
 				logging.Debug(err)
@@ -491,7 +636,7 @@ func (*InstanceResource) EditIPDescriptionFromPrefix(c *gin.Context) {
 			resp.Render(c, 200, nil, errors.New("描述不能为空和iplist都不能为空"))
 			return
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
 		defer cancel()
 		if err := ipam.EditIPDescriptionFromPrefix(ctx, req.Cidr, req.Description, req.IP); err != nil {
 			logging.Debug(err)
@@ -503,6 +648,103 @@ func (*InstanceResource) EditIPDescriptionFromPrefix(c *gin.Context) {
 	return
 }
 
+// 修改网段分配策略请求数据
+type EditPrefixStrategyReq struct {
+	Cidr     string `json:"cidr"`
+	IDC      string `json:"idc"`      //IDC
+	VRF      string `json:"vrf"`      //VRF
+	Strategy string `json:"strategy"` //firstfree, lastfree, random, sparse, skipfirst:N
+}
+
+// 修改网段分配策略
+func (*InstanceResource) EditPrefixStrategy(c *gin.Context) {
+	method := "EditPrefixStrategy"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req EditPrefixStrategyReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" {
+			resp.Render(c, 200, nil, errors.New("网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		if err := ipam.EditPrefixStrategy(ctx, req.Cidr, req.Strategy); err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+	}
+	resp.Render(c, 200, CreatePrefixRes{1}, nil)
+	return
+}
+
+// 保留地址段请求数据
+type ReservedRangeReq struct {
+	Cidr string `json:"cidr"`
+	IDC  string `json:"idc"` //IDC
+	VRF  string `json:"vrf"` //VRF
+	goipam.ReservedRange
+}
+
+// 添加保留地址段(dhcp地址池,交换机地址等)
+func (*InstanceResource) AddReservedRange(c *gin.Context) {
+	method := "AddReservedRange"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req ReservedRangeReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" || req.Name == "" || req.From == "" || req.To == "" {
+			resp.Render(c, 200, nil, errors.New("参数不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		if err := ipam.AddReservedRange(ctx, req.Cidr, req.ReservedRange); err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+	}
+	resp.Render(c, 200, CreatePrefixRes{1}, nil)
+	return
+}
+
+// 删除保留地址段
+func (*InstanceResource) DeleteReservedRange(c *gin.Context) {
+	method := "DeleteReservedRange"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req ReservedRangeReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" || req.Name == "" {
+			resp.Render(c, 200, nil, errors.New("参数不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		if err := ipam.DeleteReservedRange(ctx, req.Cidr, req.Name); err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+	}
+	resp.Render(c, 200, CreatePrefixRes{1}, nil)
+	return
+}
+
 // 删除网段
 func (*InstanceResource) DeletePrefix(c *gin.Context) {
 	method := "DeletePrefix"
@@ -514,7 +756,7 @@ func (*InstanceResource) DeletePrefix(c *gin.Context) {
 	var req DeletePrefixReq
 	if c.Bind(&req) == nil {
 		logging.Debug(req)
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
 		defer cancel()
 		_, err := ipam.DeletePrefix(ctx, req.Cidr)
 		if err != nil {
@@ -527,8 +769,8 @@ func (*InstanceResource) DeletePrefix(c *gin.Context) {
 	return
 }
 
-// mongo存储初始化
-func init() {
+// mongo存储初始化,需要在读取配置文件之后调用
+func InitStorage(cfg *conf.Config) {
 	ctx := context.Background()
 	opts := options.Client()
 	opts.ApplyURI(fmt.Sprintf(`mongodb://%s:%s`, "192.168.152.92", "27017"))
@@ -541,16 +783,35 @@ func init() {
 	c := goipam.MongoConfig{
 		DatabaseName:       `ipam`,
 		CollectionName:     `prefixes`,
+		IPCollectionName:   cfg.Storage.IPCollection,
+		HistoryRetention:   time.Duration(cfg.History.Retention) * 24 * time.Hour,
 		MongoClientOptions: opts,
+		OnHistoryError: func(err error) {
+			logging.Error("记录网段历史失败", err)
+		},
 	}
+	// 网段在事务中修改,mongo需要是副本集(单节点副本集即可)或分片集群,单机部署时启动失败
 	Storage, err := goipam.NewMongo(ctx, c)
 	if err != nil {
-		logging.Error("数据库连接失败")
+		logging.Fatal("数据库连接失败", err)
+	}
+	auditLog, err = audit.NewMongo(ctx, goipam.MongoConfig{
+		DatabaseName:       `ipam`,
+		CollectionName:     `audit`,
+		MongoClientOptions: opts,
+	})
+	if err != nil {
+		logging.Error("审计日志数据库连接失败,只记录在内存中", err)
+		auditLog = audit.NewMemory()
 	}
-	ipam = goipam.NewWithStorage(Storage)
+	auditIpam = audit.NewIpamer(goipam.NewWithStorage(Storage), auditLog)
+	auditIpam.OnError = func(e audit.Entry, err error) {
+		logging.Error("记录审计日志失败", e.Operator, e.Operation, e.Cidrs, err)
+	}
+	ipam = auditIpam
 }
 
-func arp(cidr string, idcname string, vlanid int) {
+func arp(cidr string, idcname string, vrf string, vlanid int) {
 	if !conf.Conf.Arp.Onoff {
 		return
 	}
@@ -573,7 +834,7 @@ func arp(cidr string, idcname string, vlanid int) {
 							logging.Error(err)
 							continue
 						}
-						ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+						ctx, cancel := namespaceContext(nil, idcname, vrf)
 						defer cancel()
 
 						zp := regexp.MustCompile(`\s+`)
@@ -584,8 +845,7 @@ func arp(cidr string, idcname string, vlanid int) {
 							if ll > 30 {
 								lineData := zp.Split(line, -1)
 								if _, err := netip.ParseAddr(lineData[0]); err == nil {
-									_, ok := prefix.Ips[lineData[0]]
-									if ok {
+									if prefix.Acquired(lineData[0]) {
 										continue
 									} else {
 										ips = append(ips, lineData[0])
@@ -603,10 +863,629 @@ func arp(cidr string, idcname string, vlanid int) {
 		return
 	}
 	ips = tools.RemoveDuplicateString(ips)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := namespaceContext(nil, idcname, vrf)
 	defer cancel()
 	if _, err := ipam.MarkIP(ctx, cidr, goipam.IPDetail{Operator: "networkMan", User: "arp", Description: "arp scan", Date: tools.DateToString()}, ips); err != nil {
 		logging.Debug("arp ", "标记失败", err)
 		return
 	}
 }
+
+// 带命名空间(机房+VRF)的上下文,审计日志的操作员取自c的登录用户,c为空时记为system
+func namespaceContext(c *gin.Context, idc, vrf string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx = audit.NewContextWithOperator(ctx, operator(c))
+	return goipam.NewContextWithNamespace(ctx, goipam.Namespace{IDC: idc, VRF: vrf}), cancel
+}
+
+// 申请loopback地址
+type AcquireLoopbackReq struct {
+	Cidr        string `json:"cidr"` //loopback地址池
+	IDC         string `json:"idc"`  //IDC
+	VRF         string `json:"vrf"`  //VRF
+	Description string `json:"description"`
+	User        string `json:"user"`
+}
+
+type AcquireLoopbackRes struct {
+	Prefix goipam.Prefix `json:"prefix"`
+}
+
+// 从loopback地址池申请一个/32(/128)主机路由
+func (*InstanceResource) AcquireLoopback(c *gin.Context) {
+	method := "AcquireLoopback"
+	logging.Info("开始", method)
+	username, ok := tools.FunAuth(c, modelIPAM, method)
+	if !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req AcquireLoopbackReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" || req.Description == "" || req.User == "" {
+			resp.Render(c, 200, nil, errors.New("网段,用户或描述不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		p, err := ipam.AcquireLoopback(ctx, req.Cidr, goipam.IPDetail{Operator: username, User: req.User, Description: req.Description, Date: tools.DateToString()})
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, AcquireLoopbackRes{*p}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 释放loopback地址
+type ReleaseLoopbackReq struct {
+	Cidr string `json:"cidr"` //loopback主机路由
+	IDC  string `json:"idc"`  //IDC
+	VRF  string `json:"vrf"`  //VRF
+}
+
+// 删除loopback主机路由并释放地址池中的地址
+func (*InstanceResource) ReleaseLoopback(c *gin.Context) {
+	method := "ReleaseLoopback"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req ReleaseLoopbackReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" {
+			resp.Render(c, 200, nil, errors.New("网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		if err := ipam.ReleaseLoopback(ctx, req.Cidr); err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+	}
+	resp.Render(c, 200, CreatePrefixRes{1}, nil)
+}
+
+// 网段层级
+type PrefixTreeReq struct {
+	IDC string `json:"idc"` //IDC
+	VRF string `json:"vrf"` //VRF
+}
+
+type PrefixTreeNode struct {
+	Cidr        string            `json:"cidr"`
+	Gateway     string            `json:"gateway"`
+	VlanID      int               `json:"vlanid"`
+	Kind        string            `json:"kind"`
+	IsParent    bool              `json:"isparent"`
+	ParentCidr  string            `json:"parentcidr"`
+	AcquiredIPs string            `json:"acquiredips"`
+	Children    []*PrefixTreeNode `json:"children"`
+}
+
+func prefixTreeNodes(nodes []*goipam.PrefixNode) []*PrefixTreeNode {
+	res := []*PrefixTreeNode{}
+	for _, n := range nodes {
+		p := n.Prefix
+		res = append(res, &PrefixTreeNode{
+			Cidr:        p.Cidr,
+			Gateway:     p.Gateway,
+			VlanID:      p.VlanID,
+			Kind:        p.Kind,
+			IsParent:    p.IsParent,
+			ParentCidr:  p.ParentCidr,
+			AcquiredIPs: p.Usage().AcquiredIPs.String(),
+			Children:    prefixTreeNodes(n.Children),
+		})
+	}
+	return res
+}
+
+// 获取IDC/VRF下的网段层级
+func (*InstanceResource) PrefixTree(c *gin.Context) {
+	method := "PrefixTree"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req PrefixTreeReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.IDC == "" || req.VRF == "" {
+			resp.Render(c, 200, nil, errors.New("IDC或VRF不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		nodes, err := ipam.PrefixTree(ctx)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, prefixTreeNodes(nodes), nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 从父网段划分子网段
+type AcquireChildPrefixReq struct {
+	ParentCidr string `json:"parentcidr"` //父网段
+	IDC        string `json:"idc"`        //IDC
+	VRF        string `json:"vrf"`        //VRF
+	Length     uint8  `json:"length"`     //子网段掩码长度,划分下一个空闲的子网段
+	Cidr       string `json:"cidr"`       //指定的子网段
+}
+
+type ChildPrefixRes struct {
+	Prefix            goipam.Prefix `json:"prefix"`
+	AvailablePrefixes []string      `json:"availableprefixes"` //父网段剩余可用网段
+}
+
+// 父网段剩余可用网段
+func availablePrefixes(ctx context.Context, parentCidr string) []string {
+	parent := ipam.PrefixFrom(ctx, parentCidr)
+	if parent == nil {
+		return []string{}
+	}
+	return parent.Usage().AvailablePrefixes
+}
+
+// 划分下一个空闲的子网段
+func (*InstanceResource) AcquireChildPrefix(c *gin.Context) {
+	method := "AcquireChildPrefix"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req AcquireChildPrefixReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.ParentCidr == "" || req.Length == 0 {
+			resp.Render(c, 200, nil, errors.New("父网段或掩码长度不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		child, err := ipam.AcquireChildPrefix(ctx, req.ParentCidr, req.Length)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, ChildPrefixRes{*child, availablePrefixes(ctx, req.ParentCidr)}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 划分指定的子网段
+func (*InstanceResource) AcquireSpecificChildPrefix(c *gin.Context) {
+	method := "AcquireSpecificChildPrefix"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req AcquireChildPrefixReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.ParentCidr == "" || req.Cidr == "" {
+			resp.Render(c, 200, nil, errors.New("父网段或子网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		child, err := ipam.AcquireSpecificChildPrefix(ctx, req.ParentCidr, req.Cidr)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, ChildPrefixRes{*child, availablePrefixes(ctx, req.ParentCidr)}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 释放子网段
+type ReleaseChildPrefixReq struct {
+	Cidr string `json:"cidr"` //子网段
+	IDC  string `json:"idc"`  //IDC
+	VRF  string `json:"vrf"`  //VRF
+}
+
+// 释放子网段,子网段中不能有已分配的ip
+func (*InstanceResource) ReleaseChildPrefix(c *gin.Context) {
+	method := "ReleaseChildPrefix"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req ReleaseChildPrefixReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" {
+			resp.Render(c, 200, nil, errors.New("网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		child := ipam.PrefixFrom(ctx, req.Cidr)
+		if child == nil {
+			resp.Render(c, 200, nil, errors.New("网段不存在"))
+			return
+		}
+		if err := ipam.ReleaseChildPrefix(ctx, child); err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, ChildPrefixRes{*child, availablePrefixes(ctx, child.ParentCidr)}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 从IDC/VRF下所有父网段中划分子网段
+type AcquireChildPrefixFromPoolReq struct {
+	IDC       string   `json:"idc"`       //IDC
+	VRF       string   `json:"vrf"`       //VRF
+	Tags      []string `json:"tags"`      //父网段需要带有的标签
+	Length    uint8    `json:"length"`    //子网段掩码长度
+	Placement string   `json:"placement"` //bestfit(默认), firstfit
+}
+
+// 不需要指定父网段,由服务端在符合条件的父网段中选择
+func (*InstanceResource) AcquireChildPrefixFromPool(c *gin.Context) {
+	method := "AcquireChildPrefixFromPool"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req AcquireChildPrefixFromPoolReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.IDC == "" || req.VRF == "" || req.Length == 0 {
+			resp.Render(c, 200, nil, errors.New("IDC,VRF或掩码长度不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		child, err := ipam.AcquireChildPrefixFromPool(ctx, goipam.PoolSelector{Tags: req.Tags, Placement: req.Placement}, req.Length)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, ChildPrefixRes{*child, availablePrefixes(ctx, child.ParentCidr)}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 修改网段标签
+type EditPrefixTagsReq struct {
+	Cidr string   `json:"cidr"`
+	IDC  string   `json:"idc"`  //IDC
+	VRF  string   `json:"vrf"`  //VRF
+	Tags []string `json:"tags"` //标签
+}
+
+func (*InstanceResource) EditPrefixTags(c *gin.Context) {
+	method := "EditPrefixTags"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req EditPrefixTagsReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" {
+			resp.Render(c, 200, nil, errors.New("网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		if err := ipam.EditPrefixTags(ctx, req.Cidr, req.Tags); err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+	}
+	resp.Render(c, 200, CreatePrefixRes{1}, nil)
+}
+
+// 拆分网段
+type SplitPrefixReq struct {
+	Cidr   string `json:"cidr"`   //网段,不能有子网段
+	IDC    string `json:"idc"`    //IDC
+	VRF    string `json:"vrf"`    //VRF
+	Length uint8  `json:"length"` //拆分后的掩码长度
+}
+
+// 把网段拆分为多个等长网段,已分配的ip和保留范围随之迁移
+func (*InstanceResource) SplitPrefix(c *gin.Context) {
+	method := "SplitPrefix"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req SplitPrefixReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" || req.Length == 0 {
+			resp.Render(c, 200, nil, errors.New("网段或掩码长度不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		prefixes, err := ipam.SplitPrefix(ctx, req.Cidr, req.Length)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, prefixes, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 合并网段
+type MergePrefixesReq struct {
+	Cidrs []string `json:"cidrs"` //相邻的同级网段,合并后必须正好是一个网段
+	IDC   string   `json:"idc"`   //IDC
+	VRF   string   `json:"vrf"`   //VRF
+}
+
+// 把相邻的同级网段合并为一个网段,网关和vlan取地址最小的网段
+func (*InstanceResource) MergePrefixes(c *gin.Context) {
+	method := "MergePrefixes"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req MergePrefixesReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if len(req.Cidrs) < 2 {
+			resp.Render(c, 200, nil, errors.New("至少需要两个网段"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		prefix, err := ipam.MergePrefixes(ctx, req.Cidrs)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, prefix, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 扩容或缩容网段
+type ResizePrefixReq struct {
+	Cidr   string `json:"cidr"`   //网段,不能有子网段
+	IDC    string `json:"idc"`    //IDC
+	VRF    string `json:"vrf"`    //VRF
+	Length uint8  `json:"length"` //新的掩码长度,网络地址不变
+}
+
+// 修改网段掩码长度,已分配的ip或保留范围落在新网段之外时拒绝
+func (*InstanceResource) ResizePrefix(c *gin.Context) {
+	method := "ResizePrefix"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req ResizePrefixReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" || req.Length == 0 {
+			resp.Render(c, 200, nil, errors.New("网段或掩码长度不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		prefix, err := ipam.ResizePrefix(ctx, req.Cidr, req.Length)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, prefix, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 网段迁移
+type RenumberReq struct {
+	IDC     string `json:"idc"`     //IDC
+	VRF     string `json:"vrf"`     //VRF
+	OldCidr string `json:"oldcidr"` //旧网段
+	NewCidr string `json:"newcidr"` //新网段,需要先创建
+}
+
+// 生成旧网段到新网段的地址映射,尽量保持主机位不变,不做任何修改
+func (*InstanceResource) PlanRenumber(c *gin.Context) {
+	method := "PlanRenumber"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req RenumberReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.OldCidr == "" || req.NewCidr == "" {
+			resp.Render(c, 200, nil, errors.New("旧网段或新网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		plan, err := ipam.PlanRenumber(ctx, req.OldCidr, req.NewCidr)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, plan, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 执行网段迁移
+type ApplyRenumberReq struct {
+	IDC  string              `json:"idc"`  //IDC
+	VRF  string              `json:"vrf"`  //VRF
+	Plan goipam.RenumberPlan `json:"plan"` //PlanRenumber返回的映射,可以修改新地址
+}
+
+// 一次性在新网段分配映射中的所有地址,旧地址保留到RetireRenumber为止
+// 映射需要包含旧网段的所有已分配地址,迁移期间旧网段不能再分配地址
+func (*InstanceResource) ApplyRenumber(c *gin.Context) {
+	method := "ApplyRenumber"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req ApplyRenumberReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Plan.OldCidr == "" || req.Plan.NewCidr == "" {
+			resp.Render(c, 200, nil, errors.New("旧网段或新网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		if err := ipam.ApplyRenumber(ctx, req.Plan); err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, CreatePrefixRes{1}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 结束网段迁移,释放旧网段的地址并删除旧网段
+func (*InstanceResource) RetireRenumber(c *gin.Context) {
+	method := "RetireRenumber"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req RenumberReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.OldCidr == "" {
+			resp.Render(c, 200, nil, errors.New("旧网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		prefix, err := ipam.RetireRenumber(ctx, req.OldCidr)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, prefix, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 取消网段迁移,释放新网段中迁移过来的地址
+func (*InstanceResource) CancelRenumber(c *gin.Context) {
+	method := "CancelRenumber"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req RenumberReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.OldCidr == "" {
+			resp.Render(c, 200, nil, errors.New("旧网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		if err := ipam.CancelRenumber(ctx, req.OldCidr); err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, CreatePrefixRes{1}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 导出网段迁移的新旧地址对照表(csv),已执行的迁移导出实际映射,否则导出建议映射
+func (*InstanceResource) RenumberCSV(c *gin.Context) {
+	method := "RenumberCSV"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req RenumberReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.OldCidr == "" {
+			resp.Render(c, 200, nil, errors.New("旧网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		plan, err := ipam.RenumberPlanOf(ctx, req.OldCidr)
+		if err != nil && req.NewCidr != "" {
+			plan, err = ipam.PlanRenumber(ctx, req.OldCidr, req.NewCidr)
+		}
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		var buf bytes.Buffer
+		if err := plan.WriteCSV(&buf); err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		filename := strings.NewReplacer("/", "_", ":", "_").Replace(plan.OldCidr) + ".csv"
+		c.Header("Content-Disposition", "attachment; filename="+filename)
+		c.Data(200, "text/csv; charset=utf-8", buf.Bytes())
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
@@ -0,0 +1,34 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"ipam/component"
+	goipam "ipam/pkg/ipam"
+)
+
+func TestAcquireIPNum(t *testing.T) {
+	tests := []struct {
+		num  int
+		want string
+	}{
+		{num: 0, want: fmt.Sprintf("申请数量需要在1到%d之间", goipam.MaxAcquireIPs)},
+		{num: -1, want: fmt.Sprintf("申请数量需要在1到%d之间", goipam.MaxAcquireIPs)},
+		{num: goipam.MaxAcquireIPs + 1, want: fmt.Sprintf("申请数量需要在1到%d之间", goipam.MaxAcquireIPs)},
+		// 数量合法时检查网段
+		{num: 1, want: "网段不存在"},
+	}
+	for _, tt := range tests {
+		body := fmt.Sprintf(`{"cidr":"10.99.0.0/24","user":"alice","description":"test","num":%d}`, tt.num)
+		w := serve((&InstanceResource{}).AcquireIP, body, modelIPAM)
+		var res component.GokuApiResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Message != tt.want {
+			t.Errorf("got message %q for num %d, want %q", res.Message, tt.num, tt.want)
+		}
+	}
+}
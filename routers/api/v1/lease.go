@@ -0,0 +1,147 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"ipam/pkg/audit"
+	goipam "ipam/pkg/ipam"
+	"ipam/utils/logging"
+	conf "ipam/utils/options"
+	"ipam/utils/tools"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 地址续期
+type RenewIPReq struct {
+	Cidr    string `json:"cidr"`
+	IDC     string `json:"idc"`     //IDC
+	VRF     string `json:"vrf"`     //VRF
+	IP      string `json:"ip"`      //地址
+	Expires string `json:"expires"` //新的到期时间,格式2006-01-02 15:04:05,为空时改为永不到期
+}
+
+func (*InstanceResource) RenewIP(c *gin.Context) {
+	method := "RenewIP"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req RenewIPReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" || req.IP == "" {
+			resp.Render(c, 200, nil, errors.New("网段或ip不能为空"))
+			return
+		}
+		expires, err := goipam.ParseExpires(req.Expires)
+		if err != nil {
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		if err := ipam.RenewIP(ctx, req.Cidr, req.IP, expires); err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, CreatePrefixRes{1}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 即将到期的地址
+type ExpiringIPsReq struct {
+	Hours int `json:"hours"` //多少小时内到期,包含已经到期未回收的地址
+}
+
+func (*InstanceResource) ExpiringIPs(c *gin.Context) {
+	method := "ExpiringIPs"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req ExpiringIPsReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Hours <= 0 {
+			resp.Render(c, 200, nil, errors.New("小时数必须大于0"))
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		leases, err := ipam.Leases(ctx, time.Now().Add(time.Duration(req.Hours)*time.Hour))
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, leases, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 回收通知,POST到配置的webhook,没有配置时只记录日志
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// 通知内容
+type leaseNotice struct {
+	User        string `json:"user"`
+	IP          string `json:"ip"`
+	Cidr        string `json:"cidr"`
+	IDC         string `json:"idc"`
+	VRF         string `json:"vrf"`
+	Description string `json:"description"`
+	Expires     string `json:"expires"`
+}
+
+func (n webhookNotifier) Notify(ctx context.Context, l goipam.Lease) error {
+	logging.Info("回收过期地址", l.IDC, l.VRF, l.Cidr, l.IP, "使用人", l.Detail.User)
+	if n.url == "" {
+		return nil
+	}
+	body, err := json.Marshal(leaseNotice{l.Detail.User, l.IP, l.Cidr, l.IDC, l.VRF, l.Detail.Description, l.Detail.Expires})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("通知%s失败,状态码:%d", n.url, res.StatusCode)
+	}
+	return nil
+}
+
+// 启动过期地址回收,间隔为0时不回收
+func StartReaper(c conf.Lease) {
+	if c.Interval <= 0 {
+		return
+	}
+	reaper := goipam.NewReaper(ipam, time.Duration(c.Grace)*time.Hour, webhookNotifier{c.Webhook, &http.Client{Timeout: 5 * time.Second}})
+	reaper.OnError = func(l goipam.Lease, err error) {
+		logging.Error("回收地址", l.Cidr, l.IP, "失败:", err)
+	}
+	go reaper.Run(audit.NewContextWithOperator(context.Background(), "reaper"), time.Duration(c.Interval)*time.Minute)
+}
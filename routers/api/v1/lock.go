@@ -0,0 +1,37 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	goipam "ipam/pkg/ipam"
+	"ipam/utils/logging"
+	"ipam/utils/tools"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 当前持有的锁,多个ipam实例共用数据库时用来串行化网段的结构变更
+type LocksRes struct {
+	Locks []goipam.LockHolder `json:"locks"`
+}
+
+// 查看锁的持有者,用于排查请求卡住的问题
+func (*InstanceResource) Locks(c *gin.Context) {
+	method := "Locks"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	locks, err := ipam.Locks(ctx)
+	if err != nil {
+		logging.Error(err)
+		resp.Render(c, 200, nil, errors.New("获取锁失败"))
+		return
+	}
+	resp.Render(c, 200, LocksRes{locks}, nil)
+}
@@ -58,7 +58,9 @@ func (*NOTEResource) CreateNote(c *gin.Context) {
 		r := &req
 		r.Operator = username
 		r.Date = tools.DateToString()
-		if err := r.CreateNote(); err != nil {
+		err := r.CreateNote()
+		recordAudit(c, method, "", nil, r, err)
+		if err != nil {
 			logging.Info("录入数据库失败", err)
 			resp.Render(c, 200, nil, err)
 			return
@@ -85,7 +87,10 @@ func (*NOTEResource) DeleteNote(c *gin.Context) {
 			return
 		}
 		r := &req
-		if err := r.DeleteNote(); err != nil {
+		before := noteSnapshot(r.Instance)
+		err := r.DeleteNote()
+		recordAudit(c, method, "", before, nil, err)
+		if err != nil {
 			logging.Info("录入数据库失败", err)
 			resp.Render(c, 200, nil, err)
 			return
@@ -0,0 +1,121 @@
+package v1
+
+import (
+	"errors"
+
+	goipam "ipam/pkg/ipam"
+	"ipam/utils/logging"
+	"ipam/utils/tools"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 修改网段的地址隔离时间
+type EditPrefixQuarantineReq struct {
+	Cidr       string `json:"cidr"`
+	IDC        string `json:"idc"`        //IDC
+	VRF        string `json:"vrf"`        //VRF
+	Quarantine string `json:"quarantine"` //如30m,24h,为空时不再隔离新释放的地址
+}
+
+func (*InstanceResource) EditPrefixQuarantine(c *gin.Context) {
+	method := "EditPrefixQuarantine"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req EditPrefixQuarantineReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" {
+			resp.Render(c, 200, nil, errors.New("网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		if err := ipam.EditPrefixQuarantine(ctx, req.Cidr, req.Quarantine); err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, CreatePrefixRes{1}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 隔离中的地址
+type QuarantinedIPsReq struct {
+	Cidr string   `json:"cidr"`
+	IDC  string   `json:"idc"`    //IDC
+	VRF  string   `json:"vrf"`    //VRF
+	IPs  []string `json:"iplist"` //强制结束隔离的地址,为空时结束网段中所有地址的隔离
+}
+
+type QuarantinedIPsRes struct {
+	Quarantine string                 `json:"quarantine"` //网段的隔离时间
+	IPs        []goipam.QuarantinedIP `json:"iplist"`
+}
+
+// 查看网段中隔离中的地址
+func (*InstanceResource) QuarantinedIPs(c *gin.Context) {
+	method := "QuarantinedIPs"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req QuarantinedIPsReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" {
+			resp.Render(c, 200, nil, errors.New("网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		p := ipam.PrefixFrom(ctx, req.Cidr)
+		if p == nil {
+			resp.Render(c, 200, nil, errors.New("网段不存在"))
+			return
+		}
+		ips, err := ipam.QuarantinedIPs(ctx, req.Cidr)
+		if err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, QuarantinedIPsRes{p.Quarantine, ips}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
+
+// 强制结束地址隔离,地址可以立即再分配
+func (*InstanceResource) ReleaseQuarantined(c *gin.Context) {
+	method := "ReleaseQuarantined"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req QuarantinedIPsReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Cidr == "" {
+			resp.Render(c, 200, nil, errors.New("网段不能为空"))
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		if err := ipam.ReleaseQuarantined(ctx, req.Cidr, req.IPs); err != nil {
+			logging.Error(err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		resp.Render(c, 200, CreatePrefixRes{1}, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
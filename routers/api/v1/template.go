@@ -0,0 +1,152 @@
+package v1
+
+import (
+	"errors"
+	"ipam/pkg/template"
+	"ipam/utils/logging"
+	"ipam/utils/tools"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TEMPLATEResource struct {
+}
+
+// 注册路由
+func TEMPLATERouter() {
+	APIs["/template"] = map[UriInterface]interface{}{
+		NewUri("GET", "/TemplateList"):    (&TEMPLATEResource{}).TemplateList,
+		NewUri("POST", "/CreateTemplate"): (&TEMPLATEResource{}).CreateTemplate,
+		NewUri("POST", "/DeleteTemplate"): (&TEMPLATEResource{}).DeleteTemplate,
+		NewUri("POST", "/ApplyTemplate"):  (&TEMPLATEResource{}).ApplyTemplate,
+	}
+}
+
+func (*TEMPLATEResource) TemplateList(c *gin.Context) {
+	method := "TemplateList"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	t := template.Template{}
+	templates, err := t.TemplateList()
+	if err == nil && templates != nil {
+		resp.Render(c, 200, templates, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("没有模板"))
+	return
+}
+
+func (*TEMPLATEResource) CreateTemplate(c *gin.Context) {
+	method := "CreateTemplate"
+	logging.Info("开始", method)
+	username, ok := tools.FunAuth(c, modelIPAM, method)
+	if !ok {
+		logging.Info("没有权限访问")
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	//获取前端数据
+	var req template.Template
+	if c.ShouldBind(&req) == nil {
+		if req.Name == "" || len(req.Rules) == 0 {
+			logging.Error("模板名称或规则不能为空")
+			resp.Render(c, 200, nil, errors.New("模板名称或规则不能为空"))
+			return
+		}
+		r := &req
+		r.Operator = username
+		r.Date = tools.DateToString()
+		if err := r.CreateTemplate(); err != nil {
+			logging.Info("录入数据库失败", err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+	}
+	resp.Render(c, 200, nil, nil)
+	return
+}
+
+func (*TEMPLATEResource) DeleteTemplate(c *gin.Context) {
+	method := "DeleteTemplate"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		logging.Info("没有权限访问")
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	//获取前端数据
+	var req template.Template
+	if c.ShouldBind(&req) == nil {
+		if req.Name == "" {
+			logging.Error("模板名称不能为空")
+			resp.Render(c, 200, nil, errors.New("模板名称不能为空"))
+			return
+		}
+		r := &req
+		if err := r.DeleteTemplate(); err != nil {
+			logging.Info("删除模板失败", err)
+			resp.Render(c, 200, nil, err)
+			return
+		}
+	}
+	resp.Render(c, 200, nil, nil)
+	return
+}
+
+// 对已有网段应用模板
+type ApplyTemplateReq struct {
+	Template string   `json:"template"` //模板名称
+	IDC      string   `json:"idc"`      //IDC
+	VRF      string   `json:"vrf"`      //VRF
+	Cidrs    []string `json:"cidrs"`    //网段
+}
+
+type ApplyTemplateRes struct {
+	Result []ApplyTemplateResult `json:"result"`
+}
+
+type ApplyTemplateResult struct {
+	Cidr   string `json:"cidr"`
+	Result string `json:"result"`
+}
+
+// 已有地址与模板冲突的网段不做修改
+func (*TEMPLATEResource) ApplyTemplate(c *gin.Context) {
+	method := "ApplyTemplate"
+	logging.Info("开始", method)
+	if _, ok := tools.FunAuth(c, modelIPAM, method); !ok {
+		resp.Render(c, 403, nil, errors.New("没有权限访问"))
+		return
+	}
+	var req ApplyTemplateReq
+	if c.Bind(&req) == nil {
+		logging.Debug(req)
+		if req.Template == "" || len(req.Cidrs) == 0 {
+			resp.Render(c, 200, nil, errors.New("模板或网段不能为空"))
+			return
+		}
+		t := template.Template{}
+		t.Name = req.Template
+		if err := t.GetTemplate(); err != nil {
+			resp.Render(c, 200, nil, err)
+			return
+		}
+		ctx, cancel := namespaceContext(c, req.IDC, req.VRF)
+		defer cancel()
+		res := ApplyTemplateRes{}
+		for _, cidr := range req.Cidrs {
+			if err := ipam.ApplyTemplate(ctx, cidr, t.Template); err != nil {
+				logging.Error(err)
+				res.Result = append(res.Result, ApplyTemplateResult{cidr, err.Error()})
+				continue
+			}
+			res.Result = append(res.Result, ApplyTemplateResult{cidr, "应用成功"})
+		}
+		resp.Render(c, 200, res, nil)
+		return
+	}
+	resp.Render(c, 200, nil, errors.New("解析参数出错"))
+}
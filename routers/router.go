@@ -23,6 +23,8 @@ func InitRouter() *gin.Engine {
 		v1.IPAMRouter()
 		v1.IDCRouter()
 		v1.NOTERouter()
+		v1.TEMPLATERouter()
+		v1.AUDITRouter()
 	}
 
 	for key, instance := range v1.APIs {
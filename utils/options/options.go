@@ -11,8 +11,13 @@ import (
 var Conf *Config
 var configFile string
 
+// 启动参数 -fsck 检查存储的网段后退出, -repair 同时修复能自动修复的问题
+var Fsck, Repair bool
+
 func InitConfig() *Config {
 	flag.StringVar(&configFile, "conf", "./config.json", "define config file ")
+	flag.BoolVar(&Fsck, "fsck", false, "check the stored prefixes and exit")
+	flag.BoolVar(&Repair, "repair", false, "with -fsck, repair what can be repaired")
 	flag.Parse()
 	Conf = Getconfig()
 	return Conf
@@ -48,11 +53,38 @@ type Arp struct {
 	Onoff bool `json:"onoff"`
 }
 
+// 地址租期回收
+type Lease struct {
+	Interval int    `json:"interval"` //回收检查间隔,分钟,0表示不回收
+	Grace    int    `json:"grace"`    //到期后保留的时间,小时
+	Webhook  string `json:"webhook"`  //回收后通知使用人的地址,为空时只记录日志
+}
+
+// 回收站,删除的网段保留一段时间后清理
+type Archive struct {
+	Retention int `json:"retention"` //保留天数,0表示不清理
+	Interval  int `json:"interval"`  //清理检查间隔,分钟
+}
+
+// 网段历史,每次修改网段时保存的快照
+type History struct {
+	Retention int `json:"retention"` //保留天数,0表示一直保留
+}
+
+// mongo存储
+type Storage struct {
+	IPCollection string `json:"ipCollection"` //每个ip单独存储为一个文档的集合,为空时ip保存在网段的文档中
+}
+
 type Config struct {
 	Http     Http            `json:"http"`
 	Log      Log             `json:"log"`
 	UserList map[string]User `json:"userList"`
 	Arp      Arp             `json:"arp"`
+	Lease    Lease           `json:"lease"`
+	Archive  Archive         `json:"archive"`
+	History  History         `json:"history"`
+	Storage  Storage         `json:"storage"`
 }
 
 // json读取
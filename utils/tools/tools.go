@@ -53,6 +53,23 @@ func FunAuth(c *gin.Context, model, method string) (string, bool) {
 	return "", false
 }
 
+// 管理员权限认证,只有admin角色通过,模块或方法的权限不够
+func AdminAuth(c *gin.Context) (string, bool) {
+	claims, ok := c.Get("claims")
+	if !ok {
+		return "", false
+	}
+	user, ok := claims.(*component.CustomClaims)
+	if !ok {
+		logging.Debug("解析失败")
+		return "", false
+	}
+	if !IsExistItem("admin", user.Role) {
+		return "", false
+	}
+	return user.Name, true
+}
+
 // 删除切片指定元素
 func RemoveElement(slice []string, elem string) []string {
 	for i, v := range slice {